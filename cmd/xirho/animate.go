@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"runtime"
+	"time"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/encoding"
+)
+
+// animate implements the animate subcommand, which renders an animation to a
+// sequence of numbered frames.
+func animate(args []string) {
+	var inname, outname, resample, format string
+	var fps, blur float64
+	var budget xirho.Budget
+	var timeout time.Duration
+	var width, height, osa, procs int
+	fs := flag.NewFlagSet("xirho animate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: xirho animate [flags]")
+		fmt.Fprintln(fs.Output(), "Render an animation's keyframes and the frames between them to numbered PNG files.")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&inname, "in", "", "input animation json filename (default stdin)")
+	fs.StringVar(&outname, "png", "frame%05d.png", "output filename pattern, formatted with the frame number")
+	fs.Float64Var(&fps, "fps", 30, "frames per second of animation time")
+	fs.Float64Var(&blur, "blur", 0, "fraction of each frame's time to include as motion blur, 0 to 1")
+	fs.Int64Var(&budget.Iters, "iters", 0, "iterations to render per frame")
+	fs.Float64Var(&budget.SPP, "spp", 0, "samples per histogram bin to render per frame")
+	fs.DurationVar(&timeout, "dur", 0, "max duration to render each frame")
+	fs.IntVar(&width, "width", 1024, "output image width")
+	fs.IntVar(&height, "height", 1024, "output image height")
+	fs.IntVar(&osa, "osa", 1, "oversampling; histogram bins per pixel per axis")
+	fs.IntVar(&procs, "procs", runtime.GOMAXPROCS(0), "concurrent render routines")
+	fs.StringVar(&format, "format", "rgba", "image format: rgb, rgba, rgb16, or rgba16 (16 bits per channel); formats with alpha keep the background's transparency")
+	fs.StringVar(&resample, "resample", "catmull-rom", "resampling method (catmull-rom, bilinear, approx-bilinear, or nearest)")
+	fs.Parse(args)
+	if budget == (xirho.Budget{}) && timeout <= 0 {
+		log.Fatal("animate requires -iters, -spp, or -dur to bound each frame")
+	}
+	if !(fps > 0) {
+		log.Fatalln("fps must be positive, not", fps)
+	}
+	if !(blur >= 0 && blur <= 1) {
+		log.Fatalln("blur must be between 0 and 1, not", blur)
+	}
+	resampler := resamplers[resample]
+	if resampler == nil {
+		log.Fatalln("no resampler named", resample)
+	}
+	imgfmt, ok := imageFormats[format]
+	if !ok {
+		log.Fatalln("no image format named", format)
+	}
+
+	var in io.Reader = os.Stdin
+	if inname != "" {
+		f, err := os.Open(inname)
+		if err != nil {
+			log.Fatalln("error opening input:", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	d := json.NewDecoder(in)
+	d.UseNumber()
+	a, err := encoding.UnmarshalAnimation(d)
+	if err != nil {
+		log.Fatalln("error unmarshaling animation:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	go func() {
+		<-ch
+		signal.Reset(os.Interrupt)
+		cancel()
+	}()
+
+	// Allow for rounding so that a keyframe exactly on a frame is included.
+	frames := int(math.Floor((a.End()-a.Start())*fps+1e-9)) + 1
+	log.Printf("rendering %d frames at %g fps for %s each", frames, fps, describe(timeout, budget))
+	for i := 0; i < frames; i++ {
+		tm := a.Start() + float64(i)/fps
+		s, err := a.At(tm)
+		if err != nil {
+			log.Fatalln("error interpolating animation:", err)
+		}
+		r := s.Render(image.Pt(width, height), osa)
+		r.Budget = budget
+		fctx, stop := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			fctx, stop = context.WithTimeout(ctx, timeout)
+		}
+		start := time.Now()
+		if blur > 0 {
+			r.RenderMotion(fctx, a.Motion(tm, tm+blur/fps), procs)
+		} else {
+			r.Render(fctx, s.System, procs)
+		}
+		stop()
+		if ctx.Err() != nil {
+			log.Printf("interrupted at frame %d", i)
+			return
+		}
+		dur := time.Since(start)
+		log.Printf("frame %d at %.3fs: %d iters, %d hits in %v", i, tm, r.Iters(), r.Hits(), dur.Round(time.Millisecond))
+		drawpng(fmt.Sprintf(outname, i), r, &encoding.PNGInfo{System: s, Iters: r.Iters(), Duration: dur}, resampler, imgfmt)
+	}
+}
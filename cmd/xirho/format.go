@@ -0,0 +1,44 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// imageFormat is a type of output image.
+type imageFormat struct {
+	// deep selects 16 bits per channel instead of 8.
+	deep bool
+	// alpha selects whether the output keeps transparency from the
+	// background. Without alpha, the background is made opaque.
+	alpha bool
+}
+
+// imageFormats maps format names to image formats.
+var imageFormats = map[string]imageFormat{
+	"rgb":    {deep: false, alpha: false},
+	"rgba":   {deep: false, alpha: true},
+	"rgb16":  {deep: true, alpha: false},
+	"rgba16": {deep: true, alpha: true},
+}
+
+// newImage allocates an output image and fills it with the background.
+// The PNG encoder writes an alpha channel only if some pixel is not opaque,
+// so images in formats without alpha are encoded as RGB.
+func (f imageFormat) newImage(r image.Rectangle, bg color.Color) draw.Image {
+	if !f.alpha {
+		c := color.NRGBA64Model.Convert(bg).(color.NRGBA64)
+		c.A = 0xffff
+		bg = c
+	}
+	var img draw.Image
+	if f.deep {
+		img = image.NewRGBA64(r)
+	} else {
+		img = image.NewRGBA(r)
+	}
+	draw.Draw(img, r, image.NewUniform(bg), image.Point{}, draw.Src)
+	return img
+}
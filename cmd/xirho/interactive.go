@@ -26,11 +26,11 @@ import (
 	"github.com/zephyrtronium/xirho/xmath"
 )
 
-func interactive(ctx context.Context, s *encoding.System, sz hist.Size, res draw.Scaler, tm hist.ToneMap, bg color.Color, procs int) {
+func interactive(ctx context.Context, s *encoding.System, sz hist.Size, res draw.Scaler, tm hist.ToneMap, bg color.Color, format imageFormat, procs int, budget xirho.Budget) {
 	if sz.OSA <= 0 {
 		sz.OSA = 1
 	}
-	r := &xirho.Render{Hist: hist.New(sz)}
+	r := &xirho.Render{Hist: hist.New(sz), Budget: budget}
 	status := status{
 		r:      r,
 		change: make(chan xirho.ChangeRender, 1),
@@ -40,18 +40,21 @@ func interactive(ctx context.Context, s *encoding.System, sz hist.Size, res draw
 			Scale:   res,
 			ToneMap: tm,
 		},
-		bg:    image.Uniform{C: bg},
-		sz:    sz,
-		procs: procs,
+		bg:     image.Uniform{C: bg},
+		format: format,
+		sz:     sz,
+		procs:  procs,
 	}
 	if s != nil {
 		cam := s.Camera
 		c := xirho.ChangeRender{
-			System:  s.System,
-			Size:    sz,
-			Camera:  &cam,
-			Palette: s.Palette,
-			Procs:   status.procs,
+			System:     s.System,
+			Size:       sz,
+			Camera:     &cam,
+			Depth:      &s.Depth,
+			Projection: &s.Projection,
+			Palette:    s.Palette,
+			Procs:      status.procs,
 		}
 		status.change <- c
 	}
@@ -98,6 +101,7 @@ type status struct {
 	imgs   chan draw.Image
 	onto   xirho.PlotOnto
 	bg     image.Uniform
+	format imageFormat
 	sz     hist.Size
 	procs  int
 }
@@ -188,6 +192,26 @@ var commands = []*command{
 		desc: `set render gamma threshold`,
 		exec: threshold,
 	},
+	{
+		name: []string{"density", "de"},
+		desc: `set render density estimation`,
+		exec: density,
+	},
+	{
+		name: []string{"vibrancy", "vib", "v"},
+		desc: `set render vibrancy`,
+		exec: vibrancy,
+	},
+	{
+		name: []string{"highlight", "hp"},
+		desc: `set render highlight power`,
+		exec: highlight,
+	},
+	{
+		name: []string{"curve", "tc"},
+		desc: `set render tone curve`,
+		exec: curve,
+	},
 	{
 		name: []string{"scaler", "scale", "resample"},
 		desc: `set resampling method for rendering`,
@@ -233,6 +257,21 @@ var commands = []*command{
 		desc: `zoom camera in or out`,
 		exec: camzoom,
 	},
+	{
+		name: []string{"depth", "dof"},
+		desc: `set depth of field and fog`,
+		exec: camdepth,
+	},
+	{
+		name: []string{"fov", "persp"},
+		desc: `set perspective projection`,
+		exec: camfov,
+	},
+	{
+		name: []string{"lens"},
+		desc: `set camera model and stereo`,
+		exec: camlens,
+	},
 	{
 		name: []string{"eye"},
 		desc: `reset camera to a reasonable default`,
@@ -281,6 +320,16 @@ func help(ctx context.Context, status *status, line string) {
 	cols, rows := status.r.Hist.Cols(), status.r.Hist.Rows()
 	fmt.Printf("Histogram oversampled %dx, size %dx%d (%d MB)\n", status.sz.OSA, cols, rows, status.sz.Mem()>>20)
 	fmt.Printf("Plotting brightness %f, gamma %f, gamma threshold %f\n", status.onto.ToneMap.Brightness, status.onto.ToneMap.Gamma, status.onto.ToneMap.GammaMin)
+	if de := status.onto.ToneMap.Density; de.Enabled() {
+		fmt.Printf("Density estimation radius %f to %f, curve %f\n", de.MinRadius, de.MaxRadius, de.Curve)
+	}
+	fmt.Printf("Vibrancy %f, highlight power %f, tone curve %v\n", status.onto.ToneMap.Vibrancy, status.onto.ToneMap.HighlightPower, status.onto.ToneMap.Curve)
+	if d := status.r.Depth; d != (xirho.Depth{}) {
+		fmt.Printf("Depth of field focus %f, aperture %f; fog %f beyond %f\n", d.Focus, d.Aperture, d.Fog, d.FogStart)
+	}
+	if p := status.r.Projection; p != (xirho.Projection{}) {
+		fmt.Printf("Lens %v, field of view %f degrees, near clipping %f, stereo separation %f\n", p.Lens, p.FOV*180/math.Pi, p.Near, p.Stereo)
+	}
 	r, g, b, a := status.bg.C.RGBA()
 	fmt.Printf("Plot background RGBA: #%02x%02x%02x%02x\n", r>>8, g>>8, b>>8, a>>8)
 }
@@ -324,11 +373,13 @@ func open(ctx context.Context, status *status, line string) {
 	status.sz.W, status.sz.H = w, h
 	cam := s.Camera
 	c := xirho.ChangeRender{
-		System:  s.System,
-		Size:    status.sz,
-		Camera:  &cam,
-		Palette: s.Palette,
-		Procs:   status.procs,
+		System:     s.System,
+		Size:       status.sz,
+		Camera:     &cam,
+		Depth:      &s.Depth,
+		Projection: &s.Projection,
+		Palette:    s.Palette,
+		Procs:      status.procs,
 	}
 	select {
 	case <-ctx.Done():
@@ -377,11 +428,13 @@ func flam3(ctx context.Context, status *status, line string) {
 	status.sz.W, status.sz.H = w, h
 	cam := s.Camera
 	c := xirho.ChangeRender{
-		System:  s.System,
-		Size:    status.sz,
-		Camera:  &cam,
-		Palette: s.Palette,
-		Procs:   status.procs,
+		System:     s.System,
+		Size:       status.sz,
+		Camera:     &cam,
+		Depth:      &s.Depth,
+		Projection: &s.Projection,
+		Palette:    s.Palette,
+		Procs:      status.procs,
 	}
 	select {
 	case <-ctx.Done():
@@ -774,6 +827,115 @@ func threshold(ctx context.Context, status *status, line string) {
 	status.onto.ToneMap.GammaMin = x
 }
 
+func density(ctx context.Context, status *status, line string) {
+	const usage = `density <max> [<min> [<curve>]]
+density off
+	Set density estimation, which smooths sparse regions of rendered
+	images. Each bin is blurred with a radius in pixels of max divided by
+	its count to the power of curve, but no less than min. max must be
+	greater than 0, min may be in the interval [0, max], and curve may be
+	any finite number greater than or equal to 0. By default, min is 0 and
+	curve is 0.4.`
+	if line == "" || line == "?" {
+		fmt.Println(usage)
+		return
+	}
+	if line == "off" {
+		status.onto.ToneMap.Density = hist.DensityFilter{}
+		return
+	}
+	args := strings.Fields(line)
+	if len(args) > 3 {
+		fmt.Println(usage)
+		return
+	}
+	de := hist.DensityFilter{Curve: 0.4}
+	for i, p := range []*float64{&de.MaxRadius, &de.MinRadius, &de.Curve}[:len(args)] {
+		x, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		*p = x
+	}
+	if !(de.MaxRadius > 0) || !xmath.IsFinite(de.MaxRadius) {
+		fmt.Println("can't set max radius to", de.MaxRadius)
+		return
+	}
+	if !(de.MinRadius >= 0 && de.MinRadius <= de.MaxRadius) {
+		fmt.Println("can't set min radius to", de.MinRadius)
+		return
+	}
+	if !(de.Curve >= 0) || !xmath.IsFinite(de.Curve) {
+		fmt.Println("can't set curve to", de.Curve)
+		return
+	}
+	status.onto.ToneMap.Density = de
+}
+
+func vibrancy(ctx context.Context, status *status, line string) {
+	const usage = `vibrancy <x>
+	Set vibrancy, the proportion of gamma scaling applied to colors through
+	the brightness of each bin rather than to each color channel separately.
+	Higher vibrancy produces more saturated colors. x may be in the interval
+	[0, 1]. If both vibrancy and highlight power are 0, xirho's original tone
+	mapping is used instead: colors are scaled by the log brightness of each
+	bin without the tone curve or gamma, and channels are clipped separately.`
+	if line == "" || line == "?" {
+		fmt.Println(usage)
+		return
+	}
+	x, err := strconv.ParseFloat(line, 64)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !(x >= 0 && x <= 1) { // condition negated to detect nan
+		fmt.Println("can't set vibrancy to", x)
+		return
+	}
+	status.onto.ToneMap.Vibrancy = x
+}
+
+func highlight(ctx context.Context, status *status, line string) {
+	const usage = `highlight <x>
+	Set highlight power, which controls the colors of bins too bright to
+	display. Negative values clip each color channel separately, shifting
+	hues. Otherwise, bright bins keep their hues and fade toward white more
+	strongly for larger x. x may be any finite number.`
+	if line == "" || line == "?" {
+		fmt.Println(usage)
+		return
+	}
+	x, err := strconv.ParseFloat(line, 64)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !xmath.IsFinite(x) {
+		fmt.Println("can't set highlight power to", x)
+		return
+	}
+	status.onto.ToneMap.HighlightPower = x
+}
+
+func curve(ctx context.Context, status *status, line string) {
+	const usage = `curve <name>
+	Set the tone curve, which compresses the brightest bins before gamma
+	scaling. name may be aces (the default), linear, reinhard, hable, or log.
+	The log curve applies no compression, like the classic flame algorithm.`
+	if line == "" || line == "?" {
+		fmt.Println(usage)
+		return
+	}
+	c, err := hist.ParseCurve(line)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	status.onto.ToneMap.Curve = c
+}
+
 func scaler(ctx context.Context, status *status, line string) {
 	const usage = `scaler <name>
 	Set the resampling method used to scale down oversampled histograms to
@@ -1012,17 +1174,168 @@ func cameye(ctx context.Context, status *status, line string) {
 	}
 }
 
+func camdepth(ctx context.Context, status *status, line string) {
+	const usage = `depth <focus> <aperture> [<fog> [<fogstart>]]
+depth off
+	Set depth of field and fog. Points at camera depth focus are sharp,
+	and others blur by aperture times their distance from it. Points
+	farther than fogstart fade by fog per unit of distance. Greater depth
+	is nearer the camera. aperture and fog must be at least 0, and fog
+	and fogstart default to 0.`
+	if line == "" || line == "?" {
+		fmt.Println(usage)
+		return
+	}
+	var d xirho.Depth
+	if line != "off" {
+		args := strings.Fields(line)
+		if len(args) < 2 || len(args) > 4 {
+			fmt.Println(usage)
+			return
+		}
+		for i, p := range []*float64{&d.Focus, &d.Aperture, &d.Fog, &d.FogStart}[:len(args)] {
+			x, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			if !xmath.IsFinite(x) {
+				fmt.Println("can't use", x)
+				return
+			}
+			*p = x
+		}
+		if d.Aperture < 0 || d.Fog < 0 {
+			fmt.Println(usage)
+			return
+		}
+	}
+	c := xirho.ChangeRender{Depth: &d, Procs: status.procs}
+	select {
+	case <-ctx.Done():
+		return
+	case status.change <- c:
+		// do nothing
+	}
+}
+
+func camfov(ctx context.Context, status *status, line string) {
+	const usage = `fov <degrees> [<near>]
+fov off
+	Set the field of view in degrees across the longer axis of the image,
+	or of each eye's half in stereo. With the rectilinear lens, this
+	enables perspective, and it must be less than 180. Points at camera
+	depth 0 stay in place, and nearer points appear larger. With
+	perspective, pitch and yaw orbit the camera around the system. Points
+	closer to the viewer than near, default 0, are not plotted. fov off
+	restores orthographic projection, or the lens's default field of
+	view.`
+	if line == "" || line == "?" {
+		fmt.Println(usage)
+		return
+	}
+	p := status.r.Projection
+	p.FOV, p.Near = 0, 0
+	if line != "off" {
+		args := strings.Fields(line)
+		if len(args) < 1 || len(args) > 2 {
+			fmt.Println(usage)
+			return
+		}
+		for i, q := range []*float64{&p.FOV, &p.Near}[:len(args)] {
+			x, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			if !xmath.IsFinite(x) {
+				fmt.Println("can't use", x)
+				return
+			}
+			*q = x
+		}
+		if p.FOV <= 0 || p.Lens == xirho.Rectilinear && p.FOV >= 180 {
+			fmt.Println(usage)
+			return
+		}
+		p.FOV *= math.Pi / 180
+	}
+	c := xirho.ChangeRender{Projection: &p, Procs: status.procs}
+	select {
+	case <-ctx.Done():
+		return
+	case status.change <- c:
+		// do nothing
+	}
+}
+
+func camlens(ctx context.Context, status *status, line string) {
+	const usage = `lens <lens> [<stereo>]
+	Set the camera model and stereo eye separation, keeping the field of
+	view and near clipping. lens may be rectilinear, fisheye, or
+	equirectangular. Fisheye and equirectangular lenses view from the
+	camera origin, and equirectangular covers 360 degrees on a 2:1 image.
+	If stereo is nonzero, the left and right halves of the image show a
+	stereo pair with that eye separation. stereo defaults to 0.`
+	if line == "" || line == "?" {
+		fmt.Println(usage)
+		return
+	}
+	args := strings.Fields(line)
+	if len(args) > 2 {
+		fmt.Println(usage)
+		return
+	}
+	p := status.r.Projection
+	var err error
+	p.Lens, err = xirho.ParseLens(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p.Stereo = 0
+	if len(args) > 1 {
+		p.Stereo, err = strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !xmath.IsFinite(p.Stereo) {
+			fmt.Println("can't use", p.Stereo)
+			return
+		}
+	}
+	if p.Lens == xirho.Rectilinear && p.FOV >= math.Pi {
+		p.FOV = 0
+	}
+	c := xirho.ChangeRender{Projection: &p, Procs: status.procs}
+	select {
+	case <-ctx.Done():
+		return
+	case status.change <- c:
+		// do nothing
+	}
+}
+
 func render(ctx context.Context, status *status, line string) {
-	const usage = `render <output.png>
-	Render the current histogram to a PNG file. If not paused, rendering
+	const usage = `render [<format>] <output.png>
+	Render the current histogram to a PNG file. format may be rgb, rgba,
+	rgb16, or rgba16, defaulting to the format given on the command line.
+	Formats ending in 16 use 16 bits per channel, and formats with alpha
+	keep the background's transparency. If not paused, rendering
 	automatically resumes afterward.`
 	if line == "" || line == "?" {
 		fmt.Println(usage)
 		return
 	}
+	format := status.format
+	if name, rest, ok := strings.Cut(line, " "); ok {
+		if f, ok := imageFormats[name]; ok {
+			format, line = f, strings.TrimSpace(rest)
+		}
+	}
 	onto := status.onto
-	onto.Image = image.NewNRGBA64(image.Rect(0, 0, status.sz.W, status.sz.H))
-	draw.Draw(onto.Image, image.Rect(0, 0, status.sz.W, status.sz.H), &status.bg, image.Point{}, draw.Src)
+	onto.Image = format.newImage(image.Rect(0, 0, status.sz.W, status.sz.H), status.bg.C)
 	var t time.Time
 	select {
 	case <-ctx.Done():
@@ -1069,8 +1382,7 @@ func preview(ctx context.Context, status *status, line string) {
 		line = "xirho-preview.png"
 	}
 	onto := status.onto
-	onto.Image = image.NewNRGBA64(image.Rect(0, 0, status.sz.W, status.sz.H))
-	draw.Draw(onto.Image, image.Rect(0, 0, status.sz.W, status.sz.H), &status.bg, image.Point{}, draw.Src)
+	onto.Image = status.format.newImage(image.Rect(0, 0, status.sz.W, status.sz.H), status.bg.C)
 	onto.Scale = draw.ApproxBiLinear
 	var t time.Time
 	select {
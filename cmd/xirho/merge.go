@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/encoding"
+	"github.com/zephyrtronium/xirho/hist"
+)
+
+// merge implements the merge subcommand, which combines checkpoints from
+// separate renders of the same system and tone maps the result.
+func merge(args []string) {
+	var outname, ckname, resample, format string
+	var exrname, pfmname, exrcomp string
+	var tm hist.ToneMap
+	var bgr, bgg, bgb, bga int
+	fs := flag.NewFlagSet("xirho merge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: xirho merge [flags] checkpoint.xh...")
+		fmt.Fprintln(fs.Output(), "Combine checkpoints of the same system rendered by separate processes.")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&outname, "png", "", "output filename (default stdout unless -exr or -pfm is given)")
+	fs.StringVar(&exrname, "exr", "", "linear high dynamic range OpenEXR output filename")
+	fs.StringVar(&exrcomp, "exr.compress", "zip", "OpenEXR compression (zip or none)")
+	fs.StringVar(&pfmname, "pfm", "", "linear high dynamic range PFM output filename")
+	fs.StringVar(&ckname, "checkpoint", "", "save merged checkpoint to file")
+	fs.Float64Var(&tm.Gamma, "gamma", 0, "gamma factor (default from system)")
+	fs.Float64Var(&tm.GammaMin, "thresh", 0, "gamma threshold (default from system)")
+	fs.Float64Var(&tm.Brightness, "bright", 0, "brightness (default from system)")
+	fs.Float64Var(&tm.Contrast, "contrast", 0, "contrast (default from system)")
+	fs.Float64Var(&tm.Density.MaxRadius, "de.max", 0, "density estimation maximum radius in pixels (default from system)")
+	fs.Float64Var(&tm.Density.MinRadius, "de.min", 0, "density estimation minimum radius in pixels (default from system)")
+	fs.Float64Var(&tm.Density.Curve, "de.curve", 0.4, "density estimation curve, used when set or when -de.max enables density estimation for a system without it")
+	fs.Float64Var(&tm.Vibrancy, "vibrancy", 0, "proportion of gamma applied to colors through alpha (default from system)")
+	fs.Float64Var(&tm.HighlightPower, "highlight", 0, "highlight power (default from system)")
+	fs.TextVar(&tm.Curve, "curve", hist.ACES, "tone curve (aces, linear, reinhard, hable, or log) (default from system)")
+	fs.StringVar(&format, "format", "rgba", "image format: rgb, rgba, rgb16, or rgba16 (16 bits per channel); formats with alpha keep the background's transparency")
+	fs.StringVar(&resample, "resample", "catmull-rom", "resampling method (catmull-rom, bilinear, approx-bilinear, or nearest)")
+	fs.IntVar(&bgr, "bg.r", 0, "background red (0-255) (default from system)")
+	fs.IntVar(&bgg, "bg.g", 0, "background green (0-255) (default from system)")
+	fs.IntVar(&bgb, "bg.b", 0, "background blue (0-255) (default from system)")
+	fs.IntVar(&bga, "bg.a", 255, "background alpha (0-255), 0 for transparent output (default from system)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	resampler := resamplers[resample]
+	if resampler == nil {
+		log.Fatalln("no resampler named", resample)
+	}
+	imgfmt, ok := imageFormats[format]
+	if !ok {
+		log.Fatalln("no image format named", format)
+	}
+	compression, ok := exrCompressions[exrcomp]
+	if !ok {
+		log.Fatalln("no OpenEXR compression named", exrcomp)
+	}
+	u := color.NRGBA64{
+		R: uint16(bgr * 0x0101),
+		G: uint16(bgg * 0x0101),
+		B: uint16(bgb * 0x0101),
+		A: uint16(bga * 0x0101),
+	}
+
+	r := &xirho.Render{}
+	extra := resume(fs.Arg(0), r)
+	s, err := encoding.Unmarshal(json.NewDecoder(bytes.NewReader(extra)))
+	if err != nil {
+		log.Fatalln("error unmarshaling system from checkpoint:", err)
+	}
+	r.Camera, r.Palette, r.Depth, r.Projection = s.Camera, s.Palette, s.Depth, s.Projection
+	for _, fn := range fs.Args()[1:] {
+		mergefrom(fn, r)
+	}
+	log.Printf("merged %d checkpoints with %d iters, %d hits", fs.NArg(), r.Iters(), r.Hits())
+	if ckname != "" {
+		checkpointto(ckname, r, s)
+	}
+	// Tone mapping flags override only their own parts of the system's.
+	bgset, curveset := false, false
+	stm := s.ToneMap
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "gamma":
+			stm.Gamma = tm.Gamma
+		case "thresh":
+			stm.GammaMin = tm.GammaMin
+		case "bright":
+			stm.Brightness = tm.Brightness
+		case "contrast":
+			stm.Contrast = tm.Contrast
+		case "de.max":
+			if !stm.Density.Enabled() && !curveset {
+				stm.Density.Curve = tm.Density.Curve
+			}
+			stm.Density.MaxRadius = tm.Density.MaxRadius
+		case "de.min":
+			stm.Density.MinRadius = tm.Density.MinRadius
+		case "de.curve":
+			stm.Density.Curve, curveset = tm.Density.Curve, true
+		case "vibrancy":
+			stm.Vibrancy = tm.Vibrancy
+		case "highlight":
+			stm.HighlightPower = tm.HighlightPower
+		case "curve":
+			stm.Curve = tm.Curve
+		case "bg.r", "bg.g", "bg.b", "bg.a":
+			bgset = true
+		}
+	})
+	tm = stm
+	// Systems encode no background when it is zero, so only a nonzero one is
+	// set by the system. Otherwise the flags' default of opaque black applies.
+	if !bgset && s.BG != (color.NRGBA64{}) {
+		u = s.BG
+	}
+	writehdr(exrname, pfmname, compression, r, tm)
+	if outname != "" || exrname == "" && pfmname == "" {
+		sys := *s
+		sys.ToneMap, sys.BG = tm, u
+		drawpng(outname, r, &encoding.PNGInfo{System: &sys, Iters: r.Iters()}, resampler, imgfmt)
+	}
+}
+
+// mergefrom adds a checkpoint into a render.
+func mergefrom(fn string, r *xirho.Render) {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatalln("error opening checkpoint:", err)
+	}
+	defer f.Close()
+	log.Println("merging", fn)
+	if _, err := r.MergeCheckpoint(f); err != nil {
+		log.Fatalln("error merging checkpoint:", err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"golang.org/x/image/draw"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/encoding"
+	"github.com/zephyrtronium/xirho/hist"
+)
+
+// tiled holds the settings of a tiled render.
+type tiled struct {
+	sz        hist.Size
+	tile      int
+	budget    xirho.Budget
+	timeout   time.Duration
+	seed      *uint64
+	procs     int
+	plotbuf   int
+	progress  bool
+	resampler draw.Scaler
+	format    imageFormat
+}
+
+// drawtiles renders a system in tiles, each with its own histogram, and
+// streams the stitched result as PNG to the named file, or to stdout if the
+// name is empty. Only one row of tiles is held in memory at a time.
+func drawtiles(ctx context.Context, outname string, s *encoding.System, bg color.NRGBA64, t tiled) {
+	// A budget of hits alone would never finish a tile which the system
+	// doesn't reach.
+	if t.budget.Iters <= 0 && t.budget.SPP <= 0 && t.timeout <= 0 {
+		log.Fatal("tiled render requires -iters, -spp, or -dur to bound each tile")
+	}
+	if t.seed != nil && t.budget.Iters <= 0 && t.budget.SPP <= 0 {
+		log.Fatal("seeded render requires -iters or -spp")
+	}
+	// Tiles overlap by enough that density estimation and resampling see
+	// the same neighborhood at tile edges as in an untiled render.
+	margin := int(math.Ceil(s.ToneMap.Density.MaxRadius)) + support(t.resampler) + 1
+	tl := xirho.Tiling{Size: t.sz, Tile: image.Pt(t.tile, t.tile), Margin: margin}
+	full := &xirho.Render{Camera: s.Camera, Depth: s.Depth, Projection: s.Projection, Palette: s.Palette, Budget: t.budget, PlotBuffer: t.plotbuf}
+	tiles := tl.Tiles()
+	log.Printf("rendering %d tiles of up to %dx%d with %d pixel margins, estimated %d MB each", len(tiles), t.tile, t.tile, margin, hist.Size{W: t.tile + 2*margin, H: t.tile + 2*margin, OSA: t.sz.OSA, Storage: t.sz.Storage}.Mem()>>20)
+
+	out := os.Stdout
+	if outname != "" {
+		log.Println("encoding to", outname)
+		var err error
+		out, err = os.Create(outname)
+		if err != nil {
+			log.Fatalln("error creating output file:", err)
+		}
+		defer out.Close()
+	} else {
+		log.Println("encoding to stdout")
+	}
+	w := newPNGStream(out, t.sz.W, t.sz.H, t.format)
+
+	start := time.Now()
+	var iters int64
+	var strip draw.Image
+	for i, tile := range tiles {
+		if strip == nil {
+			strip = t.format.newImage(image.Rect(0, tile.Min.Y, t.sz.W, tile.Max.Y), bg)
+		}
+		r := tl.Render(full, tile)
+		if t.progress {
+			r.OnProgress = printProgress
+		}
+		log.Printf("rendering tile %d of %d at %v", i+1, len(tiles), tile)
+		if t.seed != nil {
+			r.RenderSeeded(ctx, s.System, t.procs, *t.seed, r.Budget.Iters)
+		} else {
+			tctx, stop := ctx, context.CancelFunc(func() {})
+			if t.timeout > 0 {
+				tctx, stop = context.WithTimeout(ctx, t.timeout)
+			}
+			r.Render(tctx, s.System, t.procs)
+			stop()
+		}
+		if t.progress {
+			fmt.Fprintln(os.Stderr)
+		}
+		if ctx.Err() != nil {
+			log.Fatalln("interrupted during tile", i+1)
+		}
+		iters += r.Iters()
+		o := tl.Outer(tile)
+		img := t.format.newImage(image.Rect(0, 0, o.Dx(), o.Dy()), bg)
+		src := r.Hist.Image(s.ToneMap, r.Area(), r.Iters())
+		t.resampler.Scale(img, img.Bounds(), src, src.Bounds(), draw.Over, nil)
+		draw.Draw(strip, tile, img, tile.Min.Sub(o.Min), draw.Src)
+		if tile.Max.X == t.sz.W {
+			// End of a row of tiles.
+			if err := w.WriteRows(strip); err != nil {
+				log.Fatalln("error encoding image:", err)
+			}
+			strip = nil
+		}
+	}
+	sys := *s
+	sys.BG = bg
+	info := encoding.PNGInfo{System: &sys, Duration: time.Since(start), Seed: t.seed}
+	if t.seed != nil {
+		info.Procs = t.procs
+	}
+	if len(tiles) > 0 {
+		info.Iters = iters / int64(len(tiles))
+	}
+	log.Printf("finished %d tiles with %d iters each on average in %v", len(tiles), info.Iters, info.Duration.Round(time.Second))
+	if err := w.Close(&info); err != nil {
+		log.Fatalln("error encoding image:", err)
+	}
+}
+
+// support returns the number of pixels on each side of a pixel which a
+// resampler reads.
+func support(s draw.Scaler) int {
+	if k, ok := s.(*draw.Kernel); ok {
+		return int(math.Ceil(k.Support))
+	}
+	return 1
+}
+
+// pngStream writes a PNG file a few rows at a time, so that images larger
+// than memory can be encoded.
+type pngStream struct {
+	w      *bufio.Writer
+	idat   idatWriter
+	z      *zlib.Writer
+	format imageFormat
+	width  int
+	// nch is the number of channels per pixel, and bpp is the number of
+	// bytes per pixel.
+	nch, bpp int
+	// prev and cur are the previous and current rows, each with a leading
+	// filter type byte.
+	prev, cur []byte
+	// paeth is the filtered current row.
+	paeth []byte
+}
+
+// newPNGStream writes a PNG header for an image in the given format.
+func newPNGStream(w io.Writer, width, height int, format imageFormat) *pngStream {
+	p := pngStream{w: bufio.NewWriter(w), format: format, width: width}
+	p.idat.w = p.w
+	p.z = zlib.NewWriter(&p.idat)
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = 8
+	if format.deep {
+		ihdr[8] = 16
+	}
+	ihdr[9] = 2 // truecolor
+	p.nch = 3
+	if format.alpha {
+		ihdr[9] = 6 // truecolor with alpha
+		p.nch = 4
+	}
+	p.bpp = p.nch * int(ihdr[8]) / 8
+	p.prev = make([]byte, 1+width*p.bpp)
+	p.cur = make([]byte, 1+width*p.bpp)
+	p.paeth = make([]byte, 1+width*p.bpp)
+	p.w.WriteString("\x89PNG\r\n\x1a\n")
+	writeChunk(p.w, "IHDR", ihdr[:])
+	return &p
+}
+
+// WriteRows encodes every row of img, which must be as wide as the image.
+func (p *pngStream) WriteRows(img image.Image) error {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := p.cur[1:]
+		for x := 0; x < p.width; x++ {
+			c := color.NRGBA64Model.Convert(img.At(b.Min.X+x, y)).(color.NRGBA64)
+			ch := [...]uint16{c.R, c.G, c.B, c.A}
+			for i := 0; i < p.nch; i++ {
+				if p.format.deep {
+					binary.BigEndian.PutUint16(row[2*i:], ch[i])
+				} else {
+					row[i] = uint8(ch[i] >> 8)
+				}
+			}
+			row = row[p.bpp:]
+		}
+		p.filter()
+		if _, err := p.z.Write(p.paeth); err != nil {
+			return err
+		}
+		p.prev, p.cur = p.cur, p.prev
+	}
+	return nil
+}
+
+// filter applies the Paeth filter to the current row.
+func (p *pngStream) filter() {
+	cur, prev, out := p.cur[1:], p.prev[1:], p.paeth[1:]
+	p.paeth[0] = 4
+	for i := range cur {
+		var a, c int
+		if i >= p.bpp {
+			a, c = int(cur[i-p.bpp]), int(prev[i-p.bpp])
+		}
+		out[i] = cur[i] - paeth(a, int(prev[i]), c)
+	}
+}
+
+// paeth is the Paeth predictor.
+func paeth(a, b, c int) uint8 {
+	pc := a + b - c
+	pa, pb := abs(pc-a), abs(pc-b)
+	pc = abs(pc - c)
+	switch {
+	case pa <= pb && pa <= pc:
+		return uint8(a)
+	case pb <= pc:
+		return uint8(b)
+	default:
+		return uint8(c)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Close finishes the image data and writes the render information and end of
+// the file.
+func (p *pngStream) Close(info *encoding.PNGInfo) error {
+	if err := p.z.Close(); err != nil {
+		return err
+	}
+	p.idat.flush()
+	if err := encoding.WritePNGInfo(p.w, info); err != nil {
+		return err
+	}
+	writeChunk(p.w, "IEND", nil)
+	return p.w.Flush()
+}
+
+// idatWriter collects compressed image data into IDAT chunks.
+type idatWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (d *idatWriter) Write(b []byte) (int, error) {
+	d.buf = append(d.buf, b...)
+	if len(d.buf) >= 1<<16 {
+		d.flush()
+	}
+	return len(b), nil
+}
+
+func (d *idatWriter) flush() {
+	if len(d.buf) != 0 {
+		writeChunk(d.w, "IDAT", d.buf)
+		d.buf = d.buf[:0]
+	}
+}
+
+// writeChunk writes a PNG chunk. Errors are left for the caller to detect
+// when flushing w.
+func writeChunk(w io.Writer, typ string, data []byte) {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(len(data)))
+	copy(b[4:], typ)
+	crc := crc32.NewIEEE()
+	crc.Write(b[4:])
+	crc.Write(data)
+	w.Write(b[:])
+	w.Write(data)
+	w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+}
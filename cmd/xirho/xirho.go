@@ -3,19 +3,23 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"encoding/xml"
 	"flag"
+	"fmt"
 	"image"
 	"image/color"
-	"image/png"
+	"io"
 	"log"
 	"math"
 	"os"
 	"os/signal"
 	"runtime"
 	"runtime/pprof"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"golang.org/x/image/draw"
@@ -23,47 +27,129 @@ import (
 	"github.com/zephyrtronium/xirho"
 	"github.com/zephyrtronium/xirho/encoding"
 	"github.com/zephyrtronium/xirho/encoding/flame"
+	"github.com/zephyrtronium/xirho/hdr"
 	"github.com/zephyrtronium/xirho/hist"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "merge":
+			merge(os.Args[2:])
+			return
+		case "animate":
+			animate(os.Args[2:])
+			return
+		}
+	}
 	var intr bool
 	var outname, profname, inname, flamename, dumpname string
+	var exrname, pfmname, exrcomp string
+	var ckname, resname string
+	var ckevery time.Duration
 	var sigint bool
 	var timeout time.Duration
+	var budget xirho.Budget
+	var seed uint64
 	var sz hist.Size
 	var tm hist.ToneMap
-	var resample string
-	var procs int
-	var echo bool
+	var depth xirho.Depth
+	var proj xirho.Projection
+	var fov float64
+	var resample, format string
+	var procs, tile, plotbuf int
+	var echo, progress, stats bool
 	var bgr, bgg, bgb, bga int
 	flag.BoolVar(&intr, "i", false, "interactive mode")
-	flag.StringVar(&outname, "png", "", "output filename (default stdout)")
+	flag.StringVar(&outname, "png", "", "output filename (default stdout unless -exr or -pfm is given)")
+	flag.StringVar(&exrname, "exr", "", "linear high dynamic range OpenEXR output filename")
+	flag.StringVar(&exrcomp, "exr.compress", "zip", "OpenEXR compression (zip or none)")
+	flag.StringVar(&pfmname, "pfm", "", "linear high dynamic range PFM output filename")
 	flag.StringVar(&profname, "prof", "", "CPU profile output (default no profiling)")
-	flag.StringVar(&inname, "in", "", "input json filename (default stdin)")
+	flag.StringVar(&inname, "in", "", "input json filename, or PNG rendered by xirho (default stdin)")
 	flag.StringVar(&flamename, "flame", "", "input flame filename")
 	flag.BoolVar(&sigint, "C", true, "save image on interrupt instead of exiting (ignored when interactive)")
 	flag.DurationVar(&timeout, "dur", 0, "max duration to render (default ignored; always ignored when interactive)")
+	flag.Int64Var(&budget.Iters, "iters", 0, "total iterations to render (default ignored)")
+	flag.Float64Var(&budget.SPP, "spp", 0, "samples per histogram bin to render (default ignored)")
+	flag.Uint64Var(&seed, "seed", 0, "seed for a reproducible render; requires -iters or -spp, and -storage float32 is only reproducible with -procs 1 (default unseeded)")
 	flag.IntVar(&sz.W, "width", 1024, "output image width")
 	flag.IntVar(&sz.H, "height", 1024, "output image height")
 	flag.IntVar(&sz.OSA, "osa", 1, "oversampling; histogram bins per pixel per axis")
+	flag.TextVar(&sz.Storage, "storage", hist.Counts64, "histogram storage (counts64, or counts32 or float32 for half the memory)")
 	flag.Float64Var(&tm.Gamma, "gamma", 0, "gamma factor")
 	flag.Float64Var(&tm.GammaMin, "thresh", 0, "gamma threshold")
 	flag.Float64Var(&tm.Brightness, "bright", 0, "brightness")
 	flag.Float64Var(&tm.Contrast, "contrast", 0, "contrast")
+	flag.Float64Var(&tm.Density.MaxRadius, "de.max", 0, "density estimation maximum radius in pixels (default no density estimation)")
+	flag.Float64Var(&tm.Density.MinRadius, "de.min", 0, "density estimation minimum radius in pixels")
+	flag.Float64Var(&tm.Density.Curve, "de.curve", 0.4, "density estimation curve")
+	flag.Float64Var(&tm.Vibrancy, "vibrancy", 0, "proportion of gamma applied to colors through alpha, in [0, 1] (0 with -highlight 0 uses the original tone mapping, scaling colors by log-alpha without tone curve or gamma)")
+	flag.TextVar(&tm.Curve, "curve", hist.ACES, "tone curve (aces, linear, reinhard, hable, or log)")
+	flag.Float64Var(&tm.HighlightPower, "highlight", 0, "highlight power; negative clips channels, larger desaturates bright bins more")
+	flag.Float64Var(&depth.Focus, "dof.focus", 0, "camera z coordinate in focus for depth of field; greater z is nearer")
+	flag.Float64Var(&depth.Aperture, "dof.aperture", 0, "depth of field blur radius per unit of distance from the focus (default no depth of field)")
+	flag.Float64Var(&depth.Fog, "fog", 0, "depth fog density (default no fog)")
+	flag.Float64Var(&depth.FogStart, "fog.start", 0, "camera z coordinate beyond which fog begins")
+	flag.TextVar(&proj.Lens, "lens", xirho.Rectilinear, "camera model (rectilinear, fisheye, or equirectangular)")
+	flag.Float64Var(&fov, "fov", 0, "field of view in degrees across the longer axis, or each eye's half in stereo; enables perspective with the rectilinear lens (default orthographic, 180 for fisheye, 360 for equirectangular)")
+	flag.Float64Var(&proj.Near, "near", 0, "near clipping distance from the viewer")
+	flag.Float64Var(&proj.Stereo, "stereo", 0, "eye separation of a side-by-side stereo pair (default mono)")
+	flag.StringVar(&format, "format", "", "image format: rgb, rgba, rgb16, or rgba16 (16 bits per channel); formats with alpha keep the background's transparency (default rgba, or rgba16 when interactive)")
+	flag.IntVar(&tile, "tile", 0, "render in square tiles of this many pixels, each with its own histogram, streaming the image to -png; requires -iters, -spp, or -dur, which apply to each tile (default untiled)")
 	flag.StringVar(&resample, "resample", "catmull-rom", "resampling method (catmull-rom, bilinear, approx-bilinear, or nearest)")
 	flag.IntVar(&procs, "procs", runtime.GOMAXPROCS(0), "concurrent render routines")
+	flag.IntVar(&plotbuf, "plotbuf", 0, "bins each render routine buffers before plotting onto the shared histogram, reducing contention with many routines; at most 1048576 (default unbuffered)")
 	flag.BoolVar(&echo, "echo", false, "print system encoding before rendering")
-	flag.IntVar(&bgr, "bg.r", 0, "background red (0-255)")
-	flag.IntVar(&bgg, "bg.g", 0, "background green (0-255)")
-	flag.IntVar(&bgb, "bg.b", 0, "background blue (0-255)")
-	flag.IntVar(&bga, "bg.a", 255, "background alpha (0-255)")
+	flag.BoolVar(&progress, "progress", true, "print render progress while rendering (ignored when interactive)")
+	flag.BoolVar(&stats, "stats", false, "print per-node iteration statistics after rendering (ignored when interactive)")
+	flag.IntVar(&bgr, "bg.r", 0, "background red (0-255) (default from system)")
+	flag.IntVar(&bgg, "bg.g", 0, "background green (0-255) (default from system)")
+	flag.IntVar(&bgb, "bg.b", 0, "background blue (0-255) (default from system)")
+	flag.IntVar(&bga, "bg.a", 255, "background alpha (0-255), 0 for transparent output (default from system)")
 	flag.StringVar(&dumpname, "raw-histogram-dump", "", "dump raw histogram data to file")
+	flag.StringVar(&ckname, "checkpoint", "", "save render checkpoint to file after rendering")
+	flag.DurationVar(&ckevery, "checkpoint-every", 0, "interval at which to save checkpoints while rendering (default only at end)")
+	flag.StringVar(&resname, "resume", "", "resume render from checkpoint file (system is loaded from checkpoint unless -in or -flame is given)")
 	flag.Parse()
+	seeded, procsset, tmset, bgset, depthset, projset := false, false, false, false, false, false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "seed":
+			seeded = true
+		case "procs":
+			procsset = true
+		case "gamma", "thresh", "bright", "contrast", "de.max", "de.min", "de.curve", "vibrancy", "highlight", "curve":
+			tmset = true
+		case "bg.r", "bg.g", "bg.b", "bg.a":
+			bgset = true
+		case "dof.focus", "dof.aperture", "fog", "fog.start":
+			depthset = true
+		case "lens", "fov", "near", "stereo":
+			projset = true
+		}
+	})
 	resampler := resamplers[resample]
 	if resampler == nil {
 		log.Fatalln("no resampler named", resample)
 	}
+	if format == "" {
+		format = "rgba"
+		if intr {
+			format = "rgba16"
+		}
+	}
+	imgfmt, ok := imageFormats[format]
+	if !ok {
+		log.Fatalln("no image format named", format)
+	}
+	compression, ok := exrCompressions[exrcomp]
+	if !ok {
+		log.Fatalln("no OpenEXR compression named", exrcomp)
+	}
+	if depth.Fog < 0 {
+		log.Fatalln("fog density must not be negative, got", depth.Fog)
+	}
 	if profname != "" {
 		prof, err := os.Create(profname)
 		if err != nil {
@@ -76,7 +162,7 @@ func main() {
 	}
 	ctx := context.Background()
 	var cancel context.CancelFunc
-	if timeout > 0 && !intr {
+	if timeout > 0 && !intr && tile <= 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 	}
 	if sigint && !intr {
@@ -104,7 +190,23 @@ func main() {
 		if err != nil {
 			log.Fatalln("error opening input:", err)
 		}
-		d := json.NewDecoder(f)
+		br := bufio.NewReader(f)
+		if sig, _ := br.Peek(8); encoding.IsPNG(sig) {
+			info, err := encoding.DecodePNGInfo(br)
+			if err != nil {
+				log.Fatalln("error reading system from image:", err)
+			}
+			s = info.System
+			// Reproduce a seeded render unless told otherwise.
+			if info.Seed != nil && !seeded && budget == (xirho.Budget{}) && timeout <= 0 && ckevery <= 0 {
+				seeded, seed, budget.Iters = true, *info.Seed, info.Iters
+				if info.Procs > 0 && !procsset {
+					procs = info.Procs
+				}
+			}
+			break
+		}
+		d := json.NewDecoder(br)
 		d.UseNumber()
 		s, err = encoding.Unmarshal(d)
 		if err != nil {
@@ -121,21 +223,72 @@ func main() {
 			log.Fatalln("error unmarshaling system:", err)
 		}
 	}
-	if tm != (hist.ToneMap{}) {
+	var r *xirho.Render
+	if resname != "" && !intr {
+		r = &xirho.Render{Hist: hist.New(hist.Size{Storage: sz.Storage}), Budget: budget}
+		extra := resume(resname, r)
+		if s == nil {
+			s, err = encoding.Unmarshal(json.NewDecoder(bytes.NewReader(extra)))
+			if err != nil {
+				log.Fatalln("error unmarshaling system from checkpoint:", err)
+			}
+		}
+		sz = r.Hist.Size()
+		r.Camera, r.Palette, r.Depth, r.Projection = s.Camera, s.Palette, s.Depth, s.Projection
+	}
+	if tmset && s != nil {
 		s.ToneMap = tm
 	}
+	// Systems encode no background when it is zero, so only a nonzero one is
+	// set by the system. Otherwise the flags' default of opaque black applies.
+	if !bgset && s != nil && s.BG != (color.NRGBA64{}) {
+		u = s.BG
+	}
+	if depthset && s != nil {
+		s.Depth = depth
+	}
+	if projset && s != nil {
+		proj.FOV = fov * math.Pi / 180
+		s.Projection = proj
+	}
 	if intr {
-		interactive(ctx, s, sz, resampler, tm, u, procs)
+		interactive(ctx, s, sz, resampler, tm, u, imgfmt, procs, budget)
 		return
 	}
 	if s == nil {
 		log.Fatal("no system to render")
 	}
-	log.Println("allocating histogram, estimated", sz.Mem()>>20, "MB")
-	r := &xirho.Render{
-		Hist:    hist.New(sz),
-		Camera:  s.Camera,
-		Palette: s.Palette,
+	if tile > 0 {
+		if r != nil || ckname != "" || dumpname != "" || exrname != "" || pfmname != "" {
+			log.Fatal("tiled render cannot be combined with -resume, -checkpoint, -raw-histogram-dump, -exr, or -pfm")
+		}
+		t := tiled{
+			sz:        sz,
+			tile:      tile,
+			budget:    budget,
+			timeout:   timeout,
+			procs:     procs,
+			plotbuf:   plotbuf,
+			progress:  progress,
+			resampler: resampler,
+			format:    imgfmt,
+		}
+		if seeded {
+			t.seed = &seed
+		}
+		drawtiles(ctx, outname, s, u, t)
+		return
+	}
+	if r == nil {
+		log.Println("allocating histogram, estimated", sz.Mem()>>20, "MB")
+		r = &xirho.Render{
+			Hist:       hist.New(sz),
+			Camera:     s.Camera,
+			Depth:      s.Depth,
+			Projection: s.Projection,
+			Palette:    s.Palette,
+			Budget:     budget,
+		}
 	}
 	if echo {
 		m, err := encoding.Marshal(s.System, r, s.ToneMap, nil, s.Meta)
@@ -144,21 +297,125 @@ func main() {
 		}
 		log.Printf("system:\n%s\n", m)
 	}
-	log.Println("rendering for", timeout, "or until ^C")
-	start := time.Now()
-	r.Render(ctx, s.System, procs)
+	if progress {
+		r.OnProgress = printProgress
+	}
+	r.Diagnose = stats
+	r.PlotBuffer = plotbuf
+	start, n0 := time.Now(), r.Iters()
+	if seeded {
+		iters := budget.Iters
+		if n := int64(budget.SPP * float64(sz.Bins())); n > 0 && (iters <= 0 || n < iters) {
+			iters = n
+		}
+		if iters <= 0 {
+			log.Fatal("seeded render requires -iters or -spp")
+		}
+		if ckname != "" && ckevery > 0 {
+			log.Fatal("seeded render cannot be combined with -checkpoint-every")
+		}
+		if procs <= 0 {
+			// Record the actual number of workers for reproduction.
+			procs = runtime.GOMAXPROCS(0)
+		}
+		log.Println("rendering", iters, "iters from seed", seed, "or until ^C")
+		r.RenderSeeded(ctx, s.System, procs, seed, iters)
+	} else {
+		log.Println("rendering for", describe(timeout, budget), "or until ^C")
+		for {
+			rctx, stop := ctx, context.CancelFunc(func() {})
+			if ckname != "" && ckevery > 0 {
+				rctx, stop = context.WithTimeout(ctx, ckevery)
+			}
+			r.Render(rctx, s.System, procs)
+			stop()
+			if ctx.Err() != nil || rctx == ctx || budget.Met(r.Iters(), r.Hits(), r.Hist.Cols()*r.Hist.Rows()) {
+				break
+			}
+			checkpointto(ckname, r, s)
+		}
+	}
+	if progress {
+		fmt.Fprintln(os.Stderr)
+	}
 	dur := time.Since(start)
-	log.Printf("finished render with %d iters (%.0f/s), %d hits (%d%%)", r.Iters(), float64(r.Iters())/dur.Seconds(), r.Hits(), r.Hits()*100/r.Iters())
+	// A render cancelled immediately may have no iterations to report.
+	var rate float64
+	var pct int64
+	if n := r.Iters(); n > 0 {
+		rate = float64(n-n0) / dur.Seconds()
+		pct = r.Hits() * 100 / n
+	}
+	log.Printf("finished render with %d iters (%.0f/s), %d hits (%d%%)", r.Iters(), rate, r.Hits(), pct)
 	signal.Reset(os.Interrupt) // no rendering for ^C to interrupt
+	if stats {
+		printStats(r.NodeStats(), s.System)
+	}
 
 	if dumpname != "" {
 		dumpto(dumpname, r.Hist)
 	}
+	if ckname != "" {
+		checkpointto(ckname, r, s)
+	}
+
+	writehdr(exrname, pfmname, compression, r, s.ToneMap)
+	if outname != "" || exrname == "" && pfmname == "" {
+		sys := *s
+		sys.BG = u
+		info := encoding.PNGInfo{System: &sys, Iters: r.Iters(), Duration: dur}
+		if seeded {
+			info.Seed, info.Procs = &seed, procs
+		}
+		drawpng(outname, r, &info, resampler, imgfmt)
+	}
+}
 
-	img := image.NewRGBA(image.Rect(0, 0, sz.W, sz.H))
-	draw.Draw(img, img.Bounds(), image.NewUniform(u), image.Point{}, draw.Src)
+// writehdr writes linear high dynamic range images to the named OpenEXR
+// and PFM files, skipping either if its name is empty.
+func writehdr(exrname, pfmname string, c hdr.Compression, r *xirho.Render, tm hist.ToneMap) {
+	if exrname == "" && pfmname == "" {
+		return
+	}
+	img := r.Hist.Linear(tm, r.Area(), r.Iters())
+	if exrname != "" {
+		log.Println("encoding to", exrname)
+		writeto(exrname, func(w io.Writer) error { return hdr.EncodeEXR(w, img, c) })
+	}
+	if pfmname != "" {
+		log.Println("encoding to", pfmname)
+		writeto(pfmname, func(w io.Writer) error { return hdr.EncodePFM(w, img) })
+	}
+}
+
+// writeto creates a file and writes to it with an encoding function.
+func writeto(fn string, enc func(io.Writer) error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Fatalln("error creating output file:", err)
+	}
+	if err := enc(f); err != nil {
+		log.Fatalln("error encoding image:", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalln("error closing output file:", err)
+	}
+}
+
+var exrCompressions = map[string]hdr.Compression{
+	"zip":  hdr.ZIPCompression,
+	"none": hdr.NoCompression,
+}
+
+// drawpng tone maps a render onto an image of the given format with the tone
+// map and background color of the rendered system and encodes it as PNG to
+// the named file, or to stdout if the name is empty. The render information
+// is embedded in the PNG.
+func drawpng(outname string, r *xirho.Render, info *encoding.PNGInfo, resampler draw.Scaler, format imageFormat) {
+	sz := r.Hist.Size()
+	img := format.newImage(image.Rect(0, 0, sz.W, sz.H), info.System.BG)
 	log.Printf("drawing onto image of size %dx%d", sz.W, sz.H)
-	src := r.Hist.Image(tm, r.Area(), r.Iters())
+	src := r.Hist.Image(info.System.ToneMap, r.Area(), r.Iters())
 	resampler.Scale(img, img.Bounds(), src, src.Bounds(), draw.Over, nil)
 	r, src = nil, nil // allow memory to be reclaimed
 	out := os.Stdout
@@ -173,12 +430,63 @@ func main() {
 	} else {
 		log.Println("encoding to stdout")
 	}
-	err = png.Encode(out, img)
-	if err != nil {
+	if err := encoding.EncodePNG(out, img, info); err != nil {
 		log.Fatalln("error encoding image:", err)
 	}
 }
 
+// printProgress prints a progress line to stderr, overwriting the previous
+// one.
+func printProgress(p xirho.Progress) {
+	rem := "unknown"
+	if p.Remaining >= 0 {
+		rem = p.Remaining.Round(time.Second).String()
+	}
+	fmt.Fprintf(os.Stderr, "\r%d iters (%.0f/s), %d hits, %.2f spp, %v elapsed, %s remaining\x1b[K", p.Iters, p.Rate, p.Hits, p.SPP, p.Elapsed.Round(time.Second), rem)
+}
+
+// printStats prints a table of per-node statistics to stderr.
+func printStats(st []xirho.NodeStats, system xirho.System) {
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "node\tlabel\tselected\tinvalid\tplotted\toutside\t")
+	pct := func(n, d int64) string {
+		if d == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d (%.2f%%)", n, float64(n)*100/float64(d))
+	}
+	var total int64
+	for _, n := range st {
+		total += n.Selected
+	}
+	for i, n := range st {
+		var label string
+		if i < len(system.Nodes) {
+			label = system.Nodes[i].Label
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t\n", i, label, pct(n.Selected, total), pct(n.Invalid, n.Selected), pct(n.Plotted, n.Selected), pct(n.Outside, n.Selected))
+	}
+	w.Flush()
+}
+
+// describe describes the limits on a render.
+func describe(timeout time.Duration, budget xirho.Budget) string {
+	var r []string
+	if timeout > 0 {
+		r = append(r, timeout.String())
+	}
+	if budget.Iters > 0 {
+		r = append(r, fmt.Sprint(budget.Iters, " iters"))
+	}
+	if budget.SPP > 0 {
+		r = append(r, fmt.Sprint(budget.SPP, " samples per bin"))
+	}
+	if len(r) == 0 {
+		return "ever"
+	}
+	return strings.Join(r, " or ")
+}
+
 var resamplers = map[string]draw.Scaler{
 	"catmull-rom":     draw.CatmullRom,
 	"bilinear":        draw.BiLinear,
@@ -223,3 +531,60 @@ func dumpto(fn string, h *hist.Hist) {
 	}
 	log.Println("dumped", n, "bytes")
 }
+
+// checkpointto saves a render checkpoint including the encoded system. The
+// checkpoint is first written to a temporary file which then replaces fn, so
+// that an interruption never leaves a partially written checkpoint.
+func checkpointto(fn string, r *xirho.Render, s *encoding.System) {
+	m, err := encoding.Marshal(s.System, r, s.ToneMap, &s.BG, s.Meta)
+	if err != nil {
+		log.Println("couldn't encode system for checkpoint:", err)
+		return
+	}
+	tmp := fn + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Println("couldn't create checkpoint:", err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	log.Println("saving checkpoint to", fn)
+	n, err := r.Checkpoint(w, m)
+	if err != nil {
+		log.Println("error after writing", n, "bytes:", err)
+		f.Close()
+		return
+	}
+	if err := w.Flush(); err != nil {
+		log.Println("error flushing buffer after writing", n, "bytes:", err)
+		f.Close()
+		return
+	}
+	if err := f.Close(); err != nil {
+		log.Println("error closing checkpoint after writing", n, "bytes:", err)
+		return
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		log.Println("error replacing checkpoint:", err)
+		return
+	}
+	log.Println("saved", n, "bytes at", r.Iters(), "iters")
+}
+
+// resume restores a render from a checkpoint and returns the extra data saved
+// with it.
+func resume(fn string, r *xirho.Render) []byte {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatalln("error opening checkpoint:", err)
+	}
+	defer f.Close()
+	log.Println("loading checkpoint", fn)
+	extra, err := r.Restore(f)
+	if err != nil {
+		log.Fatalln("error reading checkpoint:", err)
+	}
+	sz := r.Hist.Size()
+	log.Printf("loaded %dx%d:%d histogram at %d iters, %d hits", sz.W, sz.H, sz.OSA, r.Iters(), r.Hits())
+	return extra
+}
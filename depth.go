@@ -0,0 +1,55 @@
+package xirho
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// Depth holds depth cues for systems with three-dimensional structure. Depth
+// is measured along the z axis after the camera transform, with greater z
+// nearer the viewer. The zero value disables all depth cues.
+type Depth struct {
+	// Focus is the camera z coordinate of the plane in focus.
+	Focus float64
+	// Aperture controls depth of field. Each plotted point is moved to a
+	// uniformly random position within a disc whose radius is Aperture times
+	// the point's distance from the focal plane, so points away from the
+	// focus blur. The disc is measured in camera coordinates, in which the
+	// view spans [-1, 1) along its longer axis. If Aperture is zero, there
+	// is no depth of field blur.
+	Aperture float64
+	// Fog is the density of depth fog. Points farther than FogStart are
+	// attenuated by exp(-Fog * d), where d is their distance beyond FogStart,
+	// fading them into the background. If Fog is zero, there is no fog. Fog
+	// must not be negative; negative values are treated as zero.
+	Fog float64
+	// FogStart is the camera z coordinate at which fog begins.
+	FogStart float64
+}
+
+// blur applies depth of field to a point in camera coordinates.
+func (d *Depth) blur(x, y, z float64, rng *xmath.RNG) (float64, float64) {
+	if d.Aperture == 0 {
+		return x, y
+	}
+	r := d.Aperture * math.Abs(z-d.Focus) * math.Sqrt(rng.Uniform())
+	s, c := math.Sincos(2 * math.Pi * rng.Uniform())
+	return x + r*c, y + r*s
+}
+
+// fog applies depth fog to the color of a point at camera depth z.
+func (d *Depth) fog(z float64, c color.RGBA64) color.RGBA64 {
+	// negated condition to catch nans
+	if !(d.Fog > 0) || z >= d.FogStart {
+		return c
+	}
+	k := math.Exp(-d.Fog * (d.FogStart - z))
+	return color.RGBA64{
+		R: uint16(float64(c.R) * k),
+		G: uint16(float64(c.G) * k),
+		B: uint16(float64(c.B) * k),
+		A: uint16(float64(c.A) * k),
+	}
+}
@@ -0,0 +1,104 @@
+package xirho_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/hist"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// counts renders a system with a single point and returns the histogram's
+// alpha counts.
+func counts(t *testing.T, p xirho.Pt, d xirho.Depth) []uint64 {
+	t.Helper()
+	return projected(t, p, d, xirho.Projection{})
+}
+
+// projected renders a system with a single point through a projection and
+// returns the histogram's alpha counts.
+func projected(t *testing.T, p xirho.Pt, d xirho.Depth, proj xirho.Projection) []uint64 {
+	t.Helper()
+	r := xirho.Render{
+		Hist:       hist.New(hist.Size{W: 32, H: 32, OSA: 1}),
+		Camera:     xmath.Eye(),
+		Palette:    color.Palette{color.RGBA64{R: 0xffff, A: 0xffff}},
+		Depth:      d,
+		Projection: proj,
+	}
+	s := xirho.System{Nodes: []xirho.Node{{Func: constf{p}, Opacity: 1, Weight: 1}}}
+	r.RenderSeeded(context.Background(), s, 1, 1, 100000)
+	var b bytes.Buffer
+	if _, err := r.Hist.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	v := b.Bytes()[16:]
+	v = v[len(v)*3/4:]
+	n := make([]uint64, len(v)/8)
+	for i := range n {
+		n[i] = binary.LittleEndian.Uint64(v[8*i:])
+	}
+	return n
+}
+
+// spread returns the number of nonzero bins.
+func spread(n []uint64) int {
+	k := 0
+	for _, x := range n {
+		if x != 0 {
+			k++
+		}
+	}
+	return k
+}
+
+func TestDepthOfField(t *testing.T) {
+	d := xirho.Depth{Focus: 0.5, Aperture: 0.25}
+	if k := spread(counts(t, xirho.Pt{Z: 0.5, C: 0.5}, d)); k != 1 {
+		t.Errorf("point in focus spread over %d bins", k)
+	}
+	// Out of focus by 1, the blur radius is 0.25, or 4 bins.
+	n := counts(t, xirho.Pt{Z: -0.5, C: 0.5}, d)
+	if k := spread(n); k < 40 || k > 60 {
+		t.Errorf("point out of focus spread over %d bins, want about 50", k)
+	}
+	for i, x := range n {
+		dx, dy := float64(i%32)-15.5, float64(i/32)-15.5
+		if x != 0 && math.Hypot(dx, dy) > 5.5 {
+			t.Errorf("point blurred too far to bin %d,%d", i%32, i/32)
+		}
+	}
+}
+
+func TestDepthFog(t *testing.T) {
+	d := xirho.Depth{Fog: 2, FogStart: 0}
+	near := counts(t, xirho.Pt{Z: 0.5, C: 0.5}, d)
+	far := counts(t, xirho.Pt{Z: -0.5, C: 0.5}, d)
+	var a, b uint64
+	for i := range near {
+		a += near[i]
+		b += far[i]
+	}
+	if a != 100000*0xffff {
+		t.Errorf("point before fog attenuated: want %d, got %d", 100000*0xffff, a)
+	}
+	want := float64(a) * math.Exp(-1)
+	if math.Abs(float64(b)-want) > 1e-4*want {
+		t.Errorf("wrong fog attenuation: want %g, got %d", want, b)
+	}
+	// Negative fog must not brighten points.
+	d.Fog = -2
+	far = counts(t, xirho.Pt{Z: -0.5, C: 0.5}, d)
+	b = 0
+	for _, x := range far {
+		b += x
+	}
+	if b != a {
+		t.Errorf("negative fog changed counts: want %d, got %d", a, b)
+	}
+}
@@ -27,6 +27,10 @@
 // Hist to plot points, then call its Render method with a non-trivial context.
 // (The context closing is the only way that Render returns.) Alternatively,
 // the RenderAsync method provides an API to manage rendering concurrently,
-// e.g. to support a UI. For fine-grained control of the rendering process,
+// e.g. to support a UI. The RenderSeeded method renders a fixed number of
+// iterations from a seed, so that the same system always produces the same
+// histogram. The RenderMotion method renders a system that changes over time,
+// such as one interpolated between two systems by package fapi, to produce
+// motion blur. For fine-grained control of the rendering process,
 // the System.Iter method can be used directly.
 package xirho
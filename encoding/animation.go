@@ -0,0 +1,224 @@
+package encoding
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/fapi"
+	"github.com/zephyrtronium/xirho/hist"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// Animation holds a sequence of keyframes describing a system which changes
+// over time.
+type Animation struct {
+	// Keyframes is the list of keyframes in order of increasing time.
+	Keyframes []Keyframe `json:"keyframes"`
+}
+
+// Keyframe is the state of an animated system at a particular time.
+type Keyframe struct {
+	// Time is the time of the keyframe in seconds.
+	Time float64 `json:"time"`
+	// System is the system and its rendering parameters at the keyframe.
+	System *System `json:"system"`
+}
+
+// Check verifies that the animation can be interpolated: it has at least one
+// keyframe, keyframe times are finite and strictly increasing, and each pair
+// of adjacent keyframes has systems of the same structure and the same lens.
+// If the systems differ in structure, the returned error wraps a
+// fapi.Mismatch describing the difference.
+func (a *Animation) Check() error {
+	if len(a.Keyframes) == 0 {
+		return fmt.Errorf("animation has no keyframes")
+	}
+	for i, k := range a.Keyframes {
+		if k.System == nil {
+			return fmt.Errorf("keyframe %d has no system", i)
+		}
+		if !xmath.IsFinite(k.Time) {
+			return fmt.Errorf("keyframe %d has non-finite time %v", i, k.Time)
+		}
+		if i == 0 {
+			continue
+		}
+		p := a.Keyframes[i-1]
+		if k.Time <= p.Time {
+			return fmt.Errorf("keyframe %d at time %v is not after keyframe %d at time %v", i, k.Time, i-1, p.Time)
+		}
+		if _, err := lerpSystem(p.System, k.System, 0); err != nil {
+			return fmt.Errorf("keyframes %d and %d differ: %w", i-1, i, err)
+		}
+	}
+	return nil
+}
+
+// Start returns the time of the first keyframe.
+func (a *Animation) Start() float64 {
+	return a.Keyframes[0].Time
+}
+
+// End returns the time of the last keyframe.
+func (a *Animation) End() float64 {
+	return a.Keyframes[len(a.Keyframes)-1].Time
+}
+
+// At interpolates the animation at time t. Times before the first keyframe or
+// after the last are clamped to the animation's ends. Systems are
+// interpolated as by fapi.LerpSystem, and cameras as by xmath.InterpAffine.
+// Tone mapping, aspect ratio, and background colors are interpolated linearly.
+// Palettes are interpolated linearly in the length of the earlier keyframe's
+// palette. Metadata is taken from the earlier keyframe. The result shares no
+// functions with any keyframe.
+func (a *Animation) At(t float64) (*System, error) {
+	k := a.Keyframes
+	// Find the first keyframe after t.
+	i := sort.Search(len(k), func(i int) bool { return k[i].Time > t })
+	switch i {
+	case 0:
+		return lerpSystem(k[0].System, k[0].System, 0)
+	case len(k):
+		return lerpSystem(k[i-1].System, k[i-1].System, 0)
+	}
+	x, y := k[i-1], k[i]
+	u := (t - x.Time) / (y.Time - x.Time)
+	s, err := lerpSystem(x.System, y.System, u)
+	if err != nil {
+		return nil, fmt.Errorf("keyframes %d and %d differ: %w", i-1, i, err)
+	}
+	return s, nil
+}
+
+// Motion creates a motion function over the interval of time [t0, t1], e.g.
+// the time for which a frame's shutter is open, for use with
+// xirho.Render.RenderMotion. The animation must pass Check. Motion prepares
+// the keyframes' systems, so that systems interpolated from them can reuse
+// expensive preparation such as loading images.
+func (a *Animation) Motion(t0, t1 float64) xirho.MotionFunc {
+	for _, k := range a.Keyframes {
+		k.System.System.Prep()
+	}
+	return func(t float64) (xirho.System, xmath.Affine) {
+		s, err := a.At(t0 + (t1-t0)*t)
+		if err != nil {
+			panic(fmt.Errorf("xirho: animation interpolation failed: %w", err))
+		}
+		return s.System, s.Camera
+	}
+}
+
+// lerpSystem interpolates between two serializable systems.
+func lerpSystem(a, b *System, t float64) (*System, error) {
+	if a.Projection.Lens != b.Projection.Lens {
+		return nil, fmt.Errorf("lenses differ: %v and %v", a.Projection.Lens, b.Projection.Lens)
+	}
+	system, err := fapi.LerpSystem(a.System, b.System, t)
+	if err != nil {
+		return nil, err
+	}
+	s := System{
+		System:  system,
+		ToneMap: lerpToneMap(a.ToneMap, b.ToneMap, t),
+		Aspect:  lerp(a.Aspect, b.Aspect, t),
+		Camera:  xmath.InterpAffine(a.Camera, b.Camera, t),
+		Depth: xirho.Depth{
+			Focus:    lerp(a.Depth.Focus, b.Depth.Focus, t),
+			Aperture: lerp(a.Depth.Aperture, b.Depth.Aperture, t),
+			Fog:      lerp(a.Depth.Fog, b.Depth.Fog, t),
+			FogStart: lerp(a.Depth.FogStart, b.Depth.FogStart, t),
+		},
+		Projection: xirho.Projection{
+			Lens:   a.Projection.Lens,
+			FOV:    lerp(a.Projection.FOV, b.Projection.FOV, t),
+			Near:   lerp(a.Projection.Near, b.Projection.Near, t),
+			Stereo: lerp(a.Projection.Stereo, b.Projection.Stereo, t),
+		},
+		BG: color.NRGBA64{
+			R: lerp16(a.BG.R, b.BG.R, t),
+			G: lerp16(a.BG.G, b.BG.G, t),
+			B: lerp16(a.BG.B, b.BG.B, t),
+			A: lerp16(a.BG.A, b.BG.A, t),
+		},
+		Palette: lerpPalette(a.Palette, b.Palette, t),
+		Meta:    a.Meta,
+	}
+	return &s, nil
+}
+
+// lerpToneMap interpolates linearly between tone mapping parameters.
+func lerpToneMap(a, b hist.ToneMap, t float64) hist.ToneMap {
+	// Curves can't be blended, so take the nearest.
+	c := a.Curve
+	if t >= 0.5 {
+		c = b.Curve
+	}
+	return hist.ToneMap{
+		Brightness: lerp(a.Brightness, b.Brightness, t),
+		Contrast:   lerp(a.Contrast, b.Contrast, t),
+		Gamma:      lerp(a.Gamma, b.Gamma, t),
+		GammaMin:   lerp(a.GammaMin, b.GammaMin, t),
+		Density: hist.DensityFilter{
+			MaxRadius: lerp(a.Density.MaxRadius, b.Density.MaxRadius, t),
+			MinRadius: lerp(a.Density.MinRadius, b.Density.MinRadius, t),
+			Curve:     lerp(a.Density.Curve, b.Density.Curve, t),
+		},
+		Vibrancy:       lerp(a.Vibrancy, b.Vibrancy, t),
+		HighlightPower: lerp(a.HighlightPower, b.HighlightPower, t),
+		Curve:          c,
+	}
+}
+
+// lerpPalette interpolates between palettes in non-premultiplied color. If
+// the palettes have different lengths, then b is sampled at the nearest
+// positions to each of a's colors.
+func lerpPalette(a, b color.Palette, t float64) color.Palette {
+	if len(b) == 0 {
+		return a
+	}
+	p := make(color.Palette, len(a))
+	for i, c := range a {
+		j := i
+		if len(a) != len(b) {
+			j = i * len(b) / len(a)
+		}
+		x := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+		y := color.NRGBA64Model.Convert(b[j]).(color.NRGBA64)
+		p[i] = color.NRGBA64{
+			R: lerp16(x.R, y.R, t),
+			G: lerp16(x.G, y.G, t),
+			B: lerp16(x.B, y.B, t),
+			A: lerp16(x.A, y.A, t),
+		}
+	}
+	return p
+}
+
+// lerp interpolates linearly between two reals.
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// lerp16 interpolates linearly between color channels.
+func lerp16(a, b uint16, t float64) uint16 {
+	return uint16(math.Round(lerp(float64(a), float64(b), t)))
+}
+
+// UnmarshalAnimation decodes an animation from serialized JSON and checks
+// that it can be interpolated. Calling UseNumber on the decoder allows
+// UnmarshalAnimation to guarantee full precision for xirho.Int function
+// parameters.
+func UnmarshalAnimation(d *json.Decoder) (*Animation, error) {
+	var a Animation
+	if err := d.Decode(&a); err != nil {
+		return nil, err
+	}
+	if err := a.Check(); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
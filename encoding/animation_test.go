@@ -0,0 +1,136 @@
+package encoding_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image/color"
+	"testing"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/encoding"
+	"github.com/zephyrtronium/xirho/fapi"
+	"github.com/zephyrtronium/xirho/hist"
+	"github.com/zephyrtronium/xirho/xi"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// keyframe creates a keyframe with a single node scaling by sc and coloring
+// toward c.
+func keyframe(tm, sc, c float64) encoding.Keyframe {
+	ax := xmath.Eye()
+	ax.Scale(sc, sc, sc)
+	return encoding.Keyframe{
+		Time: tm,
+		System: &encoding.System{
+			System: xirho.System{
+				Nodes: []xirho.Node{
+					{
+						Func: &xi.Then{Funcs: []xirho.Func{
+							&xi.Affine{Ax: ax},
+							&xi.ColorSpeed{Color: c, Speed: 0.5},
+						}},
+						Opacity: 1,
+						Weight:  1,
+					},
+				},
+			},
+			ToneMap: hist.ToneMap{
+				Brightness: sc,
+				Contrast:   1,
+				Gamma:      1,
+				Density:    hist.DensityFilter{MaxRadius: 2 * sc, Curve: 0.4},
+			},
+			Aspect:     1,
+			Camera:     xmath.Eye(),
+			Depth:      xirho.Depth{Focus: sc, Aperture: 2 * sc, Fog: sc},
+			Projection: xirho.Projection{Lens: xirho.Fisheye, FOV: sc / 2, Stereo: sc},
+			Palette:    color.Palette{color.NRGBA64{R: 0xffff, A: 0xffff}},
+		},
+	}
+}
+
+func TestAnimationRoundTrip(t *testing.T) {
+	a := encoding.Animation{
+		Keyframes: []encoding.Keyframe{keyframe(0, 1, 0), keyframe(2, 3, 1)},
+	}
+	b, err := json.Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	r, err := encoding.UnmarshalAnimation(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Keyframes) != 2 || r.Start() != 0 || r.End() != 2 {
+		t.Fatalf("wrong keyframes after round trip: %+v", r.Keyframes)
+	}
+	s, err := r.At(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := s.System.Nodes[0].Func.(*xi.Then)
+	if x := f.Funcs[0].(*xi.Affine).Ax[0]; x != 1.5 {
+		t.Errorf("wrong interpolated scale: want 1.5, got %v", x)
+	}
+	if c := f.Funcs[1].(*xi.ColorSpeed).Color; c != 0.25 {
+		t.Errorf("wrong interpolated color: want 0.25, got %v", c)
+	}
+	if s.ToneMap.Brightness != 1.5 {
+		t.Errorf("wrong interpolated brightness: want 1.5, got %v", s.ToneMap.Brightness)
+	}
+	if want := (hist.DensityFilter{MaxRadius: 3, Curve: 0.4}); s.ToneMap.Density != want {
+		t.Errorf("wrong interpolated density filter: want %+v, got %+v", want, s.ToneMap.Density)
+	}
+	if want := (xirho.Depth{Focus: 1.5, Aperture: 3, Fog: 1.5}); s.Depth != want {
+		t.Errorf("wrong interpolated depth: want %+v, got %+v", want, s.Depth)
+	}
+	if want := (xirho.Projection{Lens: xirho.Fisheye, FOV: 0.75, Stereo: 1.5}); s.Projection != want {
+		t.Errorf("wrong interpolated projection: want %+v, got %+v", want, s.Projection)
+	}
+	// Times outside the animation clamp to the ends.
+	for _, c := range []struct{ t, want float64 }{{-1, 1}, {0, 1}, {2, 3}, {10, 3}} {
+		s, err := r.At(c.t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x := s.System.Nodes[0].Func.(*xi.Then).Funcs[0].(*xi.Affine).Ax[0]; x != c.want {
+			t.Errorf("wrong scale at %v: want %v, got %v", c.t, c.want, x)
+		}
+	}
+}
+
+func TestAnimationCheck(t *testing.T) {
+	extra := keyframe(1, 1, 1)
+	extra.System.System.Nodes = append(extra.System.System.Nodes, xirho.Node{Func: &xi.Spherical{}, Weight: 1})
+	other := keyframe(1, 1, 1)
+	other.System.System.Nodes[0].Func.(*xi.Then).Funcs[1] = &xi.Spherical{}
+	lens := keyframe(1, 1, 1)
+	lens.System.Projection.Lens = xirho.Rectilinear
+	cases := map[string]struct {
+		k        []encoding.Keyframe
+		mismatch bool
+	}{
+		"empty":    {nil, false},
+		"order":    {[]encoding.Keyframe{keyframe(1, 1, 1), keyframe(0, 1, 1)}, false},
+		"same":     {[]encoding.Keyframe{keyframe(1, 1, 1), keyframe(1, 1, 1)}, false},
+		"nodes":    {[]encoding.Keyframe{keyframe(0, 1, 1), extra}, true},
+		"function": {[]encoding.Keyframe{keyframe(0, 1, 1), other}, true},
+		"lens":     {[]encoding.Keyframe{keyframe(0, 1, 1), lens}, false},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			a := encoding.Animation{Keyframes: c.k}
+			err := a.Check()
+			if err == nil {
+				t.Fatal("no error")
+			}
+			var m fapi.Mismatch
+			if errors.As(err, &m) != c.mismatch {
+				t.Errorf("wrong error: %v", err)
+			}
+		})
+	}
+}
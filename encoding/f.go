@@ -54,6 +54,8 @@ func newFuncm(f xirho.Func) (*funcm, error) {
 			r.Params[p.Name()] = p.Get()
 		case fapi.Affine:
 			r.Params[p.Name()] = p.Get()
+		case fapi.String:
+			r.Params[p.Name()] = p.Get()
 		case fapi.Func:
 			if p.Get() == nil {
 				r.Params[p.Name()] = nil
@@ -87,7 +89,8 @@ func newFuncm(f xirho.Func) (*funcm, error) {
 // registered function with the funcm's name, or if the corresponding function
 // does not have a parameter with the name of a funcm parameter, or if any
 // function parameter cannot be set to the value in the parameters (e.g. due to
-// bounds).
+// bounds), or if the function reports an error from its Err method after
+// being prepared.
 func unf(f *funcm) (v xirho.Func, err error) {
 	v = xi.New(f.Name)
 	if v == nil {
@@ -174,6 +177,14 @@ func unf(f *funcm) (v xirho.Func, err error) {
 			if err := p.Set(b); err != nil {
 				return nil, err
 			}
+		case fapi.String:
+			t, ok := x.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string for %s but got %#v", parm.Name(), x)
+			}
+			if err := p.Set(t); err != nil {
+				return nil, err
+			}
 		case fapi.Func:
 			if x == nil {
 				// Optional funcs are allowed to be nil. The setter will tell
@@ -220,9 +231,18 @@ func unf(f *funcm) (v xirho.Func, err error) {
 	}
 	if len(f.Params) != 0 {
 		nn, _ := xi.NameOf(v) // err must be nil since xi.New succeeded
-		err = fmt.Errorf("unknown params for %s: %v", nn, f.Params)
+		return v, fmt.Errorf("unknown params for %s: %v", nn, f.Params)
+	}
+	// Some functions can only tell whether their parameters are usable once
+	// prepared, e.g. expressions which fail to compile. Report those rather
+	// than leaving them to discard every point.
+	if e, ok := v.(interface{ Err() error }); ok {
+		v.Prep()
+		if err := e.Err(); err != nil {
+			return v, fmt.Errorf("%s: %w", f.Name, err)
+		}
 	}
-	return
+	return v, nil
 }
 
 // getint gets an int64 from a decoded JSON numeric value.
@@ -0,0 +1,78 @@
+package encoding_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/encoding"
+	"github.com/zephyrtronium/xirho/xi"
+)
+
+func TestFuncStringRoundTrip(t *testing.T) {
+	s := keyframe(0, 1, 0.5).System
+	s.System.Nodes[0].Func = &xi.ImageColor{Path: "logo.png", Mode: 1, Blend: 0.5}
+	b, err := s.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := encoding.Unmarshal(json.NewDecoder(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := r.System.Nodes[0].Func.(*xi.ImageColor)
+	if !ok {
+		t.Fatalf("wrong function type: want *xi.ImageColor, got %T", r.System.Nodes[0].Func)
+	}
+	if f.Path != "logo.png" || f.Mode != 1 || f.Blend != 0.5 {
+		t.Errorf("wrong params: want logo.png, 1, 0.5; got %q, %d, %g", f.Path, f.Mode, f.Blend)
+	}
+
+	bad := bytes.Replace(b, []byte(`"path":"logo.png"`), []byte(`"path":1`), 1)
+	if bytes.Equal(bad, b) {
+		t.Fatalf("path not found in encoding %s", b)
+	}
+	if _, err := encoding.Unmarshal(json.NewDecoder(bytes.NewReader(bad))); err == nil {
+		t.Error("no error decoding number as string param")
+	}
+}
+
+func TestExprRoundTrip(t *testing.T) {
+	s := keyframe(0, 1, 0.5).System
+	want := xi.Expr{Defs: "t = w^2 + p1\nu = abs(t)", X: "re(t)/u", Y: "im(t)/u", C: "rand()", P1: 0.25}
+	f := want
+	s.System.Nodes[0].Func = &f
+	b, err := s.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := encoding.Unmarshal(json.NewDecoder(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := r.System.Nodes[0].Func.(*xi.Expr)
+	if !ok {
+		t.Fatalf("wrong function type: want *xi.Expr, got %T", r.System.Nodes[0].Func)
+	}
+	if g.Defs != want.Defs || g.X != want.X || g.Y != want.Y || g.Z != want.Z || g.C != want.C || g.P1 != want.P1 {
+		t.Errorf("wrong params: want %+v, got %+v", want, *g)
+	}
+	g.Prep()
+	if err := g.Err(); err != nil {
+		t.Errorf("decoded expressions don't compile: %v", err)
+	}
+}
+
+func TestExprCompileError(t *testing.T) {
+	s := keyframe(0, 1, 0.5).System
+	// Nest the expression to check that inner functions are checked too.
+	s.System.Nodes[0].Func = &xi.Then{Funcs: []xirho.Func{&xi.Expr{X: "x +* y"}}}
+	b, err := s.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoding.Unmarshal(json.NewDecoder(bytes.NewReader(b))); err == nil {
+		t.Error("no error decoding expression that doesn't compile")
+	}
+}
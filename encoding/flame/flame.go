@@ -12,6 +12,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"image/color"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -65,8 +66,26 @@ func convert(flm flame) (s encoding.System) {
 			Contrast:   1,
 			Gamma:      flm.Gamma,
 			GammaMin:   flm.Thresh,
+			Density: hist.DensityFilter{
+				MaxRadius: flm.DERadius,
+				MinRadius: flm.DEMin,
+				Curve:     flm.DECurve,
+			},
+			Vibrancy:       1,
+			HighlightPower: -1,
 		},
 	}
+	if flm.Vibrancy != nil {
+		s.ToneMap.Vibrancy = *flm.Vibrancy
+	}
+	if flm.HighPow != nil {
+		s.ToneMap.HighlightPower = *flm.HighPow
+	}
+	if s.ToneMap.Vibrancy == 0 && s.ToneMap.HighlightPower == 0 {
+		// Highlight power is unused without vibrancy, but zero for both
+		// would disable gamma scaling of colors.
+		s.ToneMap.HighlightPower = -1
+	}
 	sz, err := nums(flm.Size)
 	defer func() {
 		// Ensure the error is relayed upward.
@@ -89,10 +108,23 @@ func convert(flm flame) (s encoding.System) {
 	}
 	scale := 2 * flm.Scale / msz
 	s.Camera.Scale(scale, scale, scale)
-	s.Camera.Translate(-tr[0]*scale, -tr[1]*scale, flm.Zpos*scale)
+	s.Camera.Translate(0, 0, -flm.Zpos*scale)
 	s.Camera.RotX(flm.Yaw)
 	s.Camera.RotY(flm.Pitch)
 	s.Camera.RotZ(flm.Angle)
+	if flm.Persp > 0 {
+		// flam3 divides by 1 - persp*z in flame units, which is a field of
+		// view whose half-angle has tangent persp/scale in camera units.
+		s.Projection.FOV = 2 * math.Atan(flm.Persp/scale)
+	}
+	// flam3 moves the center to the origin and rotates about it after
+	// projecting.
+	center := xmath.Eye()
+	center.Translate(-tr[0]*scale, -tr[1]*scale, 0)
+	center.RotZ(flm.Angle)
+	s.Projection.Translate(&s.Camera, center[3], center[7])
+	// flam3 blurs in proportion to depth from the camera position.
+	s.Depth.Aperture = flm.DOF
 	bgc, err := nums(flm.Background)
 	if err != nil {
 		return
@@ -357,10 +389,17 @@ type flame struct {
 	Pitch      float64    `xml:"cam_pitch,attr"`
 	Yaw        float64    `xml:"cam_yaw,attr"`
 	Zpos       float64    `xml:"cam_zpos,attr"`
+	Persp      float64    `xml:"cam_perspective,attr"`
+	DOF        float64    `xml:"cam_dof,attr"`
 	Background string     `xml:"background,attr"`
 	Brightness float64    `xml:"brightness,attr"`
 	Gamma      float64    `xml:"gamma,attr"`
 	Thresh     float64    `xml:"gamma_threshold,attr"`
+	DERadius   float64    `xml:"estimator_radius,attr"`
+	DEMin      float64    `xml:"estimator_minimum,attr"`
+	DECurve    float64    `xml:"estimator_curve,attr"`
+	Vibrancy   *float64   `xml:"vibrancy,attr"`
+	HighPow    *float64   `xml:"highlight_power,attr"`
 	Xforms     []xform    `xml:"xform"`
 	Final      finalxform `xml:"finalxform"`
 	Palette    palette    `xml:"palette"`
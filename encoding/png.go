@@ -0,0 +1,269 @@
+package encoding
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"time"
+)
+
+// PNGInfo describes a render for embedding in the PNG files it produces.
+type PNGInfo struct {
+	// System is the rendered system, including its tone mapping and metadata.
+	System *System
+	// Iters is the number of iterations rendered.
+	Iters int64
+	// Seed is the seed of a seeded render, or nil if the render was not
+	// seeded.
+	Seed *uint64
+	// Procs is the number of goroutines among which a seeded render split its
+	// iterations, or 0 if it is unknown. Reproducing a seeded render requires
+	// the same number.
+	Procs int
+	// Duration is the time spent rendering, or 0 if it is unknown.
+	Duration time.Duration
+}
+
+// pngRender is the JSON encoding of render information in PNG files.
+type pngRender struct {
+	Iters    int64   `json:"iters"`
+	Seed     *uint64 `json:"seed,omitempty"`
+	Procs    int     `json:"procs,omitempty"`
+	Duration string  `json:"duration,omitempty"`
+}
+
+// Keywords of iTXt chunks which hold render information.
+const (
+	pngSystemKey = "xirho.system"
+	pngRenderKey = "xirho.render"
+)
+
+// maxITXt is the maximum length of an iTXt chunk, compressed or not, which
+// DecodePNGInfo will read, to avoid huge allocations on corrupt input.
+const maxITXt = 64 << 20
+
+// pngSig is the PNG file signature.
+const pngSig = "\x89PNG\r\n\x1a\n"
+
+// IsPNG returns whether b begins with the PNG file signature.
+func IsPNG(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(pngSig))
+}
+
+// EncodePNG encodes an image as PNG with render information embedded in
+// iTXt chunks. The system is stored in its JSON encoding, along with the
+// iteration count, seed, worker count, and render duration. Metadata is also
+// stored under the standard PNG keywords, so that other programs can display
+// it.
+func EncodePNG(w io.Writer, img image.Image, info *PNGInfo) error {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return err
+	}
+	p := b.Bytes()
+	// Place the text after IHDR, which is always the first chunk, so that
+	// readers can find it without reading the image data.
+	ihdr := len(pngSig) + 12 + int(binary.BigEndian.Uint32(p[len(pngSig):]))
+	bw := bufio.NewWriter(w)
+	bw.Write(p[:ihdr])
+	if err := WritePNGInfo(bw, info); err != nil {
+		return err
+	}
+	bw.Write(p[ihdr:])
+	return bw.Flush()
+}
+
+// WritePNGInfo writes render information as PNG iTXt chunks. It is meant
+// for programs which write PNG files without EncodePNG, e.g. to stream
+// images too large for memory. The chunks may be placed anywhere after the
+// IHDR chunk and before the IEND chunk.
+func WritePNGInfo(w io.Writer, info *PNGInfo) error {
+	// Errors writing to bw are reported by Flush.
+	bw := bufio.NewWriter(w)
+	if info.System != nil {
+		sys, err := info.System.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if err := writeITXt(bw, pngSystemKey, sys, true); err != nil {
+			return err
+		}
+		if m := info.System.Meta; m != nil {
+			if m.Title != "" {
+				writeITXt(bw, "Title", []byte(m.Title), false)
+			}
+			if len(m.Authors) != 0 {
+				writeITXt(bw, "Author", []byte(strings.Join(m.Authors, "; ")), false)
+			}
+			if m.License != "" {
+				writeITXt(bw, "Copyright", []byte(m.License), false)
+			}
+		}
+	}
+	r := pngRender{Iters: info.Iters, Seed: info.Seed, Procs: info.Procs}
+	if info.Duration > 0 {
+		r.Duration = info.Duration.String()
+	}
+	rj, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	writeITXt(bw, pngRenderKey, rj, false)
+	writeITXt(bw, "Software", []byte("xirho"), false)
+	return bw.Flush()
+}
+
+// writeITXt writes an iTXt chunk, optionally compressing its text.
+func writeITXt(w io.Writer, key string, text []byte, compress bool) error {
+	var d bytes.Buffer
+	d.WriteString(key)
+	if compress {
+		// null separator, compression flag and method, empty language and
+		// translated keyword
+		d.Write([]byte{0, 1, 0, 0, 0})
+		z := zlib.NewWriter(&d)
+		z.Write(text)
+		if err := z.Close(); err != nil {
+			return err
+		}
+	} else {
+		d.Write([]byte{0, 0, 0, 0, 0})
+		d.Write(text)
+	}
+	return writeChunk(w, "iTXt", d.Bytes())
+}
+
+// writeChunk writes a PNG chunk.
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(len(data)))
+	copy(b[4:], typ)
+	crc := crc32.NewIEEE()
+	crc.Write(b[4:])
+	crc.Write(data)
+	if _, err := w.Write(b[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
+
+// DecodePNGInfo reads render information embedded in a PNG file by
+// EncodePNG or WritePNGInfo. If the file contains no encoded system, then the
+// returned error is ErrNoSystem.
+func DecodePNGInfo(r io.Reader) (*PNGInfo, error) {
+	var sig [len(pngSig)]byte
+	if _, err := io.ReadFull(r, sig[:]); err != nil {
+		return nil, err
+	}
+	if string(sig[:]) != pngSig {
+		return nil, errors.New("xirho: not a PNG file")
+	}
+	var info PNGInfo
+	for {
+		var h [8]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(h[:4])
+		typ := string(h[4:])
+		if typ == "IEND" {
+			break
+		}
+		if typ != "iTXt" {
+			// Skip the chunk and its CRC.
+			if _, err := io.CopyN(io.Discard, r, int64(n)+4); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if n > maxITXt {
+			return nil, fmt.Errorf("xirho: PNG text chunk too long (%d bytes)", n)
+		}
+		data := make([]byte, n+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(h[4:])
+		crc.Write(data[:n])
+		if crc.Sum32() != binary.BigEndian.Uint32(data[n:]) {
+			return nil, errors.New("xirho: PNG chunk checksum mismatch")
+		}
+		key, text, err := readITXt(data[:n])
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case pngSystemKey:
+			d := json.NewDecoder(bytes.NewReader(text))
+			d.UseNumber()
+			s, err := Unmarshal(d)
+			if err != nil {
+				return nil, fmt.Errorf("xirho: error decoding system from PNG: %w", err)
+			}
+			info.System = s
+		case pngRenderKey:
+			var m pngRender
+			if err := json.Unmarshal(text, &m); err != nil {
+				return nil, fmt.Errorf("xirho: error decoding render info from PNG: %w", err)
+			}
+			info.Iters, info.Seed, info.Procs = m.Iters, m.Seed, m.Procs
+			if m.Duration != "" {
+				info.Duration, err = time.ParseDuration(m.Duration)
+				if err != nil {
+					return nil, fmt.Errorf("xirho: error decoding render info from PNG: %w", err)
+				}
+			}
+		}
+	}
+	if info.System == nil {
+		return &info, ErrNoSystem
+	}
+	return &info, nil
+}
+
+// ErrNoSystem is returned by DecodePNGInfo when a PNG file contains no
+// encoded system.
+var ErrNoSystem = errors.New("xirho: no system in PNG")
+
+// readITXt parses the keyword and text of an iTXt chunk.
+func readITXt(data []byte) (key string, text []byte, err error) {
+	k, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 2 {
+		return "", nil, errors.New("xirho: malformed iTXt chunk")
+	}
+	compressed := rest[0] == 1
+	// Skip the language tag and translated keyword.
+	_, rest, ok = bytes.Cut(rest[2:], []byte{0})
+	if ok {
+		_, rest, ok = bytes.Cut(rest, []byte{0})
+	}
+	if !ok {
+		return "", nil, errors.New("xirho: malformed iTXt chunk")
+	}
+	if !compressed {
+		return string(k), rest, nil
+	}
+	z, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return "", nil, err
+	}
+	text, err = io.ReadAll(io.LimitReader(z, maxITXt+1))
+	if len(text) > maxITXt {
+		return "", nil, errors.New("xirho: PNG text chunk too long")
+	}
+	return string(k), text, err
+}
@@ -0,0 +1,107 @@
+package encoding_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/encoding"
+)
+
+func TestPNGRoundTrip(t *testing.T) {
+	s := keyframe(0, 2, 0.25).System
+	s.Meta = &xirho.Metadata{Title: "test", Authors: []string{"a", "b"}, License: "CC0"}
+	s.BG = color.NRGBA64{R: 0x1234, G: 0x5678, B: 0x9abc, A: 0xffff}
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(1, 1, color.White)
+	seed := uint64(12345)
+	info := encoding.PNGInfo{System: s, Iters: 1e6, Seed: &seed, Procs: 3, Duration: 3 * time.Second}
+	var b bytes.Buffer
+	if err := encoding.EncodePNG(&b, img, &info); err != nil {
+		t.Fatal(err)
+	}
+	if !encoding.IsPNG(b.Bytes()) {
+		t.Error("encoded image doesn't have PNG signature")
+	}
+	// The result must still be a valid PNG of the same image.
+	m, err := png.Decode(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.GrayModel.Convert(m.At(1, 1)); c != (color.Gray{Y: 0xff}) {
+		t.Errorf("wrong decoded color: want white, got %v", c)
+	}
+	r, err := encoding.DecodePNGInfo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Iters != info.Iters || r.Seed == nil || *r.Seed != seed || r.Procs != info.Procs || r.Duration != info.Duration {
+		t.Errorf("wrong render info: want %d iters, seed %d, %d procs, %v; got %d, %v, %d, %v", info.Iters, seed, info.Procs, info.Duration, r.Iters, r.Seed, r.Procs, r.Duration)
+	}
+	if r.System == nil {
+		t.Fatal("no system decoded")
+	}
+	if r.System.ToneMap != s.ToneMap {
+		t.Errorf("wrong tone map: want %+v, got %+v", s.ToneMap, r.System.ToneMap)
+	}
+	if r.System.BG != s.BG {
+		t.Errorf("wrong background: want %v, got %v", s.BG, r.System.BG)
+	}
+	if r.System.Meta == nil || r.System.Meta.Title != "test" || len(r.System.Meta.Authors) != 2 {
+		t.Errorf("wrong metadata: %+v", r.System.Meta)
+	}
+	if len(r.System.System.Nodes) != 1 {
+		t.Errorf("wrong number of nodes: want 1, got %d", len(r.System.System.Nodes))
+	}
+}
+
+func TestPNGNoSystem(t *testing.T) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoding.DecodePNGInfo(&b); !errors.Is(err, encoding.ErrNoSystem) {
+		t.Errorf("wrong error: want ErrNoSystem, got %v", err)
+	}
+	if _, err := encoding.DecodePNGInfo(bytes.NewReader([]byte("{}"))); err == nil {
+		t.Error("no error decoding non-PNG")
+	}
+	// A text chunk claiming to be enormous must be rejected without reading it.
+	huge := []byte("\x89PNG\r\n\x1a\n\x7f\xff\xff\xffiTXt")
+	if _, err := encoding.DecodePNGInfo(bytes.NewReader(huge)); err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("wrong error decoding huge chunk: %v", err)
+	}
+}
+
+func TestPNGInfoAfterData(t *testing.T) {
+	// Streaming encoders write render information after the image data.
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	p := b.Bytes()
+	iend := p[len(p)-12:]
+	var out bytes.Buffer
+	out.Write(p[:len(p)-12])
+	info := encoding.PNGInfo{System: keyframe(0, 2, 0.25).System, Iters: 77}
+	if err := encoding.WritePNGInfo(&out, &info); err != nil {
+		t.Fatal(err)
+	}
+	out.Write(iend)
+	if _, err := png.Decode(bytes.NewReader(out.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	r, err := encoding.DecodePNGInfo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Iters != 77 || r.Seed != nil {
+		t.Errorf("wrong render info: want 77 iters and no seed, got %d, %v", r.Iters, r.Seed)
+	}
+}
@@ -2,8 +2,10 @@
 package encoding
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"image"
 	"image/color"
 	"strconv"
@@ -16,12 +18,14 @@ import (
 // System holds a xirho system and its rendering parameters for marshaling or
 // unmarshaling.
 type System struct {
-	System  xirho.System
-	ToneMap hist.ToneMap
-	Aspect  float64
-	Camera  xmath.Affine
-	BG      color.NRGBA64
-	Palette color.Palette
+	System     xirho.System
+	ToneMap    hist.ToneMap
+	Aspect     float64
+	Camera     xmath.Affine
+	Depth      xirho.Depth
+	Projection xirho.Projection
+	BG         color.NRGBA64
+	Palette    color.Palette
 
 	Meta *xirho.Metadata
 
@@ -36,12 +40,14 @@ type System struct {
 // background color and metadata into a serializable system.
 func Wrap(system xirho.System, r *xirho.Render, tm hist.ToneMap, bg *color.NRGBA64, meta *xirho.Metadata) *System {
 	s := System{
-		System:  system,
-		ToneMap: tm,
-		Aspect:  r.Hist.Aspect(),
-		Camera:  r.Camera,
-		Palette: r.Palette,
-		Meta:    meta,
+		System:     system,
+		ToneMap:    tm,
+		Aspect:     r.Hist.Aspect(),
+		Camera:     r.Camera,
+		Depth:      r.Depth,
+		Projection: r.Projection,
+		Palette:    r.Palette,
+		Meta:       meta,
 	}
 	if bg != nil {
 		s.BG = *bg
@@ -54,9 +60,11 @@ func Wrap(system xirho.System, r *xirho.Render, tm hist.ToneMap, bg *color.NRGBA
 func (s *System) Render(sz image.Point, osa int) *xirho.Render {
 	w, h := xmath.Fit(sz.X, sz.Y, s.Aspect)
 	return &xirho.Render{
-		Hist:    hist.New(hist.Size{W: w, H: h, OSA: osa}),
-		Camera:  s.Camera,
-		Palette: s.Palette,
+		Hist:       hist.New(hist.Size{W: w, H: h, OSA: osa}),
+		Camera:     s.Camera,
+		Palette:    s.Palette,
+		Depth:      s.Depth,
+		Projection: s.Projection,
 	}
 }
 
@@ -75,10 +83,22 @@ func (s *System) MarshalJSON() ([]byte, error) {
 		Contrast: s.ToneMap.Contrast,
 		Gamma:    s.ToneMap.Gamma,
 		Thresh:   s.ToneMap.GammaMin,
+		Vibrancy: s.ToneMap.Vibrancy,
+		HighPow:  s.ToneMap.HighlightPower,
+		Curve:    s.ToneMap.Curve,
 		Aspect:   s.Aspect,
 		Meta:     s.Meta,
 		Palette:  EncodePalette(s.Palette),
 	}
+	if s.ToneMap.Density.Enabled() {
+		m.DE = (*densitym)(&s.ToneMap.Density)
+	}
+	if s.Depth != (xirho.Depth{}) {
+		m.Depth = (*depthm)(&s.Depth)
+	}
+	if s.Projection != (xirho.Projection{}) {
+		m.Proj = (*projm)(&s.Projection)
+	}
 	for i, f := range system.Nodes {
 		e, err := newFuncm(f.Func)
 		e.Opacity = f.Opacity
@@ -116,6 +136,15 @@ func (s *System) UnmarshalJSON(b []byte) (err error) {
 		return err
 	}
 	s.Camera = m.Camera
+	if m.Depth != nil {
+		if m.Depth.Fog < 0 {
+			return fmt.Errorf("xirho: negative fog density %v", m.Depth.Fog)
+		}
+		s.Depth = xirho.Depth(*m.Depth)
+	}
+	if m.Proj != nil {
+		s.Projection = xirho.Projection(*m.Proj)
+	}
 	s.System = xirho.System{
 		Nodes: make([]xirho.Node, len(m.Funcs)),
 	}
@@ -137,10 +166,16 @@ func (s *System) UnmarshalJSON(b []byte) (err error) {
 		}
 	}
 	s.ToneMap = hist.ToneMap{
-		Brightness: m.Bright,
-		Contrast:   m.Contrast,
-		Gamma:      m.Gamma,
-		GammaMin:   m.Thresh,
+		Brightness:     m.Bright,
+		Contrast:       m.Contrast,
+		Gamma:          m.Gamma,
+		GammaMin:       m.Thresh,
+		Vibrancy:       m.Vibrancy,
+		HighlightPower: m.HighPow,
+		Curve:          m.Curve,
+	}
+	if m.DE != nil {
+		s.ToneMap.Density = hist.DensityFilter(*m.DE)
 	}
 	if m.BG != nil {
 		s.BG = color.NRGBA64(*m.BG)
@@ -164,11 +199,21 @@ type marshaler struct {
 	// renderer params
 	Aspect float64      `json:"aspect"`
 	Camera xmath.Affine `json:"camera"`
+	// depth of field and fog, if any
+	Depth *depthm `json:"depth,omitempty"`
+	// lens and perspective, if not orthographic
+	Proj *projm `json:"projection,omitempty"`
 	// brightness params
 	Bright   float64 `json:"bright"`
 	Contrast float64 `json:"contrast"`
 	Gamma    float64 `json:"gamma"`
 	Thresh   float64 `json:"thresh"`
+	Vibrancy float64 `json:"vibrancy,omitempty"`
+	HighPow  float64 `json:"highlight,omitempty"`
+	// tone curve, if not the default
+	Curve hist.Curve `json:"curve,omitempty"`
+	// density estimation, if any
+	DE *densitym `json:"de,omitempty"`
 	// bg color, if any
 	BG *bgcolor `json:"bg,omitempty"`
 	// Palette is formed by concatenating each channel of the NRGBA64 palette
@@ -176,6 +221,29 @@ type marshaler struct {
 	Palette string `json:"palette"`
 }
 
+// densitym serializes density estimation parameters.
+type densitym struct {
+	MaxRadius float64 `json:"max"`
+	MinRadius float64 `json:"min"`
+	Curve     float64 `json:"curve"`
+}
+
+// depthm serializes depth of field and fog parameters.
+type depthm struct {
+	Focus    float64 `json:"focus"`
+	Aperture float64 `json:"aperture"`
+	Fog      float64 `json:"fog"`
+	FogStart float64 `json:"fogstart"`
+}
+
+// projm serializes projection parameters.
+type projm struct {
+	Lens   xirho.Lens `json:"lens,omitempty"`
+	FOV    float64    `json:"fov"`
+	Near   float64    `json:"near"`
+	Stereo float64    `json:"stereo,omitempty"`
+}
+
 // bgcolor serializes an NRGBA64 color in a friendlier format.
 type bgcolor color.NRGBA64
 
@@ -183,7 +251,7 @@ func (c *bgcolor) MarshalText() ([]byte, error) {
 	if c == nil {
 		return nil, nil
 	}
-	b := make([]byte, 1, 17)
+	b := make([]byte, 17)
 	b[0] = '#'
 	hex.Encode(b[1:], []byte{byte(c.R >> 8), byte(c.R)})
 	hex.Encode(b[5:], []byte{byte(c.G >> 8), byte(c.G)})
@@ -194,6 +262,7 @@ func (c *bgcolor) MarshalText() ([]byte, error) {
 
 func (c *bgcolor) UnmarshalText(text []byte) error {
 	var r, g, b, a uint16
+	text = bytes.TrimPrefix(text, []byte{'#'})
 	switch len(text) {
 	case 3: // rgb
 		c, err := strconv.ParseUint(string(text[0:1]), 16, 4)
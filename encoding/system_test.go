@@ -0,0 +1,21 @@
+package encoding_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/zephyrtronium/xirho/encoding"
+)
+
+func TestUnmarshalNegativeFog(t *testing.T) {
+	s := keyframe(0, 1, 0.5).System
+	s.Depth.Fog = -1
+	b, err := s.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoding.Unmarshal(json.NewDecoder(bytes.NewReader(b))); err == nil {
+		t.Error("no error decoding negative fog")
+	}
+}
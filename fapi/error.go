@@ -46,6 +46,23 @@ func (err NotFinite) Error() string {
 	return fmt.Sprintf("cannot set %s to a value which is not finite", err.Param.Name())
 }
 
+// TooLong is an error returned when attempting to set a String to a value
+// longer than the parameter's maximum length.
+type TooLong struct {
+	// Param is the parameter which the caller attempted to set.
+	Param Param
+	// Len is the length in characters of the value which the caller
+	// attempted to use.
+	Len int
+	// Max is the maximum allowed length.
+	Max int
+}
+
+// Error returns a formatted error message.
+func (err TooLong) Error() string {
+	return fmt.Sprintf("cannot set %s to a string of length %d: length must be at most %d", err.Param.Name(), err.Len, err.Max)
+}
+
 // NotOptional is an error returned when attempting to set a Func to nil when
 // the Func is not marked as optional.
 type NotOptional struct {
@@ -57,3 +74,17 @@ type NotOptional struct {
 func (err NotOptional) Error() string {
 	return err.Param.Name() + " is not optional"
 }
+
+// Mismatch is an error returned when attempting to interpolate between
+// functions or systems which differ in structure.
+type Mismatch struct {
+	// Path describes the location of the mismatch, e.g. "nodes[1].funcs[0]".
+	Path string
+	// Reason describes the difference.
+	Reason string
+}
+
+// Error returns a formatted error message.
+func (err Mismatch) Error() string {
+	return "cannot interpolate " + err.Path + ": " + err.Reason
+}
@@ -35,6 +35,7 @@ import (
 //   - complex128, which gives a [Complex].
 //   - [3]float64 (alias [xirho.Vec3]), which gives a [Vec3].
 //   - [xmath.Affine], which gives an [Affine].
+//   - string, which gives a [String].
 //   - [xirho.Func], which gives a [Func].
 //   - []xirho.Func, which gives a [FuncList].
 //
@@ -133,6 +134,23 @@ func getParam(f reflect.StructField, v reflect.Value) Param {
 		return vec3For(name, val.(*[3]float64))
 	case rAffine:
 		return affineFor(name, val.(*xmath.Affine))
+	case rString:
+		path, limit := false, 0
+		for _, opt := range tag[1:] {
+			if opt == "path" {
+				path = true
+				continue
+			}
+			n, err := strconv.Atoi(opt)
+			if err != nil {
+				panic(fmt.Errorf(`xirho: bad value %q for string tag; need "path" or a maximum length`, opt))
+			}
+			if n <= 0 {
+				panic(fmt.Errorf("xirho: String max length %d is not positive", n))
+			}
+			limit = n
+		}
+		return stringFor(name, val.(*string), path, limit)
 	case rFunc:
 		opt := false
 		if len(tag) >= 2 {
@@ -166,6 +184,7 @@ var (
 	rComplex  = reflect.TypeOf(complex128(0))
 	rVec3     = reflect.TypeOf([3]float64{})
 	rAffine   = reflect.TypeOf(xmath.Affine{})
+	rString   = reflect.TypeOf("")
 	rFunc     = reflect.TypeOf((*xirho.Func)(nil)).Elem()
 	rFuncList = reflect.TypeOf([]xirho.Func(nil))
 )
@@ -24,6 +24,7 @@ type pf struct {
 	Func    xirho.Func   `xirho:"11"`
 	NFunc   xirho.Func   `xirho:"12,optional"`
 	Funcs   []xirho.Func `xirho:"13"`
+	String  string       `xirho:"14"`
 }
 
 func (*pf) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
@@ -72,7 +73,7 @@ func TestForCount(t *testing.T) {
 		n int
 	}{
 		"ef": {v: ef{}, f: 0, n: 0},
-		"pf": {v: newPf(), f: 14, n: 13},
+		"pf": {v: newPf(), f: 15, n: 14},
 	}
 	for name, c := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -90,7 +91,8 @@ func TestForCount(t *testing.T) {
 			for i, p := range api {
 				switch p.(type) {
 				case fapi.Flag, fapi.List, fapi.Int, fapi.Angle, fapi.Real,
-					fapi.Complex, fapi.Vec3, fapi.Affine, fapi.Func, fapi.FuncList: // do nothing
+					fapi.Complex, fapi.Vec3, fapi.Affine, fapi.String, fapi.Func,
+					fapi.FuncList: // do nothing
 				default:
 					t.Errorf("unknown parameter type %T for parameter %d named %q", p, i, p.Name())
 				}
@@ -173,7 +175,9 @@ func TestErrorsProduceANonemptyErrorMessage(t *testing.T) {
 		"OutOfBoundsInt":  fapi.OutOfBoundsInt{Param: p, Value: 1},
 		"OutOfBoundsReal": fapi.OutOfBoundsReal{Param: p, Value: 1},
 		"NotFinite":       fapi.NotFinite{Param: p},
+		"TooLong":         fapi.TooLong{Param: p, Len: 2, Max: 1},
 		"NotOptional":     fapi.NotOptional{Param: p},
+		"Mismatch":        fapi.Mismatch{Path: "func", Reason: "test"},
 	}
 	for name, c := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -0,0 +1,214 @@
+package fapi
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// Lerp creates a new function with parameters interpolated between those of a
+// and b, which must have the same type. t is the interpolation time; 0 gives
+// the parameters of a, and 1 gives those of b.
+//
+// Real, Complex, and Vec3 parameters are interpolated linearly. Angle
+// parameters are interpolated along the shorter arc of the circle. Affine
+// parameters are decomposed into rotation, stretch, and translation, which are
+// interpolated separately as by xmath.InterpAffine.
+// Flag, List, Int, and String parameters, which have no values between their
+// endpoints, take the value of a when t < 0.5 and of b otherwise. Func and
+// FuncList parameters are interpolated recursively, so the functions they hold
+// must also have the same structure.
+//
+// The returned function is a copy; neither a nor b is modified, and the result
+// shares no parameters with either. The copy has not been prepared. If a and b
+// differ in structure, the returned error is of type Mismatch.
+func Lerp(a, b xirho.Func, t float64) (xirho.Func, error) {
+	return lerpFunc(a, b, t, "func")
+}
+
+// lerpFunc implements Lerp, using path to describe the location of mismatches.
+func lerpFunc(a, b xirho.Func, t float64, path string) (xirho.Func, error) {
+	if a == nil || b == nil {
+		if a != nil || b != nil {
+			return nil, Mismatch{Path: path, Reason: "only one function is nil"}
+		}
+		return nil, nil
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return nil, Mismatch{Path: path, Reason: fmt.Sprintf("different types %T and %T", a, b)}
+	}
+	if va.Kind() != reflect.Ptr || va.Elem().Kind() != reflect.Struct {
+		// For can't describe parameters of non-pointer functions, so there
+		// is nothing to interpolate.
+		if t < 0.5 {
+			return a, nil
+		}
+		return b, nil
+	}
+	v := reflect.New(va.Type().Elem())
+	v.Elem().Set(va.Elem())
+	f := v.Interface().(xirho.Func)
+	pa, pb, pf := For(a), For(b), For(f)
+	for i, p := range pf {
+		if err := lerpParam(p, pa[i], pb[i], t, path+"."+p.Name()); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// lerpParam sets p to the interpolation between a and b, which must be the
+// same kind of parameter.
+func lerpParam(p, a, b Param, t float64, path string) error {
+	switch p := p.(type) {
+	case Flag:
+		return p.Set(nearest(a.(Flag).Get(), b.(Flag).Get(), t))
+	case List:
+		return p.Set(nearest(a.(List).Get(), b.(List).Get(), t))
+	case Int:
+		return p.Set(nearest(a.(Int).Get(), b.(Int).Get(), t))
+	case Angle:
+		x, y := a.(Angle).Get(), b.(Angle).Get()
+		return p.Set(x + xmath.Angle(y-x)*t)
+	case Real:
+		x := lerp(a.(Real).Get(), b.(Real).Get(), t)
+		// Rounding can put the result slightly outside the bounds even
+		// when both endpoints are inside them.
+		lo, hi := p.Bounds()
+		if x < lo {
+			x = lo
+		} else if x > hi {
+			x = hi
+		}
+		return p.Set(x)
+	case Complex:
+		x, y := a.(Complex).Get(), b.(Complex).Get()
+		return p.Set(complex(lerp(real(x), real(y), t), lerp(imag(x), imag(y), t)))
+	case Vec3:
+		x, y := a.(Vec3).Get(), b.(Vec3).Get()
+		for i := range x {
+			x[i] = lerp(x[i], y[i], t)
+		}
+		return p.Set(x)
+	case Affine:
+		return p.Set(xmath.InterpAffine(a.(Affine).Get(), b.(Affine).Get(), t))
+	case String:
+		return p.Set(nearest(a.(String).Get(), b.(String).Get(), t))
+	case Func:
+		f, err := lerpFunc(a.(Func).Get(), b.(Func).Get(), t, path)
+		if err != nil || f == nil {
+			// If both endpoints are nil, then so is the copy, even if the
+			// parameter isn't optional.
+			return err
+		}
+		return p.Set(f)
+	case FuncList:
+		x, y := a.(FuncList).Get(), b.(FuncList).Get()
+		if len(x) != len(y) {
+			return Mismatch{Path: path, Reason: fmt.Sprintf("different lengths %d and %d", len(x), len(y))}
+		}
+		l := make([]xirho.Func, len(x))
+		for i := range x {
+			f, err := lerpFunc(x[i], y[i], t, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+			l[i] = f
+		}
+		return p.Set(l)
+	default:
+		panic(fmt.Errorf("fapi: unknown param type %T (unreachable)", p))
+	}
+}
+
+// LerpSystem creates a new system interpolated between a and b, which must
+// have the same number of nodes, each with functions of the same structure,
+// and must both either have or lack a final. Node functions and the final are
+// interpolated as by Lerp. Node weights, opacities, and graph weights are
+// interpolated linearly, and labels are taken from a. If a and b differ in
+// structure, the returned error is of type Mismatch.
+func LerpSystem(a, b xirho.System, t float64) (xirho.System, error) {
+	if len(a.Nodes) != len(b.Nodes) {
+		return xirho.System{}, Mismatch{Path: "system", Reason: fmt.Sprintf("different numbers of nodes %d and %d", len(a.Nodes), len(b.Nodes))}
+	}
+	s := xirho.System{Nodes: make([]xirho.Node, len(a.Nodes))}
+	for i, x := range a.Nodes {
+		y := b.Nodes[i]
+		f, err := lerpFunc(x.Func, y.Func, t, fmt.Sprintf("nodes[%d]", i))
+		if err != nil {
+			return xirho.System{}, err
+		}
+		s.Nodes[i] = xirho.Node{
+			Func:    f,
+			Opacity: lerp(x.Opacity, y.Opacity, t),
+			Weight:  lerp(x.Weight, y.Weight, t),
+			Graph:   lerpGraph(x.Graph, y.Graph, t),
+			Label:   x.Label,
+		}
+	}
+	f, err := lerpFunc(a.Final, b.Final, t, "final")
+	if err != nil {
+		return xirho.System{}, err
+	}
+	s.Final = f
+	return s, nil
+}
+
+// lerpGraph interpolates between node graphs. Missing values are treated as
+// being 1, as they are in rendering.
+func lerpGraph(a, b []float64, t float64) []float64 {
+	if a == nil && b == nil {
+		return nil
+	}
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	g := make([]float64, n)
+	for i := range g {
+		x, y := 1.0, 1.0
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		g[i] = lerp(x, y, t)
+	}
+	return g
+}
+
+// Motion creates a motion function which interpolates between two systems and
+// cameras as by LerpSystem and xmath.InterpAffine, for use with Render.RenderMotion.
+// If the systems cannot be interpolated, the returned error is of type
+// Mismatch.
+func Motion(a, b xirho.System, ca, cb xmath.Affine) (xirho.MotionFunc, error) {
+	// Interpolate once up front so that the motion function can't fail.
+	if _, err := LerpSystem(a, b, 0); err != nil {
+		return nil, err
+	}
+	m := func(t float64) (xirho.System, xmath.Affine) {
+		s, err := LerpSystem(a, b, t)
+		if err != nil {
+			panic(fmt.Errorf("fapi: motion interpolation failed after check: %w", err))
+		}
+		return s, xmath.InterpAffine(ca, cb, t)
+	}
+	return m, nil
+}
+
+// lerp interpolates linearly between two reals.
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// nearest chooses a when t < 0.5 and b otherwise.
+func nearest[T any](a, b T, t float64) T {
+	if t < 0.5 {
+		return a
+	}
+	return b
+}
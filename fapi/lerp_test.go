@@ -0,0 +1,169 @@
+package fapi_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/fapi"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+func TestLerp(t *testing.T) {
+	a := &pf{
+		Flag:    false,
+		List:    0,
+		Int:     -10,
+		Angle:   3,
+		Real:    -1,
+		BReal:   -1,
+		Complex: 1 - 1i,
+		Vec3:    [3]float64{0, 1, 2},
+		Affine:  xmath.Affine{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0},
+		Func:    &pf{Real: 0},
+		Funcs:   []xirho.Func{&pf{Real: 10}},
+		String:  "madoka",
+	}
+	b := &pf{
+		Flag:    true,
+		List:    2,
+		Int:     10,
+		Angle:   -3,
+		Real:    1,
+		BReal:   1,
+		Complex: 3 + 1i,
+		Vec3:    [3]float64{2, 3, 4},
+		Affine:  xmath.Affine{3, 2, 2, 2, 2, 3, 2, 2, 2, 2, 3, 2},
+		Func:    &pf{Real: 4},
+		Funcs:   []xirho.Func{&pf{Real: 20}},
+		String:  "homura",
+	}
+	f, err := fapi.Lerp(a, b, 0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := f.(*pf)
+	if v == a || v == b || v.Func == a.Func || v.Func == b.Func || &v.Funcs[0] == &a.Funcs[0] {
+		t.Error("interpolated function shares values with its endpoints")
+	}
+	if v.Flag || v.List != 0 || v.Int != -10 || v.String != "madoka" {
+		t.Errorf("discrete params should be from the start: %v %v %v %q", v.Flag, v.List, v.Int, v.String)
+	}
+	// The short way from 3 to -3 passes through π.
+	if want := xmath.Angle(3 + (2*math.Pi-6)*0.25); math.Abs(v.Angle-want) > 1e-12 {
+		t.Errorf("wrong angle: want %v, got %v", want, v.Angle)
+	}
+	if v.Real != -0.5 || v.BReal != -0.5 {
+		t.Errorf("wrong reals: want -0.5, got %v and %v", v.Real, v.BReal)
+	}
+	if v.Complex != 1.5-0.5i {
+		t.Errorf("wrong complex: want %v, got %v", 1.5-0.5i, v.Complex)
+	}
+	if v.Vec3 != [3]float64{0.5, 1.5, 2.5} {
+		t.Errorf("wrong vec3: want %v, got %v", [3]float64{0.5, 1.5, 2.5}, v.Vec3)
+	}
+	if want := xmath.InterpAffine(a.Affine, b.Affine, 0.25); v.Affine != want {
+		t.Errorf("wrong affine: want %v, got %v", want, v.Affine)
+	}
+	if r := v.Func.(*pf).Real; r != 1 {
+		t.Errorf("wrong func param: want 1, got %v", r)
+	}
+	if r := v.Funcs[0].(*pf).Real; r != 12.5 {
+		t.Errorf("wrong func list param: want 12.5, got %v", r)
+	}
+	if a.Real != -1 || b.Real != 1 || a.Func.(*pf).Real != 0 {
+		t.Error("interpolation modified its endpoints")
+	}
+	f, err = fapi.Lerp(a, b, 0.75)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v = f.(*pf)
+	if !v.Flag || v.List != 2 || v.Int != 10 || v.String != "homura" {
+		t.Errorf("discrete params should be from the end: %v %v %v %q", v.Flag, v.List, v.Int, v.String)
+	}
+}
+
+func TestLerpMismatch(t *testing.T) {
+	cases := map[string]struct {
+		a, b xirho.Func
+	}{
+		"types":  {&pf{}, &uf{}},
+		"nil":    {&pf{Func: &pf{}}, &pf{}},
+		"length": {&pf{Funcs: []xirho.Func{&pf{}}}, &pf{}},
+		"nested": {&pf{Funcs: []xirho.Func{&pf{}}}, &pf{Funcs: []xirho.Func{&uf{}}}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := fapi.Lerp(c.a, c.b, 0.5)
+			var m fapi.Mismatch
+			if !errors.As(err, &m) {
+				t.Errorf("wrong error: want Mismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLerpSystem(t *testing.T) {
+	a := xirho.System{
+		Nodes: []xirho.Node{
+			{Func: &pf{Real: 0}, Opacity: 1, Weight: 1, Graph: []float64{0}, Label: "a"},
+			{Func: &pf{Real: 0}, Opacity: 0, Weight: 3},
+		},
+	}
+	b := xirho.System{
+		Nodes: []xirho.Node{
+			{Func: &pf{Real: 2}, Opacity: 0, Weight: 3, Label: "b"},
+			{Func: &pf{Real: 2}, Opacity: 1, Weight: 1},
+		},
+	}
+	s, err := fapi.LerpSystem(a, b, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range s.Nodes {
+		if n.Opacity != 0.5 || n.Weight != 2 || n.Func.(*pf).Real != 1 {
+			t.Errorf("wrong node %d: %+v", i, n)
+		}
+	}
+	if len(s.Nodes[0].Graph) != 1 || s.Nodes[0].Graph[0] != 0.5 {
+		t.Errorf("wrong graph: want [0.5], got %v", s.Nodes[0].Graph)
+	}
+	if s.Nodes[1].Graph != nil {
+		t.Errorf("graph should be nil, got %v", s.Nodes[1].Graph)
+	}
+	if s.Nodes[0].Label != "a" {
+		t.Errorf("wrong label: want a, got %q", s.Nodes[0].Label)
+	}
+	b.Nodes = b.Nodes[:1]
+	if _, err := fapi.LerpSystem(a, b, 0.5); err == nil {
+		t.Error("no error interpolating systems with different node counts")
+	}
+	if _, err := fapi.Motion(a, b, xmath.Eye(), xmath.Eye()); err == nil {
+		t.Error("no error creating motion between systems with different node counts")
+	}
+	b.Nodes = append(b.Nodes, xirho.Node{Func: &pf{}})
+	b.Final = &pf{}
+	if _, err := fapi.LerpSystem(a, b, 0.5); err == nil {
+		t.Error("no error interpolating systems with and without finals")
+	}
+}
+
+func TestMotion(t *testing.T) {
+	a := xirho.System{Nodes: []xirho.Node{{Func: &pf{Real: 0}, Opacity: 1, Weight: 1}}}
+	b := xirho.System{Nodes: []xirho.Node{{Func: &pf{Real: 4}, Opacity: 1, Weight: 1}}}
+	ca, cb := xmath.Eye(), xmath.Eye()
+	cb.Translate(4, 0, 0)
+	m, err := fapi.Motion(a, b, ca, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, c := m(0.25)
+	if r := s.Nodes[0].Func.(*pf).Real; r != 1 {
+		t.Errorf("wrong param: want 1, got %v", r)
+	}
+	if x, _, _ := xmath.Tx(&c, 0, 0, 0); x != 1 {
+		t.Errorf("wrong camera translation: want 1, got %v", x)
+	}
+}
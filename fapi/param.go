@@ -2,6 +2,7 @@ package fapi
 
 import (
 	"math"
+	"unicode/utf8"
 
 	"github.com/zephyrtronium/xirho"
 	"github.com/zephyrtronium/xirho/xmath"
@@ -367,6 +368,64 @@ func (p Affine) Get() xmath.Affine {
 	return *p.v
 }
 
+// String is a textual function parameter. After the parameter name, a String
+// field may include additional comma-separated tags: "path" to indicate that
+// the string names a file, and a positive integer to limit the length of the
+// string in characters. For example, to define a parameter naming a file with
+// a name of at most 4096 characters, do:
+//
+//	type Example struct {
+//		File string `xirho:"file,path,4096"`
+//	}
+type String struct {
+	v *string
+	// path indicates whether external interfaces should treat the value as
+	// a file path.
+	path bool
+	// max is the maximum length in characters, or 0 if unlimited.
+	max int
+
+	paramName
+}
+
+// stringFor creates a String function parameter.
+func stringFor(name string, v *string, path bool, limit int) Param {
+	return String{
+		v:         v,
+		paramName: paramName(name),
+		path:      path,
+		max:       limit,
+	}
+}
+
+// Set sets the string value. If the String has a maximum length and v is
+// longer, an error of type TooLong is returned instead.
+func (p String) Set(v string) error {
+	if p.max > 0 {
+		if n := utf8.RuneCountInString(v); n > p.max {
+			return TooLong{Param: p, Len: n, Max: p.max}
+		}
+	}
+	*p.v = v
+	return nil
+}
+
+// Get gets the string value.
+func (p String) Get() string {
+	return *p.v
+}
+
+// IsPath returns whether the string names a file.
+func (p String) IsPath() bool {
+	return p.path
+}
+
+// MaxLen returns the maximum length of the string in characters, or 0 if the
+// length is unlimited.
+func (p String) MaxLen() int {
+	return p.max
+}
+
 // Func is a function parameter that is itself a function. After the parameter
 // name, a Func field may include an additional comma-separated tag containing
 // the string "optional". Func fields marked optional may be set to nil. For
@@ -450,5 +509,6 @@ func (Real) isParam()     { panic(nil) }
 func (Complex) isParam()  { panic(nil) }
 func (Vec3) isParam()     { panic(nil) }
 func (Affine) isParam()   { panic(nil) }
+func (String) isParam()   { panic(nil) }
 func (Func) isParam()     { panic(nil) }
 func (FuncList) isParam() { panic(nil) }
@@ -230,6 +230,33 @@ func TestSetAffine(t *testing.T) {
 	}
 }
 
+func TestSetString(t *testing.T) {
+	for _, c := range typeCases {
+		if c.param == reflect.TypeOf(fapi.String{}) {
+			if len(c.set) == 0 {
+				t.Log("no set cases in", c)
+				continue
+			}
+			t.Run(c.name, func(t *testing.T) {
+				api := fapi.For(c.v)
+				if len(api) != 1 {
+					t.Fatalf("wrong number of fields on %#v: expected 1, have %d", c.v, len(api))
+				}
+				p := api[0].(fapi.String)
+				for i, s := range c.set {
+					err := p.Set(s.set.(string))
+					if (err != nil && s.err != nil && !errors.As(err, &s.err)) || (err == nil && s.err != nil) || (err != nil && s.err == nil) {
+						t.Errorf("wrong error for set case %d: expected %T, got %T", i, s.err, err)
+					}
+					if s.get != p.Get() {
+						t.Errorf("wrong get after set %q (with expected error %T): expected %q, got %q", s.set, s.err, s.get, p.Get())
+					}
+				}
+			})
+		}
+	}
+}
+
 func TestSetFunc(t *testing.T) {
 	for _, c := range typeCases {
 		if c.param == reflect.TypeOf(fapi.Func{}) {
@@ -378,6 +405,35 @@ func TestRealBounds(t *testing.T) {
 	}
 }
 
+func TestStringOpts(t *testing.T) {
+	cases := map[string]struct {
+		v    xirho.Func
+		path bool
+		max  int
+	}{
+		"plain":    {v: new(testString), path: false, max: 0},
+		"unnamed":  {v: new(testStringUnnamed), path: false, max: 0},
+		"path":     {v: new(testStringPath), path: true, max: 0},
+		"max":      {v: new(testStringMax), path: false, max: 3},
+		"path_max": {v: new(testStringPathMax), path: true, max: 8},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			api := fapi.For(c.v)
+			if len(api) != 1 {
+				t.Fatalf("wrong number of fields on %#v: expected 1, have %d", c.v, len(api))
+			}
+			p := api[0].(fapi.String)
+			if p.IsPath() != c.path {
+				t.Errorf("%#v has path=%v but expected path=%v", c.v, p.IsPath(), c.path)
+			}
+			if p.MaxLen() != c.max {
+				t.Errorf("%#v has max length %d but expected %d", c.v, p.MaxLen(), c.max)
+			}
+		})
+	}
+}
+
 func TestFuncOptional(t *testing.T) {
 	cases := map[string]struct {
 		v xirho.Func
@@ -145,6 +145,28 @@ type (
 		V xmath.Affine `xirho:"test,ignore"` // ok
 	}
 
+	testString struct {
+		V string `xirho:"test"` // ok
+	}
+	testStringUnnamed struct {
+		V string `xirho:""` // ok, named V
+	}
+	testStringPath struct {
+		V string `xirho:"test,path"` // ok
+	}
+	testStringMax struct {
+		V string `xirho:"test,3"` // ok
+	}
+	testStringPathMax struct {
+		V string `xirho:"test,path,8"` // ok
+	}
+	testStringBad struct {
+		V string `xirho:"test,ignore"` // error
+	}
+	testStringZero struct {
+		V string `xirho:"test,0"` // error
+	}
+
 	testFunc struct {
 		V xirho.Func `xirho:"test"` // ok
 	}
@@ -663,6 +685,68 @@ var typeCases = []struct {
 			{set: xmath.Affine{11: math.Inf(0)}, get: xmath.Eye(), err: new(fapi.NotFinite)},
 		},
 	},
+	{
+		name:  "string",
+		v:     new(testString),
+		param: reflect.TypeOf(fapi.String{}),
+		field: "test",
+		set: []setCase{
+			{set: "madoka", get: "madoka"},
+			{set: "", get: ""},
+			{set: "ほむら", get: "ほむら"},
+		},
+	},
+	{
+		name:  "stringUnnamed",
+		v:     new(testStringUnnamed),
+		param: reflect.TypeOf(fapi.String{}),
+		field: "V",
+		set: []setCase{
+			{set: "madoka", get: "madoka"},
+		},
+	},
+	{
+		name:  "stringPath",
+		v:     new(testStringPath),
+		param: reflect.TypeOf(fapi.String{}),
+		field: "test",
+		set: []setCase{
+			{set: "/tmp/madoka.png", get: "/tmp/madoka.png"},
+		},
+	},
+	{
+		name:  "stringMax",
+		v:     new(testStringMax),
+		param: reflect.TypeOf(fapi.String{}),
+		field: "test",
+		set: []setCase{
+			{set: "abc", get: "abc"},
+			{set: "abcd", get: "abc", err: new(fapi.TooLong)},
+			{set: "ほむら", get: "ほむら"},
+			{set: "", get: ""},
+			{set: "ほむらちゃん", get: "", err: new(fapi.TooLong)},
+		},
+	},
+	{
+		name:  "stringPathMax",
+		v:     new(testStringPathMax),
+		param: reflect.TypeOf(fapi.String{}),
+		field: "test",
+		set: []setCase{
+			{set: "a.png", get: "a.png"},
+			{set: "madoka.png", get: "a.png", err: new(fapi.TooLong)},
+		},
+	},
+	{
+		name:  "stringBad",
+		v:     new(testStringBad),
+		param: nil,
+	},
+	{
+		name:  "stringZero",
+		v:     new(testStringZero),
+		param: nil,
+	},
 	{
 		name:  "func",
 		v:     new(testFunc),
@@ -792,6 +876,13 @@ func (*testVec3Extra) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt            { re
 func (*testAffine) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt               { return xirho.Pt{} }
 func (*testAffineUnnamed) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt        { return xirho.Pt{} }
 func (*testAffineExtra) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt          { return xirho.Pt{} }
+func (*testString) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt               { return xirho.Pt{} }
+func (*testStringUnnamed) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt        { return xirho.Pt{} }
+func (*testStringPath) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt           { return xirho.Pt{} }
+func (*testStringMax) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt            { return xirho.Pt{} }
+func (*testStringPathMax) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt        { return xirho.Pt{} }
+func (*testStringBad) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt            { return xirho.Pt{} }
+func (*testStringZero) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt           { return xirho.Pt{} }
 func (*testFunc) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt                 { return xirho.Pt{} }
 func (*testFuncUnnamed) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt          { return xirho.Pt{} }
 func (*testFuncOptional) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt         { return xirho.Pt{} }
@@ -844,6 +935,13 @@ func (*testVec3Extra) Prep()            {}
 func (*testAffine) Prep()               {}
 func (*testAffineUnnamed) Prep()        {}
 func (*testAffineExtra) Prep()          {}
+func (*testString) Prep()               {}
+func (*testStringUnnamed) Prep()        {}
+func (*testStringPath) Prep()           {}
+func (*testStringMax) Prep()            {}
+func (*testStringPathMax) Prep()        {}
+func (*testStringBad) Prep()            {}
+func (*testStringZero) Prep()           {}
 func (*testFunc) Prep()                 {}
 func (*testFuncUnnamed) Prep()          {}
 func (*testFuncOptional) Prep()         {}
@@ -0,0 +1,160 @@
+package hdr
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Compression is a compression method for OpenEXR files.
+type Compression uint8
+
+const (
+	// NoCompression stores pixel data uncompressed.
+	NoCompression Compression = 0
+	// ZIPCompression compresses blocks of 16 scanlines with zlib after
+	// applying a byte predictor.
+	ZIPCompression Compression = 3
+)
+
+// lines returns the number of scanlines per chunk for the compression method.
+func (c Compression) lines() int {
+	if c == ZIPCompression {
+		return 16
+	}
+	return 1
+}
+
+// exrChannels is the channels written to OpenEXR files. Channels must be
+// stored in alphabetical order. The values are indices into a pixel.
+var exrChannels = [...]struct {
+	name string
+	idx  int
+}{{"A", 3}, {"B", 2}, {"G", 1}, {"R", 0}}
+
+// EncodeEXR writes an image as a single-part scanline OpenEXR file with
+// 32-bit floating-point channels.
+func EncodeEXR(w io.Writer, m *Image, c Compression) error {
+	if c != NoCompression && c != ZIPCompression {
+		return fmt.Errorf("xirho: unsupported OpenEXR compression %d", c)
+	}
+	dx, dy := m.Rect.Dx(), m.Rect.Dy()
+	if dx <= 0 || dy <= 0 {
+		return fmt.Errorf("xirho: cannot encode empty image as OpenEXR")
+	}
+	var hdr bytes.Buffer
+	// Magic number and version 2, single-part scanline.
+	hdr.Write([]byte{0x76, 0x2f, 0x31, 0x01, 2, 0, 0, 0})
+	var ch bytes.Buffer
+	for _, c := range exrChannels {
+		ch.WriteString(c.name)
+		ch.WriteByte(0)
+		// pixel type FLOAT, pLinear, reserved, x and y sampling
+		binary.Write(&ch, binary.LittleEndian, [...]int32{2, 0, 1, 1})
+	}
+	ch.WriteByte(0)
+	exrAttr(&hdr, "channels", "chlist", ch.Bytes())
+	exrAttr(&hdr, "compression", "compression", []byte{byte(c)})
+	win := exrInts(0, 0, int32(dx-1), int32(dy-1))
+	exrAttr(&hdr, "dataWindow", "box2i", win)
+	exrAttr(&hdr, "displayWindow", "box2i", win)
+	exrAttr(&hdr, "lineOrder", "lineOrder", []byte{0})
+	exrAttr(&hdr, "pixelAspectRatio", "float", exrInts(int32(math.Float32bits(1))))
+	exrAttr(&hdr, "screenWindowCenter", "v2f", exrInts(0, 0))
+	exrAttr(&hdr, "screenWindowWidth", "float", exrInts(int32(math.Float32bits(1))))
+	hdr.WriteByte(0)
+
+	// Encode all chunks first so the offset table can be written up front.
+	lines := c.lines()
+	n := (dy + lines - 1) / lines
+	chunks := make([][]byte, n)
+	raw := make([]byte, 0, 4*len(exrChannels)*dx*lines)
+	var zbuf bytes.Buffer
+	for i := range chunks {
+		y0 := i * lines
+		raw = raw[:0]
+		for y := y0; y < y0+lines && y < dy; y++ {
+			p := m.Pix[m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y+y):]
+			for _, c := range exrChannels {
+				for x := 0; x < dx; x++ {
+					raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(p[4*x+c.idx]))
+				}
+			}
+		}
+		data := raw
+		if c == ZIPCompression {
+			zbuf.Reset()
+			if err := exrZip(&zbuf, raw); err != nil {
+				return err
+			}
+			// Data that doesn't compress is stored raw.
+			if zbuf.Len() < len(raw) {
+				data = zbuf.Bytes()
+			}
+		}
+		chunk := make([]byte, 8, 8+len(data))
+		binary.LittleEndian.PutUint32(chunk, uint32(y0))
+		binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+		chunks[i] = append(chunk, data...)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(hdr.Bytes())
+	off := uint64(hdr.Len() + 8*n)
+	for _, chunk := range chunks {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], off)
+		bw.Write(b[:])
+		off += uint64(len(chunk))
+	}
+	for _, chunk := range chunks {
+		bw.Write(chunk)
+	}
+	return bw.Flush()
+}
+
+// exrAttr writes a header attribute.
+func exrAttr(w *bytes.Buffer, name, typ string, val []byte) {
+	w.WriteString(name)
+	w.WriteByte(0)
+	w.WriteString(typ)
+	w.WriteByte(0)
+	binary.Write(w, binary.LittleEndian, int32(len(val)))
+	w.Write(val)
+}
+
+// exrInts encodes 32-bit integers in little-endian order.
+func exrInts(v ...int32) []byte {
+	b := make([]byte, 0, 4*len(v))
+	for _, x := range v {
+		b = binary.LittleEndian.AppendUint32(b, uint32(x))
+	}
+	return b
+}
+
+// exrZip compresses a chunk as OpenEXR's ZIP compression does. The bytes are
+// split into even and odd halves, each byte is replaced by its difference
+// from the previous one, and the result is compressed with zlib.
+func exrZip(w io.Writer, raw []byte) error {
+	t := make([]byte, len(raw))
+	h := (len(raw) + 1) / 2
+	for i, b := range raw {
+		if i%2 == 0 {
+			t[i/2] = b
+		} else {
+			t[h+i/2] = b
+		}
+	}
+	for i := len(t) - 1; i > 0; i-- {
+		t[i] = t[i] - t[i-1] + 128
+	}
+	z := zlib.NewWriter(w)
+	if _, err := z.Write(t); err != nil {
+		return err
+	}
+	return z.Close()
+}
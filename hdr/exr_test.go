@@ -0,0 +1,152 @@
+package hdr_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/zephyrtronium/xirho/hdr"
+)
+
+// testImage creates an image with a variety of values, including values
+// outside [0, 1].
+func testImage(w, h int) *hdr.Image {
+	m := hdr.NewImage(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := float32(x) / float32(w)
+			m.SetRGBA(x, y, a*float32(y), a*0.5, -a, a)
+		}
+	}
+	return m
+}
+
+// decodeEXR decodes the subset of OpenEXR written by EncodeEXR.
+func decodeEXR(t *testing.T, b []byte) *hdr.Image {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte{0x76, 0x2f, 0x31, 0x01, 2, 0, 0, 0}) {
+		t.Fatalf("bad magic or version: %x", b[:8])
+	}
+	p := b[8:]
+	cstr := func() string {
+		i := bytes.IndexByte(p, 0)
+		s := string(p[:i])
+		p = p[i+1:]
+		return s
+	}
+	attrs := make(map[string][]byte)
+	for {
+		name := cstr()
+		if name == "" {
+			break
+		}
+		cstr() // type
+		n := binary.LittleEndian.Uint32(p)
+		attrs[name] = p[4 : 4+n]
+		p = p[4+n:]
+	}
+	var chans []string
+	for c := attrs["channels"]; c[0] != 0; c = c[17:] {
+		i := bytes.IndexByte(c, 0)
+		chans = append(chans, string(c[:i]))
+		if typ := binary.LittleEndian.Uint32(c[i+1:]); typ != 2 {
+			t.Errorf("channel %s has type %d, not FLOAT", c[:i], typ)
+		}
+		c = c[i:]
+	}
+	win := attrs["dataWindow"]
+	w := int(int32(binary.LittleEndian.Uint32(win[8:]))) + 1
+	h := int(int32(binary.LittleEndian.Uint32(win[12:]))) + 1
+	lines := 1
+	comp := attrs["compression"][0]
+	if comp == 3 {
+		lines = 16
+	}
+	m := hdr.NewImage(image.Rect(0, 0, w, h))
+	n := (h + lines - 1) / lines
+	for i := 0; i < n; i++ {
+		off := binary.LittleEndian.Uint64(p[8*i:])
+		chunk := b[off:]
+		y0 := int(binary.LittleEndian.Uint32(chunk))
+		sz := binary.LittleEndian.Uint32(chunk[4:])
+		data := chunk[8 : 8+sz]
+		nl := min(lines, h-y0)
+		want := 4 * len(chans) * w * nl
+		if comp == 3 && len(data) < want {
+			z, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := io.ReadAll(z)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i < len(u); i++ {
+				u[i] = u[i-1] + u[i] - 128
+			}
+			data = make([]byte, len(u))
+			half := (len(u) + 1) / 2
+			for i := range data {
+				if i%2 == 0 {
+					data[i] = u[i/2]
+				} else {
+					data[i] = u[half+i/2]
+				}
+			}
+		}
+		if len(data) != want {
+			t.Fatalf("chunk %d has %d bytes, want %d", i, len(data), want)
+		}
+		for y := y0; y < y0+nl; y++ {
+			for _, c := range chans {
+				idx := map[string]int{"R": 0, "G": 1, "B": 2, "A": 3}[c]
+				for x := 0; x < w; x++ {
+					m.Pix[m.PixOffset(x, y)+idx] = math.Float32frombits(binary.LittleEndian.Uint32(data))
+					data = data[4:]
+				}
+			}
+		}
+	}
+	return m
+}
+
+func TestEXR(t *testing.T) {
+	cases := []struct {
+		name string
+		c    hdr.Compression
+		w, h int
+	}{
+		{"none", hdr.NoCompression, 7, 5},
+		{"zip", hdr.ZIPCompression, 37, 40},
+		{"zip-small", hdr.ZIPCompression, 1, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := testImage(c.w, c.h)
+			var b bytes.Buffer
+			if err := hdr.EncodeEXR(&b, m, c.c); err != nil {
+				t.Fatal(err)
+			}
+			r := decodeEXR(t, b.Bytes())
+			if !r.Rect.Eq(m.Rect) {
+				t.Fatalf("wrong bounds: want %v, got %v", m.Rect, r.Rect)
+			}
+			for i := range m.Pix {
+				if m.Pix[i] != r.Pix[i] {
+					t.Errorf("wrong value at %d: want %g, got %g", i, m.Pix[i], r.Pix[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEXREmpty(t *testing.T) {
+	m := hdr.NewImage(image.Rectangle{})
+	if err := hdr.EncodeEXR(io.Discard, m, hdr.NoCompression); err == nil {
+		t.Error("no error encoding empty image")
+	}
+}
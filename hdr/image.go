@@ -0,0 +1,59 @@
+// Package hdr implements high dynamic range images and encoders for them.
+package hdr
+
+import "image"
+
+// Image is a linear floating-point RGBA image. Colors are premultiplied by
+// alpha, and they are unbounded, so that brightness above 1 is preserved for
+// grading after rendering.
+type Image struct {
+	// Pix holds the image's pixels in R, G, B, A order. The pixel at (x, y)
+	// starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4].
+	Pix []float32
+	// Stride is the Pix stride between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewImage allocates a new image with the given bounds.
+func NewImage(r image.Rectangle) *Image {
+	return &Image{
+		Pix:    make([]float32, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// Bounds returns the image's bounds.
+func (m *Image) Bounds() image.Rectangle {
+	return m.Rect
+}
+
+// PixOffset returns the index of the first element of Pix corresponding to
+// the pixel at (x, y).
+func (m *Image) PixOffset(x, y int) int {
+	return (y-m.Rect.Min.Y)*m.Stride + (x-m.Rect.Min.X)*4
+}
+
+// RGBAAt returns the color of the pixel at (x, y). The result is zero if the
+// point is outside the image.
+func (m *Image) RGBAAt(x, y int) (r, g, b, a float32) {
+	if !(image.Point{X: x, Y: y}.In(m.Rect)) {
+		return 0, 0, 0, 0
+	}
+	i := m.PixOffset(x, y)
+	s := m.Pix[i : i+4 : i+4]
+	return s[0], s[1], s[2], s[3]
+}
+
+// SetRGBA sets the color of the pixel at (x, y). It does nothing if the point
+// is outside the image.
+func (m *Image) SetRGBA(x, y int, r, g, b, a float32) {
+	if !(image.Point{X: x, Y: y}.In(m.Rect)) {
+		return
+	}
+	i := m.PixOffset(x, y)
+	s := m.Pix[i : i+4 : i+4]
+	s[0], s[1], s[2], s[3] = r, g, b, a
+}
@@ -0,0 +1,34 @@
+package hdr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// EncodePFM writes an image in the Portable Float Map format. PFM has no
+// alpha channel, so the image is written as if composited onto black.
+func EncodePFM(w io.Writer, m *Image) error {
+	bw := bufio.NewWriter(w)
+	dx, dy := m.Rect.Dx(), m.Rect.Dy()
+	// A negative scale indicates little-endian samples.
+	if _, err := fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", dx, dy); err != nil {
+		return err
+	}
+	row := make([]byte, 12*dx)
+	// Rows are stored from bottom to top.
+	for y := m.Rect.Max.Y - 1; y >= m.Rect.Min.Y; y-- {
+		p := m.Pix[m.PixOffset(m.Rect.Min.X, y):]
+		for x := 0; x < dx; x++ {
+			for c := 0; c < 3; c++ {
+				binary.LittleEndian.PutUint32(row[12*x+4*c:], math.Float32bits(p[4*x+c]))
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
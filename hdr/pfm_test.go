@@ -0,0 +1,46 @@
+package hdr_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/zephyrtronium/xirho/hdr"
+)
+
+func TestPFM(t *testing.T) {
+	m := testImage(9, 4)
+	var b bytes.Buffer
+	if err := hdr.EncodePFM(&b, m); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(&b)
+	var w, h int
+	var scale float64
+	if _, err := fmt.Fscanf(r, "PF\n%d %d\n%f\n", &w, &h, &scale); err != nil {
+		t.Fatal(err)
+	}
+	if w != 9 || h != 4 || scale >= 0 {
+		t.Fatalf("wrong header: %dx%d scale %g", w, h, scale)
+	}
+	for y := h - 1; y >= 0; y-- {
+		for x := 0; x < w; x++ {
+			var v [3]uint32
+			if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+				t.Fatal(err)
+			}
+			pr, pg, pb, _ := m.RGBAAt(x, y)
+			for c, want := range [3]float32{pr, pg, pb} {
+				if got := math.Float32frombits(v[c]); got != want {
+					t.Errorf("wrong channel %d at %d,%d: want %g, got %g", c, x, y, want, got)
+				}
+			}
+		}
+	}
+	if n, _ := r.Read(make([]byte, 1)); n != 0 {
+		t.Error("extra data after image")
+	}
+}
@@ -0,0 +1,176 @@
+package hist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Checkpoint holds the render state saved alongside a histogram's contents so
+// that the render can be resumed later.
+type Checkpoint struct {
+	// Iters is the number of iterations performed to produce the histogram.
+	Iters int64
+	// Hits is the number of points plotted onto the histogram.
+	Hits int64
+	// Extra is arbitrary data saved with the checkpoint, typically the
+	// encoded system being rendered.
+	Extra []byte
+}
+
+// checkpointMagic identifies histogram checkpoints.
+const checkpointMagic = "xirhohst"
+
+// checkpointVersion is the current checkpoint format version.
+const checkpointVersion = 1
+
+// maxExtra is the maximum length of extra checkpoint data that
+// ReadCheckpoint will accept, to avoid huge allocations on corrupt input.
+const maxExtra = 1 << 30
+
+// WriteCheckpoint writes the histogram contents and render state in a
+// versioned format which ReadCheckpoint can load. The format is as follows,
+// with all integers encoded as 8-byte little-endian words:
+//
+//   - the eight bytes "xirhohst";
+//   - the format version, currently 1;
+//   - the histogram width, height, and oversampling factor;
+//   - the iteration count and hit count;
+//   - the length of the extra data, followed by the extra data itself;
+//   - the bins, in the same format as WriteTo.
+//
+// It is not safe to call WriteCheckpoint while the histogram may be plotted
+// onto.
+func (h *Hist) WriteCheckpoint(w io.Writer, c Checkpoint) (n int64, err error) {
+	b := make([]byte, len(checkpointMagic), len(checkpointMagic)+7*8)
+	copy(b, checkpointMagic)
+	sz := h.Size()
+	for _, x := range []uint64{checkpointVersion, uint64(sz.W), uint64(sz.H), uint64(sz.OSA), uint64(c.Iters), uint64(c.Hits), uint64(len(c.Extra))} {
+		b = binary.LittleEndian.AppendUint64(b, x)
+	}
+	k, err := w.Write(b)
+	n += int64(k)
+	if err != nil {
+		return n, err
+	}
+	k, err = w.Write(c.Extra)
+	n += int64(k)
+	if err != nil {
+		return n, err
+	}
+	m, err := h.writeBins(w)
+	return n + m, err
+}
+
+// ReadCheckpoint loads histogram contents and render state written by
+// WriteCheckpoint, resizing the histogram as needed. If r is an io.Seeker, the
+// size recorded in the checkpoint is checked against the data remaining in r
+// before any memory is allocated for it. If an error occurs, the histogram
+// contents are unspecified.
+//
+// It is not safe to call ReadCheckpoint while the histogram may be plotted
+// onto.
+func (h *Hist) ReadCheckpoint(r io.Reader) (Checkpoint, error) {
+	sz, c, err := readCheckpointHeader(r)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	sz.Storage = h.storage
+	h.Reset(sz)
+	if _, err := h.readBins(r); err != nil {
+		return Checkpoint{}, err
+	}
+	return c, nil
+}
+
+// MergeCheckpoint adds the histogram contents of a checkpoint written by
+// WriteCheckpoint into h. The checkpoint must have the same size as h. The
+// returned Checkpoint holds the render state of the merged checkpoint alone.
+// If an error occurs after the size is verified, the histogram contents are
+// unspecified.
+//
+// It is safe to call MergeCheckpoint while the histogram may be plotted onto.
+func (h *Hist) MergeCheckpoint(r io.Reader) (Checkpoint, error) {
+	sz, c, err := readCheckpointHeader(r)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	sz.Storage = h.storage
+	if sz != h.Size() {
+		return Checkpoint{}, fmt.Errorf("xirho: cannot merge %dx%d:%d histogram checkpoint into %dx%d:%d histogram", sz.W, sz.H, sz.OSA, h.Width(), h.Height(), h.OSA())
+	}
+	if _, err := h.readBins(r); err != nil {
+		return Checkpoint{}, err
+	}
+	return c, nil
+}
+
+// readCheckpointHeader reads a checkpoint header, returning the histogram size
+// and render state. The bins follow the header.
+func readCheckpointHeader(r io.Reader) (Size, Checkpoint, error) {
+	b := make([]byte, len(checkpointMagic)+7*8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Size{}, Checkpoint{}, err
+	}
+	if !bytes.Equal(b[:len(checkpointMagic)], []byte(checkpointMagic)) {
+		return Size{}, Checkpoint{}, fmt.Errorf("xirho: not a histogram checkpoint")
+	}
+	b = b[len(checkpointMagic):]
+	var v [7]uint64
+	for i := range v {
+		v[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	if v[0] != checkpointVersion {
+		return Size{}, Checkpoint{}, fmt.Errorf("xirho: unsupported histogram checkpoint version %d", v[0])
+	}
+	sz := Size{W: int(v[1]), H: int(v[2]), OSA: int(v[3])}
+	if sz.W < 0 || sz.H < 0 || sz.OSA < 0 || sz.Overflows() {
+		return Size{}, Checkpoint{}, fmt.Errorf("xirho: invalid histogram checkpoint size %dx%d:%d", v[1], v[2], v[3])
+	}
+	if v[6] > maxExtra {
+		return Size{}, Checkpoint{}, fmt.Errorf("xirho: histogram checkpoint extra data too long (%d bytes)", v[6])
+	}
+	if rem, ok := remaining(r); ok {
+		// Every bin is written as four 8-byte words regardless of storage.
+		if need := v[6] + uint64(sz.Bins())*32; uint64(rem) < need {
+			return Size{}, Checkpoint{}, fmt.Errorf("xirho: histogram checkpoint needs %d bytes but only %d remain", need, rem)
+		}
+	}
+	c := Checkpoint{
+		Iters: int64(v[4]),
+		Hits:  int64(v[5]),
+		Extra: make([]byte, v[6]),
+	}
+	if _, err := io.ReadFull(r, c.Extra); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Size{}, Checkpoint{}, err
+	}
+	return sz, c, nil
+}
+
+// remaining returns the number of bytes left to read from r if r is an
+// io.Seeker. The read position is unchanged.
+func remaining(r io.Reader) (int64, bool) {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	cur, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := s.Seek(cur, io.SeekStart); err != nil {
+		return 0, false
+	}
+	return end - cur, true
+}
@@ -0,0 +1,180 @@
+package hist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"io"
+	"testing"
+)
+
+// fill plots a distinct color into every bin of a histogram.
+func fill(h *Hist) {
+	for y := 0; y < h.Rows(); y++ {
+		for x := 0; x < h.Cols(); x++ {
+			k := uint16(y*h.Cols() + x)
+			h.Add(x, y, color.RGBA64{R: k, G: 2 * k, B: 3 * k, A: 4*k + 1})
+		}
+	}
+}
+
+// samebins returns whether two histograms have identical bins.
+func samebins(a, b *Hist) bool {
+	if a.bins() != b.bins() {
+		return false
+	}
+	for i := 0; i < a.bins(); i++ {
+		r0, g0, b0, n0 := a.get(i)
+		r1, g1, b1, n1 := b.get(i)
+		if r0 != r1 || g0 != g1 || b0 != b1 || n0 != n1 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCheckpoint(t *testing.T) {
+	sizes := []Size{
+		{W: 0, H: 0, OSA: 1},
+		{W: 3, H: 2, OSA: 1},
+		{W: 3, H: 2, OSA: 3},
+		{W: 40, H: 50, OSA: 2},
+	}
+	for _, sz := range sizes {
+		h := New(sz)
+		fill(h)
+		c := Checkpoint{Iters: 12345, Hits: 678, Extra: []byte(`{"funcs":[]}`)}
+		var b bytes.Buffer
+		n, err := h.WriteCheckpoint(&b, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(b.Len()) {
+			t.Errorf("%v: wrote %d bytes but reported %d", sz, b.Len(), n)
+		}
+		r := New(Size{W: 1, H: 1, OSA: 1})
+		d, err := r.ReadCheckpoint(&b)
+		if err != nil {
+			t.Fatalf("%v: %v", sz, err)
+		}
+		if r.Size() != h.Size() {
+			t.Errorf("%v: wrong size after read: %v", sz, r.Size())
+		}
+		if d.Iters != c.Iters || d.Hits != c.Hits || !bytes.Equal(d.Extra, c.Extra) {
+			t.Errorf("%v: wrong checkpoint: want %+v, got %+v", sz, c, d)
+		}
+		if !samebins(h, r) {
+			t.Errorf("%v: bins differ after read", sz)
+		}
+	}
+}
+
+func TestCheckpointBad(t *testing.T) {
+	h := New(Size{W: 2, H: 2, OSA: 1})
+	fill(h)
+	var b bytes.Buffer
+	if _, err := h.WriteCheckpoint(&b, Checkpoint{Iters: 1, Hits: 1}); err != nil {
+		t.Fatal(err)
+	}
+	good := b.Bytes()
+	// A size far larger than the data must be rejected before allocating.
+	huge := append([]byte{}, good...)
+	binary.LittleEndian.PutUint64(huge[16:], 1<<20)
+	binary.LittleEndian.PutUint64(huge[24:], 1<<20)
+	cases := map[string][]byte{
+		"empty":     nil,
+		"magic":     append([]byte("xirhoHST"), good[8:]...),
+		"version":   append(append(append([]byte{}, good[:8]...), 2, 0, 0, 0, 0, 0, 0, 0), good[16:]...),
+		"truncated": good[:len(good)-1],
+		"header":    good[:20],
+		"size":      huge,
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := New(Size{})
+			if _, err := r.ReadCheckpoint(bytes.NewReader(c)); err == nil {
+				t.Error("no error reading bad checkpoint")
+			}
+		})
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	h := New(Size{W: 4, H: 6, OSA: 2})
+	fill(h)
+	var b bytes.Buffer
+	if _, err := h.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	dump := b.Bytes()
+	r := New(Size{W: 1, H: 1, OSA: 2})
+	n, err := r.ReadFrom(bytes.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(dump)) {
+		t.Errorf("read %d bytes but dump is %d", n, len(dump))
+	}
+	if r.Size() != h.Size() {
+		t.Errorf("wrong size after read: want %v, got %v", h.Size(), r.Size())
+	}
+	if !samebins(h, r) {
+		t.Error("bins differ after read")
+	}
+	// With an oversampling factor that doesn't divide the size, OSA becomes 1.
+	r = New(Size{W: 1, H: 1, OSA: 3})
+	if _, err := r.ReadFrom(bytes.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Size{W: 8, H: 12, OSA: 1}); r.Size() != want {
+		t.Errorf("wrong size after read: want %v, got %v", want, r.Size())
+	}
+	if _, err := r.ReadFrom(bytes.NewReader(dump[:len(dump)-8])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("wrong error reading truncated dump: want %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+	// Without seeking, truncation is found only by reading.
+	if _, err := r.ReadFrom(io.MultiReader(bytes.NewReader(dump[:len(dump)-8]))); err != io.ErrUnexpectedEOF {
+		t.Errorf("wrong error reading truncated stream: want %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+	// A size far larger than the data must be rejected before allocating.
+	huge := append([]byte{}, dump...)
+	binary.LittleEndian.PutUint64(huge[0:], 1<<20)
+	binary.LittleEndian.PutUint64(huge[8:], 1<<20)
+	if _, err := r.ReadFrom(bytes.NewReader(huge)); err == nil {
+		t.Error("no error reading dump with huge size")
+	}
+	if want := (Size{W: 8, H: 12, OSA: 1}); r.Size() != want {
+		t.Errorf("size changed after bad read: want %v, got %v", want, r.Size())
+	}
+}
+
+func TestMergeCheckpoint(t *testing.T) {
+	h := New(Size{W: 3, H: 4, OSA: 2})
+	fill(h)
+	var b bytes.Buffer
+	if _, err := h.WriteCheckpoint(&b, Checkpoint{Iters: 10, Hits: 5}); err != nil {
+		t.Fatal(err)
+	}
+	ck := b.Bytes()
+	r := New(h.Size())
+	for i := 0; i < 2; i++ {
+		c, err := r.MergeCheckpoint(bytes.NewReader(ck))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Iters != 10 || c.Hits != 5 {
+			t.Errorf("wrong checkpoint counts: want 10 iters 5 hits, got %d iters %d hits", c.Iters, c.Hits)
+		}
+	}
+	want := New(h.Size())
+	fill(want)
+	fill(want)
+	if !samebins(want, r) {
+		t.Error("wrong bins after merging twice")
+	}
+	other := New(Size{W: 4, H: 3, OSA: 2})
+	if _, err := other.MergeCheckpoint(bytes.NewReader(ck)); err == nil {
+		t.Error("no error merging checkpoint of different size")
+	}
+}
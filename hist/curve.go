@@ -0,0 +1,136 @@
+package hist
+
+import (
+	"fmt"
+	"math"
+)
+
+// Curve is a tone curve applied to log-scaled bin brightness before gamma
+// scaling. Curves compress the brightest bins so that they can be displayed.
+type Curve uint8
+
+const (
+	// ACES is an approximation of the ACES filmic tone curve. It is the
+	// default curve.
+	ACES Curve = iota
+	// Linear clamps brightness to 1 without otherwise changing it.
+	Linear
+	// Reinhard is the simple Reinhard operator x/(1+x).
+	Reinhard
+	// Hable is John Hable's filmic curve from Uncharted 2.
+	Hable
+	// Log applies no curve, leaving only the logarithmic scaling of the
+	// classic flame algorithm. Bright bins are left to highlight power.
+	Log
+)
+
+// curveNames maps curves to their names.
+var curveNames = [...]string{
+	ACES:     "aces",
+	Linear:   "linear",
+	Reinhard: "reinhard",
+	Hable:    "hable",
+	Log:      "log",
+}
+
+// ParseCurve finds a tone curve by name.
+func ParseCurve(name string) (Curve, error) {
+	for i, s := range curveNames {
+		if s == name {
+			return Curve(i), nil
+		}
+	}
+	return 0, fmt.Errorf("xirho: no tone curve named %q", name)
+}
+
+// String returns the name of the curve.
+func (c Curve) String() string {
+	if int(c) >= len(curveNames) {
+		return fmt.Sprintf("Curve(%d)", uint8(c))
+	}
+	return curveNames[c]
+}
+
+// MarshalText encodes the curve as its name.
+func (c Curve) MarshalText() ([]byte, error) {
+	if int(c) >= len(curveNames) {
+		return nil, fmt.Errorf("xirho: unknown tone curve %d", uint8(c))
+	}
+	return []byte(curveNames[c]), nil
+}
+
+// UnmarshalText decodes a curve from its name.
+func (c *Curve) UnmarshalText(text []byte) error {
+	r, err := ParseCurve(string(text))
+	if err != nil {
+		return err
+	}
+	*c = r
+	return nil
+}
+
+// fn returns the function implementing the curve.
+func (c Curve) fn() func(float64) float64 {
+	switch c {
+	case Linear:
+		return linear
+	case Reinhard:
+		return reinhard
+	case Hable:
+		return hable
+	case Log:
+		return identity
+	default:
+		return aces
+	}
+}
+
+func aces(x float64) float64 {
+	// Approximate ACES filmic tone mapping curve by Krzysztof Narkowicz.
+	// https://knarkowicz.wordpress.com/2016/01/06/aces-filmic-tone-mapping-curve/
+	const (
+		a = 2.51
+		b = 0.03
+		c = 2.43
+		d = 0.59
+		e = 0.14
+	)
+	return (x * (a*x + b)) / (x*(c*x+d) + e)
+}
+
+// linear clamps x to [0, 1]. It, reinhard, and hable treat negative inputs as
+// 0 so that gamma never turns their results into NaN.
+func linear(x float64) float64 {
+	return math.Min(math.Max(x, 0), 1)
+}
+
+func reinhard(x float64) float64 {
+	x = math.Max(x, 0)
+	return x / (1 + x)
+}
+
+func hable(x float64) float64 {
+	// http://filmicworlds.com/blog/filmic-tonemapping-operators/
+	const (
+		bias  = 2
+		white = 11.2
+	)
+	x = math.Max(x, 0)
+	return hablePartial(x*bias) / hablePartial(white)
+}
+
+func hablePartial(x float64) float64 {
+	const (
+		a = 0.15
+		b = 0.50
+		c = 0.10
+		d = 0.20
+		e = 0.02
+		f = 0.30
+	)
+	return (x*(a*x+c*b)+d*e)/(x*(a*x+b)+d*f) - e/f
+}
+
+func identity(x float64) float64 {
+	return x
+}
@@ -0,0 +1,60 @@
+package hist
+
+import "testing"
+
+func TestCurveNames(t *testing.T) {
+	for c := ACES; c <= Log; c++ {
+		b, err := c.MarshalText()
+		if err != nil {
+			t.Errorf("couldn't marshal %v: %v", c, err)
+			continue
+		}
+		var r Curve
+		if err := r.UnmarshalText(b); err != nil {
+			t.Errorf("couldn't unmarshal %q: %v", b, err)
+			continue
+		}
+		if r != c {
+			t.Errorf("wrong curve from %q: want %v, got %v", b, c, r)
+		}
+	}
+	if _, err := (Log + 1).MarshalText(); err == nil {
+		t.Error("no error marshaling unknown curve")
+	}
+	if _, err := ParseCurve("bogus"); err == nil {
+		t.Error("no error parsing unknown curve name")
+	}
+}
+
+func TestCurves(t *testing.T) {
+	for c := ACES; c <= Log; c++ {
+		t.Run(c.String(), func(t *testing.T) {
+			f := c.fn()
+			if y := f(0); y < -1e-12 || y > 1e-12 {
+				t.Errorf("wrong value at 0: want 0, got %g", y)
+			}
+			prev := 0.0
+			for x := 0.01; x < 100; x *= 1.1 {
+				y := f(x)
+				if y < prev {
+					t.Errorf("curve decreases at %g: %g after %g", x, y, prev)
+				}
+				if c != Log && y > 1.5 {
+					t.Errorf("curve too large at %g: %g", x, y)
+				}
+				prev = y
+			}
+		})
+	}
+}
+
+func TestCurvesNegative(t *testing.T) {
+	for _, c := range []Curve{Linear, Reinhard, Hable} {
+		f := c.fn()
+		for _, x := range []float64{-1e-9, -0.5, -1, -2, -100} {
+			if y := gamma(f(x), 1/2.2, 0.01); y != 0 {
+				t.Errorf("%v: wrong value at %g: want 0, got %g", c, x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package hist
+
+import "math"
+
+// DensityFilter holds the parameters of adaptive density estimation. Each bin
+// is spread over a kernel whose radius shrinks as the bin's count grows, so
+// sparse regions of the histogram are smoothed heavily while dense regions
+// keep their detail. This is the density estimation filter of the classic
+// flame algorithm.
+type DensityFilter struct {
+	// MaxRadius is the kernel radius, in pixels, for bins which were hit once.
+	// If it is not positive, then density estimation is disabled.
+	MaxRadius float64
+	// MinRadius is the smallest kernel radius in pixels.
+	MinRadius float64
+	// Curve controls how quickly the kernel radius shrinks as bin counts
+	// increase. The radius for a bin hit n times is MaxRadius / n^Curve.
+	Curve float64
+}
+
+// Enabled returns whether the filter does anything.
+func (f DensityFilter) Enabled() bool {
+	return f.MaxRadius > 0
+}
+
+// fbin is a histogram bin after filtering.
+type fbin struct {
+	r, g, b, n float64
+}
+
+// kernel is a density estimation kernel, a list of offsets and weights.
+type kernel []struct {
+	dx, dy int
+	w      float64
+}
+
+// newKernel creates a normalized Epanechnikov kernel of the given radius in
+// bins.
+func newKernel(rad float64) kernel {
+	var k kernel
+	var sum float64
+	m := int(rad)
+	for dy := -m; dy <= m; dy++ {
+		for dx := -m; dx <= m; dx++ {
+			d := float64(dx*dx+dy*dy) / (rad * rad)
+			if d >= 1 {
+				continue
+			}
+			w := 1 - d
+			k = append(k, struct {
+				dx, dy int
+				w      float64
+			}{dx, dy, w})
+			sum += w
+		}
+	}
+	for i := range k {
+		k[i].w /= sum
+	}
+	return k
+}
+
+// kernelRes is the number of kernel radii per bin that density estimation
+// distinguishes.
+const kernelRes = 4
+
+// density applies a density estimation filter to the histogram, producing
+// filtered bins in row-major order. Bin counts are measured in
+// hits, i.e. units of full opacity.
+func (h *Hist) density(f DensityFilter) []fbin {
+	out := make([]fbin, h.bins())
+	maxr := f.MaxRadius * float64(h.osa)
+	minr := f.MinRadius * float64(h.osa)
+	// Kernels are cached by radius, quantized to kernelRes steps per bin.
+	kernels := make(map[int]kernel)
+	for y := 0; y < h.rows; y++ {
+		for x := 0; x < h.cols; x++ {
+			r, g, b, n := h.get(h.index(x, y))
+			if n == 0 {
+				continue
+			}
+			v := fbin{
+				r: float64(r),
+				g: float64(g),
+				b: float64(b),
+				n: float64(n),
+			}
+			rad := maxr
+			if c := v.n / 0xffff; c > 1 {
+				rad = maxr / math.Pow(c, f.Curve)
+			}
+			rad = math.Max(rad, minr)
+			q := int(rad*kernelRes + 0.5)
+			if q <= kernelRes/2 {
+				// The kernel is smaller than a bin.
+				o := &out[y*h.cols+x]
+				o.r += v.r
+				o.g += v.g
+				o.b += v.b
+				o.n += v.n
+				continue
+			}
+			k := kernels[q]
+			if k == nil {
+				k = newKernel(float64(q) / kernelRes)
+				kernels[q] = k
+			}
+			for _, e := range k {
+				u, w := x+e.dx, y+e.dy
+				if u < 0 || u >= h.cols || w < 0 || w >= h.rows {
+					continue
+				}
+				o := &out[w*h.cols+u]
+				o.r += v.r * e.w
+				o.g += v.g * e.w
+				o.b += v.b * e.w
+				o.n += v.n * e.w
+			}
+		}
+	}
+	return out
+}
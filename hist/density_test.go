@@ -0,0 +1,76 @@
+package hist
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestDensity(t *testing.T) {
+	white := color.RGBA64{R: 0xffff, G: 0xffff, B: 0xffff, A: 0xffff}
+	f := DensityFilter{MaxRadius: 3, MinRadius: 0, Curve: 0.5}
+	h := New(Size{W: 15, H: 15, OSA: 1})
+	// A sparse bin in one corner and a dense one in the other.
+	h.Add(3, 3, white)
+	for i := 0; i < 10000; i++ {
+		h.Add(11, 11, white)
+	}
+	de := h.density(f)
+	var sparse, dense, spread int
+	for y := 0; y < 15; y++ {
+		for x := 0; x < 15; x++ {
+			v := de[y*15+x]
+			if v.n == 0 {
+				continue
+			}
+			if v.r != v.n || v.g != v.n || v.b != v.n {
+				t.Errorf("color changed at %d,%d: %+v", x, y, v)
+			}
+			if x < 8 {
+				sparse++
+			} else {
+				dense++
+			}
+			if x < 8 && (x != 3 || y != 3) {
+				spread++
+			}
+		}
+	}
+	if spread == 0 {
+		t.Error("sparse bin was not spread")
+	}
+	if dense != 1 {
+		t.Errorf("dense bin spread over %d bins", dense)
+	}
+	var sum float64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			sum += de[y*15+x].n
+		}
+	}
+	if math.Abs(sum-0xffff) > 1e-6 {
+		t.Errorf("sparse bin mass changed: want %d, got %g", 0xffff, sum)
+	}
+	if n := de[11*15+11].n; n != 10000*0xffff {
+		t.Errorf("dense bin mass changed: want %d, got %g", 10000*0xffff, n)
+	}
+}
+
+func TestDensityDisabled(t *testing.T) {
+	h := New(Size{W: 3, H: 3, OSA: 1})
+	h.Add(1, 1, color.RGBA64{R: 0xffff, A: 0xffff})
+	tm := ToneMap{Brightness: 1, Contrast: 1, Gamma: 1}
+	a := h.Image(tm, 1, 1)
+	tm.Density = DensityFilter{MaxRadius: 0, MinRadius: 1, Curve: 1}
+	b := h.Image(tm, 1, 1)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 3; x++ {
+			if p, q := a.At(x, y), b.At(x, y); p != q {
+				t.Errorf("disabled filter changed %d,%d: %v to %v", x, y, p, q)
+			}
+		}
+	}
+	if b.(*histImage).de != nil {
+		t.Error("disabled filter allocated")
+	}
+}
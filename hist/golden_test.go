@@ -0,0 +1,137 @@
+package hist_test
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zephyrtronium/xirho/hist"
+)
+
+// goldenHist creates a histogram with deterministic contents spanning a wide
+// range of counts and colors.
+func goldenHist() *hist.Hist {
+	h := hist.New(hist.Size{W: 32, H: 32, OSA: 1})
+	var s uint32 = 1
+	next := func() uint32 {
+		s = s*1664525 + 1013904223
+		return s >> 8
+	}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			// Bins further right and down are hit exponentially more often.
+			n := 1 << ((x + y) / 4)
+			if next()%5 == 0 {
+				continue
+			}
+			for i := 0; i < n; i++ {
+				c := color.RGBA64{
+					R: uint16(next()),
+					G: uint16(next()),
+					B: uint16(next()),
+					A: 0xffff,
+				}
+				c.R, c.G, c.B = c.R>>(x%3), c.G>>(y%3), c.B>>((x+y)%3)
+				h.Add(x, y, c)
+			}
+		}
+	}
+	return h
+}
+
+// goldenToneMaps is the tone maps rendered for golden tests. These use only
+// the parameters xirho has always had.
+var goldenToneMaps = []hist.ToneMap{
+	{Brightness: 1, Contrast: 1, Gamma: 1},
+	{Brightness: 4, Contrast: 1, Gamma: 2.2, GammaMin: 0.01},
+	{Brightness: 30, Contrast: 1.5, Gamma: 4, GammaMin: 0.1},
+}
+
+// allToneMaps is goldenToneMaps along with tone maps using newer parameters.
+var allToneMaps = append(goldenToneMaps[:len(goldenToneMaps):len(goldenToneMaps)], []hist.ToneMap{
+	{Brightness: 4, Contrast: 1, Gamma: 2.2, Density: hist.DensityFilter{MaxRadius: 3, Curve: 0.4}},
+	{Brightness: 4, Contrast: 1, Gamma: 2.2, Vibrancy: 1, HighlightPower: -1},
+	{Brightness: 30, Contrast: 1.5, Gamma: 4, GammaMin: 0.1, Vibrancy: 0.5, HighlightPower: 1},
+	{Brightness: 4, Contrast: 1, Gamma: 2.2, Curve: hist.Linear},
+	{Brightness: 4, Contrast: 1, Gamma: 2.2, Curve: hist.Reinhard},
+	{Brightness: 4, Contrast: 1, Gamma: 2.2, Curve: hist.Hable},
+	{Brightness: 4, Contrast: 1, Gamma: 2.2, Vibrancy: 1, HighlightPower: 0, Curve: hist.Log},
+}...)
+
+// golden compares img against the named golden file. Golden files record the
+// output of earlier versions of xirho, so there is intentionally no way to
+// regenerate them from the current code.
+func golden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !want.Bounds().Eq(img.Bounds()) {
+		t.Fatalf("wrong bounds: want %v, got %v", want.Bounds(), img.Bounds())
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p := color.RGBA64Model.Convert(want.At(x, y))
+			q := color.RGBA64Model.Convert(img.At(x, y))
+			if p != q {
+				t.Errorf("wrong color at %d,%d: want %v, got %v", x, y, p, q)
+			}
+		}
+	}
+}
+
+// TestGoldenBlack tests that tone mapping onto an opaque black background
+// produces the same results as it always has. testdata/black.png was rendered
+// before tone mapped images were premultiplied.
+func TestGoldenBlack(t *testing.T) {
+	h := goldenHist()
+	dst := image.NewRGBA64(image.Rect(0, 0, 32, 32*len(goldenToneMaps)))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	for i, tm := range goldenToneMaps {
+		r := image.Rect(0, 32*i, 32, 32*(i+1))
+		draw.Draw(dst, r, h.Image(tm, 1, 1000), image.Point{}, draw.Over)
+	}
+	golden(t, "black.png", dst)
+}
+
+// TestPremultiplied tests that tone mapped images are validly premultiplied
+// so that they composite correctly onto any background.
+func TestPremultiplied(t *testing.T) {
+	h := goldenHist()
+	for i, tm := range allToneMaps {
+		img := h.Image(tm, 1, 1000)
+		// Compositing onto transparent and then onto black must be the same
+		// as compositing directly onto black.
+		clear := image.NewRGBA64(img.Bounds())
+		draw.Draw(clear, clear.Bounds(), img, image.Point{}, draw.Over)
+		black := image.NewRGBA64(img.Bounds())
+		draw.Draw(black, black.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		direct := image.NewRGBA64(img.Bounds())
+		draw.Draw(direct, direct.Bounds(), black, image.Point{}, draw.Src)
+		draw.Draw(direct, direct.Bounds(), img, image.Point{}, draw.Over)
+		draw.Draw(black, black.Bounds(), clear, image.Point{}, draw.Over)
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := img.At(x, y).(color.RGBA64)
+				if c.R > c.A || c.G > c.A || c.B > c.A {
+					t.Errorf("tone map %d: invalid premultiplied color at %d,%d: %v", i, x, y, c)
+				}
+				if p, q := direct.RGBA64At(x, y), black.RGBA64At(x, y); p != q {
+					t.Errorf("tone map %d: transparent then black differs at %d,%d: want %v, got %v", i, x, y, p, q)
+				}
+			}
+		}
+	}
+}
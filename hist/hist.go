@@ -3,6 +3,7 @@ package hist
 
 import (
 	"encoding/binary"
+	"fmt"
 	"image/color"
 	"io"
 	"math/bits"
@@ -19,9 +20,16 @@ type Hist struct {
 	rows, cols int
 	// osa is the oversampling factor.
 	osa int
-	// counts is the slice backed by arr. It is kept as a separate field for
-	// the convenience of less performance-sensitive algorithms.
+	// storage is the bin layout.
+	storage Storage
+	// counts is the slice backed by arr when the layout is Counts64. It is
+	// kept as a separate field for the convenience of less
+	// performance-sensitive algorithms.
 	counts []bin
+	// compact is the slice backed by arr for the other layouts.
+	compact []bin32
+	// carry holds the overflowed parts of bins in the Counts32 layout.
+	carry carries
 }
 
 type bin struct {
@@ -38,6 +46,9 @@ type Size struct {
 	// OSA is the oversampling factor, the number of bins per axis to be
 	// resampled into each pixel. A value of 0 results in an empty histogram.
 	OSA int
+	// Storage is the layout of histogram bins. The zero value selects
+	// Counts64.
+	Storage Storage
 }
 
 // Bins computes the number of bins in a histogram of this size. If Overflows
@@ -50,33 +61,36 @@ func (sz Size) Bins() int {
 }
 
 // Mem estimates the memory usage in bytes of a histogram (and not accumulator)
-// of this size. If Overflows returns true, then the result is zero.
+// of this size and storage layout. If Overflows returns true, then the result
+// is zero.
 func (sz Size) Mem() uintptr {
 	if sz.Overflows() {
 		return 0
 	}
-	return unsafe.Sizeof(Hist{}) + uintptr(sz.W*sz.H*sz.OSA*sz.OSA)*unsafe.Sizeof(bin{})
+	return unsafe.Sizeof(Hist{}) + uintptr(sz.W*sz.H*sz.OSA*sz.OSA)*sz.Storage.binSize()
 }
 
 // Overflows returns true when the memory required by a histogram of this
-// size would overflow the size of an integer. This is always true if either
-// dimension or the oversampling factor is negative.
+// size and storage layout would overflow the size of an integer. This is
+// always true if either dimension or the oversampling factor is negative.
 func (sz Size) Overflows() bool {
 	nw := bits.Len64(uint64(sz.W))
 	nh := bits.Len64(uint64(sz.H))
 	no := bits.Len64(uint64(sz.OSA))
-	return nw+nh+2*no >= bits.UintSize
+	nb := bits.Len64(uint64(sz.Storage.binSize() - 1))
+	return nw+nh+2*no+nb >= bits.UintSize
 }
 
 // MemFor estimates the memory usage in bytes of a histogram (and not
-// accumulator) of a given size. It assumes Overflows returns false for
-// the given width and height.
+// accumulator) of a given size with the default storage layout. It assumes
+// Overflows returns false for the given width and height.
 func MemFor(width, height int) int {
 	return int(unsafe.Sizeof(Hist{})) + width*height*int(unsafe.Sizeof(bin{}))
 }
 
 // Overflows returns true when the memory required by a histogram of the
-// given size would overflow the size of an integer.
+// given size with the default storage layout would overflow the size of an
+// integer.
 func Overflows(width, height int) bool {
 	// Lazy approach: do the multiplication.
 	// mask is the bits that are allowed to be set in the low word of the
@@ -93,6 +107,9 @@ func (sz Size) check() {
 	if sz.W < 0 || sz.H < 0 || sz.OSA < 0 {
 		panic("xirho: cannot make negative size histogram")
 	}
+	if int(sz.Storage) >= len(storageNames) {
+		panic("xirho: unknown histogram storage")
+	}
 	if sz.Overflows() {
 		panic("xirho: histogram size overflows")
 	}
@@ -101,70 +118,196 @@ func (sz Size) check() {
 // New allocates a new histogram.
 func New(sz Size) *Hist {
 	sz.check()
-	h := Hist{
-		cols:   sz.W * sz.OSA,
-		rows:   sz.H * sz.OSA,
-		osa:    sz.OSA,
-		counts: make([]bin, sz.Bins()),
+	h := &Hist{
+		cols:    sz.W * sz.OSA,
+		rows:    sz.H * sz.OSA,
+		osa:     sz.OSA,
+		storage: sz.Storage,
 	}
-	if len(h.counts) > 0 {
-		h.arr = unsafe.Pointer(&h.counts[0])
+	h.alloc(sz.Bins())
+	return h
+}
+
+// alloc allocates n bins in the histogram's storage layout.
+func (h *Hist) alloc(n int) {
+	switch h.storage {
+	case Counts64:
+		h.counts = make([]bin, n)
+		if n > 0 {
+			h.arr = unsafe.Pointer(&h.counts[0])
+		}
+	default:
+		h.compact = make([]bin32, n)
+		if n > 0 {
+			h.arr = unsafe.Pointer(&h.compact[0])
+		}
 	}
-	return &h
 }
 
 // Reset reinitializes the histogram counts. If the given size requires a
-// different number of bins from the current one, then the entire histogram is
-// reallocated.
+// different number of bins or a different storage layout from the current
+// one, then the entire histogram is reallocated.
 func (h *Hist) Reset(sz Size) {
 	sz.check()
+	h.carry.reset()
+	n := len(h.counts) + len(h.compact)
 	// Might be different sizes with the same number of bins.
 	h.cols, h.rows, h.osa = sz.W*sz.OSA, sz.H*sz.OSA, sz.OSA
-	if sz.Bins() != len(h.counts) {
+	if sz.Bins() != n || sz.Storage != h.storage {
 		// Histograms can be very large, so we want to ensure the current
 		// counts are collected before we attempt to allocate new ones.
 		h.arr = nil
 		h.counts = nil
+		h.compact = nil
 		runtime.GC()
-		h.counts = make([]bin, sz.Bins())
-		if len(h.counts) > 0 {
-			h.arr = unsafe.Pointer(&h.counts[0])
-		}
+		h.storage = sz.Storage
+		h.alloc(sz.Bins())
 		return
 	}
 	for i := range h.counts {
 		h.counts[i] = bin{}
 	}
+	for i := range h.compact {
+		h.compact[i] = bin32{}
+	}
 }
 
 // IsEmpty returns true if the histogram has zero bins.
 func (h *Hist) IsEmpty() bool {
-	return len(h.counts) == 0
+	return h.bins() == 0
+}
+
+// bins returns the number of bins in the histogram.
+func (h *Hist) bins() int {
+	return h.rows * h.cols
 }
 
-// checkBounds controls whether at checks histogram bounds. Only disable this
-// if everything that calls at or Add is thoroughly tested!
+// checkBounds controls whether index checks histogram bounds. Only disable
+// this if everything that calls index or Add is thoroughly tested!
 const checkBounds = false
 
-// at gets the bin at a given x and y. May panics if either dimension is
-// out of bounds.
-func (h *Hist) at(x, y int) *bin {
+// index gets the index of the bin at a given x and y. The result may be out
+// of bounds if either dimension is.
+func (h *Hist) index(x, y int) int {
 	if checkBounds {
 		if x < 0 || y < 0 || x >= h.cols || y >= h.rows {
 			panic("xirho: histogram position out of bounds")
 		}
 	}
-	return (*bin)(unsafe.Add(h.arr, uintptr(y*h.cols+x)*unsafe.Sizeof(bin{})))
+	return y*h.cols + x
 }
 
 // Add increments a histogram bucket by a color. It is safe for multiple
 // goroutines to call this concurrently.
 func (h *Hist) Add(x, y int, c color.RGBA64) {
-	bin := h.at(x, y)
-	bin.r.Add(uint64(c.R))
-	bin.g.Add(uint64(c.G))
-	bin.b.Add(uint64(c.B))
-	bin.n.Add(uint64(c.A))
+	i := h.index(x, y)
+	switch h.storage {
+	case Counts64:
+		bin := (*bin)(unsafe.Add(h.arr, uintptr(i)*unsafe.Sizeof(bin{})))
+		bin.r.Add(uint64(c.R))
+		bin.g.Add(uint64(c.G))
+		bin.b.Add(uint64(c.B))
+		bin.n.Add(uint64(c.A))
+	case Counts32:
+		bin := (*bin32)(unsafe.Add(h.arr, uintptr(i)*unsafe.Sizeof(bin32{})))
+		if v := bin.r.Add(uint32(c.R)); v < uint32(c.R) {
+			h.carry.add(i, 0, 1)
+		}
+		if v := bin.g.Add(uint32(c.G)); v < uint32(c.G) {
+			h.carry.add(i, 1, 1)
+		}
+		if v := bin.b.Add(uint32(c.B)); v < uint32(c.B) {
+			h.carry.add(i, 2, 1)
+		}
+		if v := bin.n.Add(uint32(c.A)); v < uint32(c.A) {
+			h.carry.add(i, 3, 1)
+		}
+	case Float32:
+		bin := (*bin32)(unsafe.Add(h.arr, uintptr(i)*unsafe.Sizeof(bin32{})))
+		addf(&bin.r, float32(c.R))
+		addf(&bin.g, float32(c.G))
+		addf(&bin.b, float32(c.B))
+		addf(&bin.n, float32(c.A))
+	}
+}
+
+// AddCounts adds channel sums to a histogram bucket, as if by several calls to
+// Add whose colors sum to the given values. It is safe for multiple goroutines
+// to call this concurrently.
+func (h *Hist) AddCounts(x, y int, r, g, b, n uint64) {
+	i := h.index(x, y)
+	h.put(i, 0, r)
+	h.put(i, 1, g)
+	h.put(i, 2, b)
+	h.put(i, 3, n)
+}
+
+// get loads the channels of the bin at index i.
+func (h *Hist) get(i int) (r, g, b, n uint64) {
+	switch h.storage {
+	case Counts64:
+		bin := &h.counts[i]
+		return bin.r.Load(), bin.g.Load(), bin.b.Load(), bin.n.Load()
+	case Counts32:
+		bin := &h.compact[i]
+		hi := h.carry.get(i)
+		return uint64(bin.r.Load()) + hi[0], uint64(bin.g.Load()) + hi[1], uint64(bin.b.Load()) + hi[2], uint64(bin.n.Load()) + hi[3]
+	default:
+		bin := &h.compact[i]
+		return loadf(&bin.r), loadf(&bin.g), loadf(&bin.b), loadf(&bin.n)
+	}
+}
+
+// channel loads only channel ch of the bin at index i, where channels 0
+// through 3 are red, green, blue, and count. carry holds the overflowed parts
+// of Counts32 bins as returned by h.carry.snapshot.
+func (h *Hist) channel(i, ch int, carry map[int][4]uint64) uint64 {
+	switch h.storage {
+	case Counts64:
+		bin := &h.counts[i]
+		return [...]*atomic.Uint64{&bin.r, &bin.g, &bin.b, &bin.n}[ch].Load()
+	case Counts32:
+		bin := &h.compact[i]
+		return uint64([...]*atomic.Uint32{&bin.r, &bin.g, &bin.b, &bin.n}[ch].Load()) + carry[i][ch]
+	default:
+		bin := &h.compact[i]
+		return loadf([...]*atomic.Uint32{&bin.r, &bin.g, &bin.b, &bin.n}[ch])
+	}
+}
+
+// put adds x to channel ch of the bin at index i, where channels 0 through 3
+// are red, green, blue, and count.
+func (h *Hist) put(i, ch int, x uint64) {
+	switch h.storage {
+	case Counts64:
+		bin := &h.counts[i]
+		[...]*atomic.Uint64{&bin.r, &bin.g, &bin.b, &bin.n}[ch].Add(x)
+	case Counts32:
+		bin := &h.compact[i]
+		if hi := add32([...]*atomic.Uint32{&bin.r, &bin.g, &bin.b, &bin.n}[ch], x); hi != 0 {
+			h.carry.add(i, ch, hi)
+		}
+	default:
+		bin := &h.compact[i]
+		addf([...]*atomic.Uint32{&bin.r, &bin.g, &bin.b, &bin.n}[ch], float32(x))
+	}
+}
+
+// Merge adds the contents of another histogram of the same size into h. The
+// histograms may have different storage layouts. It is safe to call Merge
+// while h may be plotted onto, but not while o may be.
+func (h *Hist) Merge(o *Hist) error {
+	if h.cols != o.cols || h.rows != o.rows || h.osa != o.osa {
+		return fmt.Errorf("xirho: cannot merge %dx%d:%d histogram into %dx%d:%d histogram", o.Width(), o.Height(), o.OSA(), h.Width(), h.Height(), h.OSA())
+	}
+	for i := 0; i < o.bins(); i++ {
+		r, g, b, n := o.get(i)
+		h.put(i, 0, r)
+		h.put(i, 1, g)
+		h.put(i, 2, b)
+		h.put(i, 3, n)
+	}
+	return nil
 }
 
 // Width returns the horizontal size of the histogram in pixels.
@@ -191,9 +334,10 @@ func (h *Hist) OSA() int {
 // Size returns the histogram's size.
 func (h *Hist) Size() Size {
 	return Size{
-		W:   h.Width(),
-		H:   h.Height(),
-		OSA: h.OSA(),
+		W:       h.Width(),
+		H:       h.Height(),
+		OSA:     h.OSA(),
+		Storage: h.storage,
 	}
 }
 
@@ -220,7 +364,7 @@ func (h *Hist) Aspect() float64 {
 
 // WriteTo dumps the histogram contents. The first two 8-byte words are the
 // size in columns and rows, respectively. Then, each bin's red count is
-// written in row-major order, then each blue, green, and alpha count. Each
+// written in row-major order, then each green, blue, and alpha count. Each
 // value written is an 8-byte little-endian integer.
 //
 // It is not safe to call WriteTo while the histogram may be plotted onto.
@@ -233,41 +377,94 @@ func (h *Hist) WriteTo(w io.Writer) (n int64, err error) {
 	if err != nil {
 		return n, err
 	}
-	b = b[:8]
-	for i := range h.counts {
-		bin := &h.counts[i]
-		binary.LittleEndian.PutUint64(b, bin.r.Load())
-		k, err = w.Write(b)
-		n += int64(k)
-		if err != nil {
-			return n, err
+	m, err := h.writeBins(w)
+	return n + m, err
+}
+
+// ReadFrom loads histogram contents in the format written by WriteTo,
+// resizing the histogram as needed. Since the format does not record the
+// oversampling factor, the current one is kept if it evenly divides the new
+// size; otherwise, the oversampling factor becomes 1. If an error occurs, the
+// histogram contents are unspecified.
+//
+// It is not safe to call ReadFrom while the histogram may be plotted onto.
+func (h *Hist) ReadFrom(r io.Reader) (n int64, err error) {
+	b := make([]byte, 16)
+	k, err := io.ReadFull(r, b)
+	n += int64(k)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
+		return n, err
 	}
-	for i := range h.counts {
-		bin := &h.counts[i]
-		binary.LittleEndian.PutUint64(b, bin.g.Load())
-		k, err = w.Write(b)
-		n += int64(k)
-		if err != nil {
-			return n, err
+	cols := binary.LittleEndian.Uint64(b[0:8])
+	rows := binary.LittleEndian.Uint64(b[8:16])
+	sz := Size{W: int(cols), H: int(rows), OSA: 1, Storage: h.storage}
+	if sz.W < 0 || sz.H < 0 || sz.Overflows() {
+		return n, fmt.Errorf("xirho: histogram size %dx%d is invalid", cols, rows)
+	}
+	if rem, ok := remaining(r); ok {
+		// Check the dump is complete before allocating for a hostile size.
+		if need := uint64(sz.Bins()) * 32; uint64(rem) < need {
+			return n, fmt.Errorf("xirho: histogram needs %d bytes but only %d remain: %w", need, rem, io.ErrUnexpectedEOF)
 		}
 	}
-	for i := range h.counts {
-		bin := &h.counts[i]
-		binary.LittleEndian.PutUint64(b, bin.b.Load())
-		k, err = w.Write(b)
-		n += int64(k)
-		if err != nil {
-			return n, err
+	if osa := h.osa; osa > 0 && sz.W%osa == 0 && sz.H%osa == 0 {
+		sz = Size{W: sz.W / osa, H: sz.H / osa, OSA: osa, Storage: h.storage}
+	}
+	h.Reset(sz)
+	m, err := h.readBins(r)
+	return n + m, err
+}
+
+// writeBins writes each channel of every bin in row-major order as 8-byte
+// little-endian integers, first red, then green, blue, and alpha.
+func (h *Hist) writeBins(w io.Writer) (n int64, err error) {
+	// Copy the overflowed parts of Counts32 bins once so that loading each
+	// channel doesn't need to lock.
+	carry := h.carry.snapshot()
+	b := make([]byte, 0, 8*1024)
+	for c := 0; c < 4; c++ {
+		for i := 0; i < h.bins(); i++ {
+			b = binary.LittleEndian.AppendUint64(b, h.channel(i, c, carry))
+			if len(b) < cap(b) {
+				continue
+			}
+			k, err := w.Write(b)
+			n += int64(k)
+			if err != nil {
+				return n, err
+			}
+			b = b[:0]
 		}
 	}
-	for i := range h.counts {
-		bin := &h.counts[i]
-		binary.LittleEndian.PutUint64(b, bin.n.Load())
-		k, err = w.Write(b)
-		n += int64(k)
-		if err != nil {
-			return n, err
+	k, err := w.Write(b)
+	return n + int64(k), err
+}
+
+// readBins reads bins in the format written by writeBins into the histogram
+// at its current size, adding the values read to the existing bins.
+func (h *Hist) readBins(r io.Reader) (n int64, err error) {
+	b := make([]byte, 8*1024)
+	for c := 0; c < 4; c++ {
+		for i := 0; i < h.bins(); {
+			b := b
+			if rem := h.bins() - i; rem < len(b)/8 {
+				b = b[:8*rem]
+			}
+			k, err := io.ReadFull(r, b)
+			n += int64(k)
+			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return n, err
+			}
+			for ; len(b) > 0; b = b[8:] {
+				h.put(i, c, binary.LittleEndian.Uint64(b))
+				i++
+			}
 		}
 	}
 	return n, nil
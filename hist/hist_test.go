@@ -160,3 +160,24 @@ func TestHistAdd(t *testing.T) {
 		}
 	})
 }
+
+func TestHistMerge(t *testing.T) {
+	sz := Size{W: 5, H: 3, OSA: 2}
+	a, b := New(sz), New(sz)
+	fill(a)
+	fill(b)
+	fill(b)
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	want := New(sz)
+	for i := 0; i < 3; i++ {
+		fill(want)
+	}
+	if !samebins(want, a) {
+		t.Error("wrong bins after merge")
+	}
+	if err := a.Merge(New(Size{W: 3, H: 5, OSA: 2})); err == nil {
+		t.Error("no error merging histograms of different sizes")
+	}
+}
@@ -19,13 +19,29 @@ type ToneMap struct {
 	Gamma float64
 	// GammaMin is the minimum log-alpha value to which to apply gamma scaling.
 	GammaMin float64
+	// Density is the density estimation filter to apply before tone mapping.
+	Density DensityFilter
+	// Curve is the tone curve applied to log-alpha before gamma scaling.
+	Curve Curve
+	// Vibrancy is the proportion of gamma scaling applied to colors through
+	// alpha, in [0, 1]. At 1, each color is scaled by its bin's gamma-scaled
+	// alpha, preserving saturation. At 0, gamma is applied to each color
+	// channel separately, which desaturates dim bins. Intermediate values
+	// blend the two.
+	Vibrancy float64
+	// HighlightPower controls how bins too bright to display are converted
+	// under vibrancy. If it is negative, each color channel is clipped
+	// separately, shifting hues of bright bins. Otherwise, bright bins are
+	// scaled to preserve hue, then desaturated toward white by an amount
+	// which increases with HighlightPower and with the excess brightness.
+	//
+	// HighlightPower has no effect when Vibrancy is zero, so the zero values
+	// of both instead select xirho's original tone mapping: each color is
+	// scaled by its bin's log-alpha without the tone curve or gamma, and
+	// channels are clipped separately.
+	HighlightPower float64
 }
 
-// TODO: saturation
-
-// Note that this implementation is incorrect except on black backgrounds. It
-// is legacy code. The API will change eventually.
-
 // clscale is log10(0xffff). Histogram counts are in [0, 0xffff], but the flame
 // algorithm is based on colors in [0, 1]. Subtracting this from log counts
 // performs the conversion.
@@ -43,19 +59,34 @@ const lwp = 2.301029995663981195213738
 // plane in spatial units. iters is the total number of iterations run for the
 // render.
 //
+// If the tone map's density filter is enabled, then Image filters the entire
+// histogram before returning, allocating a buffer the size of the histogram.
+// Otherwise, bins are converted as they are accessed.
+//
+// The image's colors are premultiplied by alpha, so it can be composited onto
+// any background, including a transparent one, using the Over operator.
+//
 // The histogram should not be modified while the wrapper is in use.
 // Note that the wrapper holds a reference to the histogram's bins, so it
 // should not be stored in any long-lived locations.
 func (h *Hist) Image(tm ToneMap, area float64, iters int64) image.Image {
 	// Convert to log early to avoid overflow and mitigate loss of precision.
-	q := math.Log10(float64(len(h.counts))) - math.Log10(float64(iters))
-	return &histImage{
+	q := math.Log10(float64(h.bins())) - math.Log10(float64(iters))
+	img := histImage{
 		Hist: h,
 		b:    tm.Contrast,
 		g:    1 / tm.Gamma,
 		t:    tm.GammaMin,
 		lqa:  lwp - clscale + math.Log10(tm.Brightness) - math.Log10(area) + q,
+		v:    tm.Vibrancy,
+		hp:   tm.HighlightPower,
+		flat: tm.Vibrancy == 0 && tm.HighlightPower == 0,
+		tc:   tm.Curve.fn(),
+	}
+	if tm.Density.Enabled() {
+		img.de = h.density(tm.Density)
 	}
+	return &img
 }
 
 // histImage wraps a histogram with brightness parameters for rendering.
@@ -63,6 +94,24 @@ type histImage struct {
 	*Hist
 	b, g, t float64
 	lqa     float64
+	v, hp   float64
+	// flat indicates that colors are scaled by log-alpha alone, without
+	// gamma, vibrancy blending, or highlight handling.
+	flat bool
+	// tc is the tone curve.
+	tc func(float64) float64
+	// de is the density estimated bins, if any.
+	de []fbin
+}
+
+// load gets the channels of a bin, after density estimation if it is enabled.
+func (h *histImage) load(x, y int) (r, g, b, n float64) {
+	if h.de != nil {
+		v := h.de[y*h.cols+x]
+		return v.r, v.g, v.b, v.n
+	}
+	r0, g0, b0, n0 := h.get(h.index(x, y))
+	return float64(r0), float64(g0), float64(b0), float64(n0)
 }
 
 func (h *histImage) ColorModel() color.Model {
@@ -83,43 +132,88 @@ func (h *histImage) RGBA64At(x, y int) color.RGBA64 {
 	if x < 0 || x >= h.cols || y < 0 || y >= h.rows {
 		return color.RGBA64{}
 	}
-	bin := h.at(x, y)
-	r := bin.r.Load()
-	g := bin.g.Load()
-	b := bin.b.Load()
-	n := bin.n.Load()
+	r, g, b, n := h.load(x, y)
 	if n == 0 {
 		return color.RGBA64{}
 	}
 	a := ascale(n, h.b, h.lqa)
-	ag := gamma(aces(a), h.g, h.t)
+	if a <= 0 {
+		// Bins dimmer than the black point are fully transparent.
+		return color.RGBA64{}
+	}
+	ag := gamma(h.tc(a), h.g, h.t)
 	as := cscale(ag)
 	if itdoesntworkatall {
-		fmt.Printf("  at(%d,%d) h.b=%f h.g=%f h.t=%f h.lqa=%f rgbn=%d/%d/%d/%d a=%f ag=%f as=%d\n", x, y, h.b, h.g, h.t, h.lqa, r, g, b, n, a, ag, as)
+		fmt.Printf("  at(%d,%d) h.b=%f h.g=%f h.t=%f h.lqa=%f rgbn=%g/%g/%g/%g a=%f ag=%f as=%d\n", x, y, h.b, h.g, h.t, h.lqa, r, g, b, n, a, ag, as)
 	}
 	if as <= 0 {
 		return color.RGBA64{}
 	}
-	s := a / float64(n)
-	rs := s * float64(r)
-	gs := s * float64(g)
-	bs := s * float64(b)
+	var rs, gs, bs float64
+	if h.flat {
+		s := a / n
+		rs, gs, bs = s*r, s*g, s*b
+	} else {
+		rs, gs, bs = h.color(r/n, g/n, b/n, a, ag)
+	}
 	p := color.RGBA64{
 		R: cscale(rs),
 		G: cscale(gs),
 		B: cscale(bs),
 		A: as,
 	}
+	// Bright bins can have color channels greater than alpha, which is not a
+	// valid premultiplied color. Raising alpha to the brightest channel makes
+	// it valid without changing the result over black.
+	p.A = max(p.A, p.R, p.G, p.B)
 	if itdoesntworkatall {
-		fmt.Printf("at(%d,%d) p=%v s=%g rgb=%g/%g/%g\n", x, y, p, s, rs, gs, bs)
+		fmt.Printf("at(%d,%d) p=%v rgb=%g/%g/%g\n", x, y, p, rs, gs, bs)
 	}
 	return p
 }
 
 const itdoesntworkatall = false
 
-func ascale(n uint64, br, lb float64) float64 {
-	a := br * (math.Log10(float64(n)) + lb)
+// color computes the premultiplied color of a bin with mean color (r, g, b),
+// alpha a before gamma scaling, and alpha ag after, applying vibrancy and
+// highlight power.
+func (h *histImage) color(r, g, b, a, ag float64) (float64, float64, float64) {
+	c := [3]float64{r, g, b}
+	var v [3]float64
+	m := max(r, g, b)
+	switch {
+	case m <= 0:
+		// Black stays black.
+	case ag*m <= 1:
+		for i, x := range c {
+			v[i] = ag * x
+		}
+	case h.hp >= 0:
+		// Scale to the brightest displayable color with the same hue, then
+		// reduce saturation in proportion to the excess brightness. With the
+		// value fixed at 1, scaling saturation by k moves each channel
+		// toward 1 by 1-k.
+		ls := 1 / m
+		k := math.Pow(ls/ag, h.hp)
+		for i, x := range c {
+			v[i] = 1 - k*(1-ls*x)
+		}
+	default:
+		// Blend between clipping each channel and preserving hue.
+		ls := 1 / m
+		p := min(-h.hp, 1)
+		for i, x := range c {
+			v[i] = ((1-p)*ls + p*ag) * x
+		}
+	}
+	for i, x := range c {
+		v[i] = h.v*v[i] + (1-h.v)*gamma(h.tc(a*x), h.g, h.t)
+	}
+	return v[0], v[1], v[2]
+}
+
+func ascale(n, br, lb float64) float64 {
+	a := br * (math.Log10(n) + lb)
 	return a
 }
 
@@ -142,16 +236,3 @@ func gamma(a, exp, tr float64) float64 {
 	p := a / tr
 	return p*math.Pow(a, exp) + (1-p)*a*math.Pow(tr, exp-1)
 }
-
-func aces(x float64) float64 {
-	// Approximate ACES filmic tone mapping curve by Krzysztof Narkowicz.
-	// https://knarkowicz.wordpress.com/2016/01/06/aces-filmic-tone-mapping-curve/
-	const (
-		a = 2.51
-		b = 0.03
-		c = 2.43
-		d = 0.59
-		e = 0.14
-	)
-	return (x * (a*x + b)) / (x*(c*x+d) + e)
-}
@@ -76,3 +76,97 @@ func TestAt(t *testing.T) {
 		}
 	}
 }
+
+func TestVibrancy(t *testing.T) {
+	h := hist.New(hist.Size{W: 1, H: 1, OSA: 1})
+	h.Add(0, 0, color.RGBA64{R: 0xffff, G: 0x4000, A: 0xffff})
+	cases := []struct {
+		name string
+		v    float64
+		// lo and hi bound the ratio of green to red.
+		lo, hi float64
+	}{
+		{"vibrant", 1, 0.24, 0.26},
+		{"per-channel", 0, 0.3, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tm := hist.ToneMap{Brightness: 0.1, Contrast: 1, Gamma: 2.2, Vibrancy: c.v, HighlightPower: -1}
+			p := h.Image(tm, 1, 1).At(0, 0).(color.RGBA64)
+			if p.R == 0 || p.R == 0xffff {
+				t.Fatalf("red out of range: %v", p)
+			}
+			if r := float64(p.G) / float64(p.R); r < c.lo || r > c.hi {
+				t.Errorf("wrong green to red ratio: want in [%g, %g], got %g from %v", c.lo, c.hi, r, p)
+			}
+		})
+	}
+}
+
+func TestHighlightPower(t *testing.T) {
+	h := hist.New(hist.Size{W: 1, H: 1, OSA: 1})
+	for i := 0; i < 1000; i++ {
+		h.Add(0, 0, color.RGBA64{R: 0xffff, G: 0x8000, A: 0xffff})
+	}
+	tm := hist.ToneMap{Brightness: 1000, Contrast: 100, Gamma: 1, Vibrancy: 1}
+	at := func(hp float64) color.RGBA64 {
+		tm.HighlightPower = hp
+		return h.Image(tm, 1, 1).At(0, 0).(color.RGBA64)
+	}
+	clip := at(-1)
+	// Clipping red but not green shifts the hue toward green.
+	if clip.R != 0xffff || clip.G <= 0x8100 || clip.B != 0 {
+		t.Errorf("negative highlight power should clip channels: got %v", clip)
+	}
+	hue := at(0)
+	if hue.R != 0xffff || hue.G < 0x7f00 || hue.G > 0x8100 || hue.B != 0 {
+		t.Errorf("zero highlight power should preserve hue: got %v", hue)
+	}
+	white := at(1)
+	if white.R != 0xffff || white.G <= hue.G || white.B == 0 {
+		t.Errorf("positive highlight power should desaturate: got %v", white)
+	}
+}
+
+func TestFlat(t *testing.T) {
+	h := hist.New(hist.Size{W: 1, H: 1, OSA: 1})
+	h.Add(0, 0, color.RGBA64{R: 0xffff, A: 0xffff})
+	// A dim bin keeps its hue, with color not exceeding its partial alpha.
+	p := h.Image(hist.ToneMap{Brightness: 0.02, Contrast: 1, Gamma: 2.2}, 1, 1).At(0, 0).(color.RGBA64)
+	if p.A == 0 || p.A == 0xffff || p.R == 0 || p.R > p.A || p.G != 0 || p.B != 0 {
+		t.Errorf("wrong dim color: want red premultiplied by partial alpha, got %v", p)
+	}
+	// A bright bin raises alpha to its brightest channel.
+	p = h.Image(hist.ToneMap{Brightness: 340, Contrast: 1, Gamma: 0.25}, 1, 1).At(0, 0).(color.RGBA64)
+	if p.R != 0xffff || p.A != 0xffff {
+		t.Errorf("wrong bright color: want opaque red, got %v", p)
+	}
+	// A bin below the black point is transparent.
+	p = h.Image(hist.ToneMap{Brightness: 0.001, Contrast: 1, Gamma: 2.2}, 1, 1).At(0, 0).(color.RGBA64)
+	if p != (color.RGBA64{}) {
+		t.Errorf("wrong color below black point: want transparent, got %v", p)
+	}
+}
+
+func TestMonotonic(t *testing.T) {
+	for _, hp := range []float64{-1, 0, 1} {
+		// Sparse bins fall below the black point, where alpha is negative.
+		tm := hist.ToneMap{Brightness: 1, Contrast: 1, Gamma: 4, GammaMin: 0.01, Vibrancy: 1, HighlightPower: hp}
+		var last color.RGBA64
+		h := hist.New(hist.Size{W: 1, H: 1, OSA: 1})
+		for n := 1; n <= 1<<16; n++ {
+			h.Add(0, 0, color.RGBA64{R: 0xffff, G: 0x8000, A: 0xffff})
+			if n&(n-1) != 0 {
+				continue
+			}
+			p := h.Image(tm, 1, 1e6).At(0, 0).(color.RGBA64)
+			if p.A < last.A || p.R < last.R || p.G < last.G {
+				t.Errorf("highlight power %g: brightness decreased at %d hits: %v after %v", hp, n, p, last)
+			}
+			last = p
+		}
+		if last.A == 0 {
+			t.Errorf("highlight power %g: never visible", hp)
+		}
+	}
+}
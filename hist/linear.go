@@ -0,0 +1,52 @@
+package hist
+
+import (
+	"image"
+
+	"github.com/zephyrtronium/xirho/hdr"
+)
+
+// Linear converts the histogram to a high dynamic range image. Bins are
+// scaled by log density with the tone map's brightness, contrast, and density
+// filter, but the tone curve, gamma, vibrancy, and clamping are not applied,
+// so the result is linear and may contain colors brighter than 1. Alpha is
+// limited to [0, 1]. Oversampled bins are averaged into each pixel, so the
+// result has the histogram's width and height.
+//
+// The parameters are the same as for Image.
+func (h *Hist) Linear(tm ToneMap, area float64, iters int64) *hdr.Image {
+	src := h.Image(tm, area, iters).(*histImage)
+	osa := h.osa
+	m := hdr.NewImage(image.Rect(0, 0, h.Width(), h.Height()))
+	k := 1 / float64(osa*osa)
+	for y := 0; y < m.Rect.Dy(); y++ {
+		for x := 0; x < m.Rect.Dx(); x++ {
+			var pr, pg, pb, pa float64
+			for j := 0; j < osa; j++ {
+				for i := 0; i < osa; i++ {
+					r, g, b, a := src.linear(x*osa+i, y*osa+j)
+					pr += r
+					pg += g
+					pb += b
+					pa += a
+				}
+			}
+			m.SetRGBA(x, y, float32(pr*k), float32(pg*k), float32(pb*k), float32(pa*k))
+		}
+	}
+	return m
+}
+
+// linear computes the linear premultiplied color of a bin.
+func (h *histImage) linear(x, y int) (r, g, b, a float64) {
+	r, g, b, n := h.load(x, y)
+	if n == 0 {
+		return 0, 0, 0, 0
+	}
+	a = ascale(n, h.b, h.lqa)
+	if a <= 0 {
+		return 0, 0, 0, 0
+	}
+	s := a / n
+	return s * r, s * g, s * b, min(a, 1)
+}
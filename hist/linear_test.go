@@ -0,0 +1,53 @@
+package hist_test
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/zephyrtronium/xirho/hist"
+)
+
+func TestLinear(t *testing.T) {
+	h := goldenHist()
+	tm := hist.ToneMap{Brightness: 4, Contrast: 1, Gamma: 1, Curve: hist.Log}
+	img := h.Image(tm, 1, 1000)
+	lin := h.Linear(tm, 1, 1000)
+	if !lin.Bounds().Eq(img.Bounds()) {
+		t.Fatalf("wrong bounds: want %v, got %v", img.Bounds(), lin.Bounds())
+	}
+	bright := false
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			r, g, b, a := lin.RGBAAt(x, y)
+			if a < 0 || a > 1 {
+				t.Errorf("alpha out of range at %d,%d: %g", x, y, a)
+			}
+			if max(r, g, b) > 1 {
+				// The clamped image can't match, but it must saturate.
+				bright = true
+				continue
+			}
+			c := img.At(x, y).(color.RGBA64)
+			for i, v := range [...]float32{r, g, b} {
+				w := [...]uint16{c.R, c.G, c.B}[i]
+				if d := v*65536 - float32(w); d < -1 || d > 1 {
+					t.Errorf("channel %d at %d,%d: linear %g doesn't match clamped %d", i, x, y, v, w)
+				}
+			}
+		}
+	}
+	if !bright {
+		t.Error("no colors above 1")
+	}
+}
+
+func TestLinearOSA(t *testing.T) {
+	h := hist.New(hist.Size{W: 1, H: 1, OSA: 2})
+	h.Add(0, 0, color.RGBA64{R: 0xffff, A: 0xffff})
+	h.Add(1, 1, color.RGBA64{G: 0xffff, A: 0xffff})
+	lin := h.Linear(hist.ToneMap{Brightness: 1, Contrast: 1, Gamma: 1}, 1, 1)
+	r, g, b, a := lin.RGBAAt(0, 0)
+	if r <= 0 || r != g || b != 0 || a <= 0 {
+		t.Errorf("wrong averaged color: %g %g %g %g", r, g, b, a)
+	}
+}
@@ -0,0 +1,176 @@
+package hist
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Storage selects the layout of histogram bins. Compact layouts use less
+// memory per bin, allowing larger histograms and improving cache behavior
+// while plotting, at some cost in speed or precision.
+type Storage uint8
+
+const (
+	// Counts64 stores each channel as a 64-bit integer, 32 bytes per bin.
+	// It is the default layout.
+	Counts64 Storage = iota
+	// Counts32 stores each channel as a 32-bit integer, 16 bytes per bin.
+	// When a channel overflows, the excess is promoted to a side table of
+	// 64-bit counts, so results are exact, but bins which receive more than
+	// about 65000 fully opaque hits plot more slowly.
+	Counts32
+	// Float32 stores each channel as a single-precision float, 16 bytes per
+	// bin. Counts are exact up to 256 fully opaque hits per bin and lose
+	// precision gradually beyond that.
+	Float32
+)
+
+// storageNames maps storage layouts to their names.
+var storageNames = [...]string{
+	Counts64: "counts64",
+	Counts32: "counts32",
+	Float32:  "float32",
+}
+
+// ParseStorage finds a storage layout by name.
+func ParseStorage(name string) (Storage, error) {
+	for i, s := range storageNames {
+		if s == name {
+			return Storage(i), nil
+		}
+	}
+	return 0, fmt.Errorf("xirho: no histogram storage named %q", name)
+}
+
+// String returns the name of the storage layout.
+func (s Storage) String() string {
+	if int(s) >= len(storageNames) {
+		return fmt.Sprintf("Storage(%d)", uint8(s))
+	}
+	return storageNames[s]
+}
+
+// MarshalText encodes the storage layout as its name.
+func (s Storage) MarshalText() ([]byte, error) {
+	if int(s) >= len(storageNames) {
+		return nil, fmt.Errorf("xirho: unknown histogram storage %d", uint8(s))
+	}
+	return []byte(storageNames[s]), nil
+}
+
+// UnmarshalText decodes a storage layout from its name.
+func (s *Storage) UnmarshalText(text []byte) error {
+	r, err := ParseStorage(string(text))
+	if err != nil {
+		return err
+	}
+	*s = r
+	return nil
+}
+
+// binSize returns the size in bytes of one bin in the layout.
+func (s Storage) binSize() uintptr {
+	switch s {
+	case Counts32, Float32:
+		return unsafe.Sizeof(bin32{})
+	default:
+		return unsafe.Sizeof(bin{})
+	}
+}
+
+// bin32 is a histogram bin for the compact layouts. For Float32, the channels
+// hold the bits of float32 values.
+type bin32 struct {
+	// r, g, b are the red, green, and blue channels.
+	r, g, b atomic.Uint32
+	// n is the bin count.
+	n atomic.Uint32
+}
+
+// addf atomically adds x to a float32 stored as bits in p.
+func addf(p *atomic.Uint32, x float32) {
+	for {
+		old := p.Load()
+		if p.CompareAndSwap(old, math.Float32bits(math.Float32frombits(old)+x)) {
+			return
+		}
+	}
+}
+
+// loadf loads a float32 channel as an integer count. Channels only ever
+// accumulate integers, and float32 rounding of integers gives integers, so
+// the conversion is exact.
+func loadf(p *atomic.Uint32) uint64 {
+	return uint64(math.Float32frombits(p.Load()))
+}
+
+// add32 atomically adds x to a 32-bit channel, returning the amount which
+// overflowed, as a multiple of 1<<32.
+func add32(p *atomic.Uint32, x uint64) uint64 {
+	lo := uint32(x)
+	v := p.Add(lo)
+	hi := x >> 32
+	if v < lo {
+		hi++
+	}
+	return hi
+}
+
+// carries holds the high parts of Counts32 channels which have overflowed.
+type carries struct {
+	mu sync.Mutex
+	// m maps bin indices to the high 32 bits of each channel, in the order
+	// red, green, blue, count.
+	m map[int][4]uint64
+	// any is set once any channel has overflowed, so that loads can skip the
+	// lock in the common case.
+	any atomic.Bool
+}
+
+// add adds hi<<32 to channel ch of bin i.
+func (c *carries) add(i, ch int, hi uint64) {
+	c.mu.Lock()
+	if c.m == nil {
+		c.m = make(map[int][4]uint64)
+	}
+	v := c.m[i]
+	v[ch] += hi << 32
+	c.m[i] = v
+	c.any.Store(true)
+	c.mu.Unlock()
+}
+
+// get returns the high parts of bin i.
+func (c *carries) get(i int) (v [4]uint64) {
+	if !c.any.Load() {
+		return v
+	}
+	c.mu.Lock()
+	v = c.m[i]
+	c.mu.Unlock()
+	return v
+}
+
+// snapshot returns a copy of the high parts of all bins which have any, or
+// nil if none do.
+func (c *carries) snapshot() map[int][4]uint64 {
+	if !c.any.Load() {
+		return nil
+	}
+	c.mu.Lock()
+	m := maps.Clone(c.m)
+	c.mu.Unlock()
+	return m
+}
+
+// reset discards all high parts.
+func (c *carries) reset() {
+	c.mu.Lock()
+	c.m = nil
+	c.any.Store(false)
+	c.mu.Unlock()
+}
@@ -0,0 +1,171 @@
+package hist
+
+import (
+	"bytes"
+	"image/color"
+	"math/rand"
+	"testing"
+	"unsafe"
+)
+
+var storages = []Storage{Counts64, Counts32, Float32}
+
+func TestStorageNames(t *testing.T) {
+	for _, s := range storages {
+		b, err := s.MarshalText()
+		if err != nil {
+			t.Errorf("couldn't marshal %v: %v", s, err)
+			continue
+		}
+		var r Storage
+		if err := r.UnmarshalText(b); err != nil {
+			t.Errorf("couldn't unmarshal %q: %v", b, err)
+		}
+		if r != s {
+			t.Errorf("wrong storage from %q: want %v, got %v", b, s, r)
+		}
+	}
+	if _, err := ParseStorage("counts16"); err == nil {
+		t.Error("no error parsing unknown storage")
+	}
+}
+
+func TestStorageMem(t *testing.T) {
+	for _, s := range storages {
+		sz := Size{W: 13, H: 7, OSA: 2, Storage: s}
+		h := New(sz)
+		act := unsafe.Sizeof(*h) + uintptr(len(h.counts))*unsafe.Sizeof(bin{}) + uintptr(len(h.compact))*unsafe.Sizeof(bin32{})
+		if est := sz.Mem(); est != act {
+			t.Errorf("%v: wrong histogram size; estimated %d but actual size is %d", s, est, act)
+		}
+		if h.Size() != sz {
+			t.Errorf("%v: wrong size: want %+v, got %+v", s, sz, h.Size())
+		}
+	}
+	// The largest histograms fit only in compact layouts.
+	sz := Size{W: 1 << 27, H: 1 << 28, OSA: 1}
+	if !sz.Overflows() {
+		t.Errorf("%+v should overflow", sz)
+	}
+	sz.Storage = Counts32
+	if sz.Overflows() {
+		t.Errorf("%+v should not overflow", sz)
+	}
+}
+
+func TestStorageAdd(t *testing.T) {
+	for _, s := range storages {
+		sz := Size{W: 5, H: 3, OSA: 2}
+		want := New(sz)
+		sz.Storage = s
+		h := New(sz)
+		for i := 0; i < 3; i++ {
+			fill(want)
+			fill(h)
+		}
+		if !samebins(want, h) {
+			t.Errorf("%v: wrong bins after adding", s)
+		}
+		// Round trip through checkpoints and merging with other layouts.
+		var b bytes.Buffer
+		if _, err := h.WriteCheckpoint(&b, Checkpoint{}); err != nil {
+			t.Fatal(err)
+		}
+		c := New(sz)
+		if _, err := c.ReadCheckpoint(bytes.NewReader(b.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if c.Size() != sz || !samebins(want, c) {
+			t.Errorf("%v: wrong bins after checkpoint", s)
+		}
+		if err := c.Merge(want); err != nil {
+			t.Fatal(err)
+		}
+		if err := want.Merge(h); err != nil {
+			t.Fatal(err)
+		}
+		if !samebins(want, c) {
+			t.Errorf("%v: wrong bins after merge", s)
+		}
+		h.Reset(sz)
+		r, g, b0, n := h.get(0)
+		if r|g|b0|n != 0 {
+			t.Errorf("%v: reset failed to zero bin: have %d %d %d %d", s, r, g, b0, n)
+		}
+	}
+}
+
+func TestStorageOverflow(t *testing.T) {
+	h := New(Size{W: 2, H: 1, OSA: 1, Storage: Counts32})
+	c := color.RGBA64{R: 0xffff, G: 1, B: 0x8000, A: 0xffff}
+	const n = 1<<32/0xffff + 100
+	for i := 0; i < n; i++ {
+		h.Add(1, 0, c)
+	}
+	r, g, b, a := h.get(1)
+	if r != n*0xffff || g != n || b != n*0x8000 || a != n*0xffff {
+		t.Errorf("wrong counts after overflow: want %d %d %d %d, got %d %d %d %d", n*0xffff, n, n*0x8000, n*0xffff, r, g, b, a)
+	}
+	if r, g, b, a := h.get(0); r|g|b|a != 0 {
+		t.Errorf("overflow leaked into neighbor: %d %d %d %d", r, g, b, a)
+	}
+	var buf bytes.Buffer
+	if _, err := h.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	m := New(Size{W: 2, H: 1, OSA: 1})
+	if _, err := m.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !samebins(h, m) {
+		t.Error("wrong bins after reading overflowed histogram")
+	}
+}
+
+func TestStorageImage(t *testing.T) {
+	// Compact layouts are exact for modest counts, so images match.
+	tm := ToneMap{Brightness: 4, Gamma: 2, Contrast: 1, Density: DensityFilter{MaxRadius: 2, Curve: 0.4}}
+	for _, s := range storages[1:] {
+		want := New(Size{W: 8, H: 8, OSA: 1})
+		h := New(Size{W: 8, H: 8, OSA: 1, Storage: s})
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 2000; i++ {
+			x, y := rng.Intn(8), rng.Intn(4)
+			c := color.RGBA64{R: uint16(rng.Intn(0x10000)), G: uint16(rng.Intn(0x10000)), B: 0x1234, A: 0xffff}
+			want.Add(x, y, c)
+			h.Add(x, y, c)
+		}
+		a, b := want.Image(tm, 1, 2000), h.Image(tm, 1, 2000)
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				if p, q := a.At(x, y), b.At(x, y); p != q {
+					t.Errorf("%v: wrong color at %d,%d: want %v, got %v", s, x, y, p, q)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	for _, s := range storages {
+		b.Run(s.String(), func(b *testing.B) {
+			sz := Size{W: 2048, H: 2048, OSA: 1, Storage: s}
+			h := New(sz)
+			b.ReportMetric(float64(sz.Mem())/float64(sz.Bins()), "B/bin")
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(rand.Int63()))
+				c := color.RGBA64{R: 0x8000, G: 0x4000, B: 0x2000, A: 0xffff}
+				for pb.Next() {
+					// Points concentrate near the center like a typical
+					// flame, with some scattered across the whole image.
+					x := int(rng.NormFloat64()*256) + 1024
+					y := int(rng.NormFloat64()*256) + 1024
+					if x < 0 || x >= 2048 || y < 0 || y >= 2048 {
+						x, y = rng.Intn(2048), rng.Intn(2048)
+					}
+					h.Add(x, y, c)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package xirho
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// MotionFunc describes a system and camera that change over time. It returns
+// the state at time t in the interval [0, 1]. Each call should return a system
+// that does not share mutable state with systems from other calls, as the
+// renderer prepares and iterates them concurrently. Package fapi provides
+// Motion to interpolate between two systems.
+type MotionFunc func(t float64) (System, xmath.Affine)
+
+// motionLen is the minimum number of iterations in each trajectory of a
+// motion blurred render, and motionMaxLen is the maximum. Every trajectory
+// requires creating and preparing a new system, so trajectories need to be
+// long enough to amortize that cost, but short enough that a render samples
+// many distinct times. Each worker adapts the length of its trajectories
+// within these bounds so that iterating takes about motionPrepRatio times as
+// long as preparing. motionMaxLen is kept small because each trajectory plots
+// a single frozen instant; fewer, longer trajectories show as discrete ghosts
+// of the system rather than smooth blur.
+const (
+	motionLen       = 10000
+	motionMaxLen    = 5 * motionLen
+	motionPrepRatio = 20
+)
+
+// motionIters computes the length of the next trajectory given the length of
+// the last one and the time spent preparing and iterating it.
+func motionIters(n int64, prep, iter time.Duration) int64 {
+	if iter <= 0 {
+		return motionLen
+	}
+	m := float64(n) * motionPrepRatio * float64(prep) / float64(iter)
+	return int64(min(max(m, motionLen), motionMaxLen))
+}
+
+// RenderMotion renders a system that changes over time onto a Hist, producing
+// motion blur. Each worker repeatedly samples a uniformly random time, obtains
+// the system and camera at that time from motion, and plots a trajectory of
+// that system through that camera. The renderer's Camera is not used to plot
+// points, but it still determines Area, so it should be set to a camera that
+// is representative of the motion, e.g. the camera at time 0.
+//
+// Each trajectory runs for between ten and fifty thousand iterations so that
+// preparing a system for each time does not dominate the render, with
+// systems that are slower to prepare getting longer trajectories. Since each
+// trajectory plots a single time, a render with too few iterations shows
+// strobing, i.e. separate copies of the system along the motion rather than
+// smooth blur. Budgets for motion blurred renders should allow many times
+// more iterations than the trajectory length per worker.
+//
+// Calculation is performed by procs goroutines, or by GOMAXPROCS goroutines if
+// procs <= 0. RenderMotion returns after the context closes or the renderer's
+// Budget is met, and after all its renderer goroutines finish.
+func (r *Render) RenderMotion(ctx context.Context, motion MotionFunc, procs int) {
+	rng := xmath.NewRNG()
+	if procs <= 0 {
+		procs = runtime.GOMAXPROCS(0)
+	}
+	defer r.watch(r.Budget)()
+	var wg sync.WaitGroup
+	wg.Add(procs)
+	for i := 0; i < procs; i++ {
+		go func(rng xmath.RNG) {
+			defer wg.Done()
+			n := int64(motionLen)
+			for ctx.Err() == nil {
+				system, cam := motion(rng.Uniform())
+				t0 := time.Now()
+				system.Prep()
+				t1 := time.Now()
+				if !system.iter(ctx, r, &rng, &cam, n, true) {
+					return
+				}
+				n = motionIters(n, t1.Sub(t0), time.Since(t1))
+			}
+		}(rng)
+		rng.Jump()
+	}
+	wg.Wait()
+}
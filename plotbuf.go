@@ -0,0 +1,83 @@
+package xirho
+
+import (
+	"image/color"
+	"math/bits"
+
+	"github.com/zephyrtronium/xirho/hist"
+)
+
+// plotBuffer is a per-worker write-back cache of histogram bins. Points
+// plotted to the same bin accumulate in the buffer until another bin which
+// maps to the same slot evicts them or the buffer is flushed.
+type plotBuffer struct {
+	h *hist.Hist
+	// slots is the cache, with a power of two length.
+	slots []plotSlot
+	// shift maps hashes to slot indices.
+	shift uint
+	// used is the indices of occupied slots, so that flushing touches only
+	// those rather than the entire buffer.
+	used []int32
+}
+
+// maxPlotBuffer is the largest number of slots in a plot buffer.
+const maxPlotBuffer = 1 << 20
+
+// plotSlot holds the channel sums of one bin.
+type plotSlot struct {
+	// x and y are the bin coordinates. x is -1 if the slot is empty.
+	x, y int32
+	// r, g, b, n are the channel sums.
+	r, g, b, n uint64
+}
+
+// newPlotBuffer creates a buffer with at least size slots, up to
+// maxPlotBuffer.
+func newPlotBuffer(h *hist.Hist, size int) *plotBuffer {
+	k := bits.Len(uint(min(size, maxPlotBuffer) - 1))
+	b := plotBuffer{
+		h:     h,
+		slots: make([]plotSlot, 1<<k),
+		shift: uint(64 - k),
+	}
+	for i := range b.slots {
+		b.slots[i].x = -1
+	}
+	return &b
+}
+
+// add plots a color to a bin.
+func (b *plotBuffer) add(x, y int, c color.RGBA64) {
+	// Fibonacci hashing spreads nearby bins across the buffer.
+	i := (uint64(y)<<32 | uint64(x)) * 0x9e3779b97f4a7c15 >> b.shift
+	s := &b.slots[i]
+	if s.x != int32(x) || s.y != int32(y) {
+		if s.x < 0 {
+			b.used = append(b.used, int32(i))
+		} else {
+			b.evict(s)
+		}
+		s.x, s.y = int32(x), int32(y)
+	}
+	s.r += uint64(c.R)
+	s.g += uint64(c.G)
+	s.b += uint64(c.B)
+	s.n += uint64(c.A)
+}
+
+// evict adds a slot's sums to the histogram and empties it.
+func (b *plotBuffer) evict(s *plotSlot) {
+	if s.x >= 0 {
+		b.h.AddCounts(int(s.x), int(s.y), s.r, s.g, s.b, s.n)
+	}
+	*s = plotSlot{x: -1}
+}
+
+// flush adds all buffered points to the histogram.
+func (b *plotBuffer) flush() {
+	for _, i := range b.used {
+		b.evict(&b.slots[i])
+	}
+	b.used = b.used[:0]
+}
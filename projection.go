@@ -0,0 +1,272 @@
+package xirho
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// Projection describes how points in camera coordinates project onto the
+// output. The zero value is orthographic projection, which discards depth.
+type Projection struct {
+	// Lens is the camera model.
+	Lens Lens
+	// FOV is the field of view in radians across the longer axis of the
+	// output, or of each eye's half of the output in stereo. Its meaning
+	// depends on the lens; see the documentation of each.
+	FOV float64
+	// Near is the distance from the viewer within which points are not
+	// plotted. It does not apply to orthographic projection. Points behind
+	// a rectilinear viewer are never plotted.
+	Near float64
+	// Stereo is the separation between the eyes of a stereo pair along the
+	// camera x axis. If it is nonzero, each point is plotted once for each
+	// eye, the left eye onto the left half of the output and the right eye
+	// onto the right half. Under orthographic projection, both eyes see the
+	// same image.
+	Stereo float64
+}
+
+// Lens selects a camera model for projection.
+type Lens uint8
+
+const (
+	// Rectilinear is orthographic projection if FOV is not positive and
+	// perspective projection otherwise. Under perspective, FOV is the angle
+	// subtended by the output at the plane of camera z = 0, so that points on
+	// that plane appear where orthographic projection would place them, and
+	// points nearer the viewer appear larger. The viewer is on the positive
+	// z axis at distance 1/tan(FOV/2), looking toward negative z. FOV must be
+	// less than π. In stereo, the eyes converge at z = 0.
+	Rectilinear Lens = iota
+	// Fisheye is equidistant fisheye projection from a viewer at the camera
+	// origin looking toward negative z: a point's distance from the center of
+	// the output is proportional to its angle from the view axis. FOV is the
+	// angle across the output, defaulting to π if it is not positive, which
+	// places the whole hemisphere in front of the viewer in a circle inscribed
+	// in a square output.
+	Fisheye
+	// Equirectangular is 360° panoramic projection from a viewer at the
+	// camera origin. Longitude measured from negative z toward positive x
+	// maps to the horizontal axis, and latitude toward positive y maps to the
+	// vertical axis, at a rate of FOV radians across the output, defaulting to
+	// 2π if FOV is not positive. Hence a 2:1 output with the default FOV
+	// covers the full sphere. In stereo, the eyes lie on a circle of diameter
+	// Stereo, turning to face each point, as in omnidirectional stereo.
+	Equirectangular
+)
+
+// lensNames maps lenses to their names.
+var lensNames = [...]string{
+	Rectilinear:     "rectilinear",
+	Fisheye:         "fisheye",
+	Equirectangular: "equirectangular",
+}
+
+// ParseLens finds a lens by name.
+func ParseLens(name string) (Lens, error) {
+	for i, s := range lensNames {
+		if s == name {
+			return Lens(i), nil
+		}
+	}
+	return 0, fmt.Errorf("xirho: no lens named %q", name)
+}
+
+// String returns the name of the lens.
+func (l Lens) String() string {
+	if int(l) >= len(lensNames) {
+		return fmt.Sprintf("Lens(%d)", uint8(l))
+	}
+	return lensNames[l]
+}
+
+// MarshalText encodes the lens as its name.
+func (l Lens) MarshalText() ([]byte, error) {
+	if int(l) >= len(lensNames) {
+		return nil, fmt.Errorf("xirho: unknown lens %d", uint8(l))
+	}
+	return []byte(lensNames[l]), nil
+}
+
+// UnmarshalText decodes a lens from its name.
+func (l *Lens) UnmarshalText(text []byte) error {
+	r, err := ParseLens(string(text))
+	if err != nil {
+		return err
+	}
+	*l = r
+	return nil
+}
+
+// persp returns the perspective factor of a rectilinear projection, which is
+// the reciprocal of the distance from the viewer to the plane z = 0, or 0 for
+// orthographic projection.
+func (p *Projection) persp() float64 {
+	if p.Lens != Rectilinear || p.FOV <= 0 {
+		return 0
+	}
+	return math.Tan(p.FOV / 2)
+}
+
+// Translate adjusts a camera so that points projected through it with a
+// rectilinear lens move by dx and dy after projection. Under orthographic
+// projection, this is the same as translating the camera. Under perspective
+// projection, translating the camera instead moves points by an amount
+// depending on their depth. For other lenses, Translate translates the
+// camera.
+func (p *Projection) Translate(cam *xmath.Affine, dx, dy float64) {
+	t := p.persp()
+	for i := 0; i < 4; i++ {
+		cam[i] -= dx * t * cam[8+i]
+		cam[4+i] -= dy * t * cam[8+i]
+	}
+	cam[3] += dx
+	cam[7] += dy
+}
+
+// eyes returns the number of eyes the projection plots.
+func (p *Projection) eyes() int {
+	if p.Stereo != 0 {
+		return 2
+	}
+	return 1
+}
+
+// frame places the histogram of a tile within a larger output.
+type frame struct {
+	// cols and rows are the size in bins of the full output.
+	cols, rows int
+	// x and y are the position in bins of the histogram within the output.
+	x, y int
+}
+
+// view is a projection prepared for plotting onto a histogram.
+type view struct {
+	lens Lens
+	// t is the perspective factor of a rectilinear lens, or 0 for
+	// orthographic projection.
+	t float64
+	// k is the scale from angles to camera coordinates for other lenses.
+	k float64
+	// near is the near clipping distance. For a rectilinear lens, it is
+	// instead the least plotted value of 1 - t*z, which is the clipping
+	// distance in units of the viewer's distance from z = 0.
+	near float64
+	// eye is half the stereo eye separation.
+	eye float64
+	// cols and rows are the size in bins of the area onto which each eye
+	// plots, and aspect is its aspect ratio.
+	cols, rows, aspect float64
+	// x and y are the position in bins of the histogram within the full
+	// output, and w and h are its size.
+	x, y, w, h int
+}
+
+// view prepares the renderer's projection for plotting.
+func (r *Render) view() view {
+	p := &r.Projection
+	v := view{
+		lens: p.Lens,
+		t:    p.persp(),
+		near: math.Max(p.Near, 0),
+		eye:  p.Stereo / 2,
+		w:    r.Hist.Cols(),
+		h:    r.Hist.Rows(),
+	}
+	switch p.Lens {
+	case Rectilinear:
+		v.near *= v.t
+	case Fisheye:
+		v.k = 2 / math.Pi
+		if p.FOV > 0 {
+			v.k = 2 / p.FOV
+		}
+	case Equirectangular:
+		v.k = 1 / math.Pi
+		if p.FOV > 0 {
+			v.k = 2 / p.FOV
+		}
+	}
+	cols, rows := v.w, v.h
+	if r.frame != nil {
+		v.x, v.y = r.frame.x, r.frame.y
+		cols, rows = r.frame.cols, r.frame.rows
+	}
+	v.cols, v.rows = float64(cols)/float64(p.eyes()), float64(rows)
+	v.aspect = v.cols / v.rows
+	return v
+}
+
+// project projects a point in camera coordinates as seen by an eye at camera
+// x coordinate e. The result is false if the point is clipped.
+func (v *view) project(x, y, z, e float64) (float64, float64, bool) {
+	switch v.lens {
+	case Fisheye:
+		x -= e
+		d := math.Hypot(x, y)
+		if !(math.Hypot(d, z) >= v.near) {
+			return 0, 0, false
+		}
+		if d == 0 {
+			if z > 0 {
+				// Directly behind the viewer.
+				return 0, 0, false
+			}
+			return 0, 0, true
+		}
+		s := v.k * math.Atan2(d, -z) / d
+		return x * s, y * s, true
+	case Equirectangular:
+		h := math.Hypot(x, z)
+		lon := math.Atan2(x, -z)
+		if e != 0 {
+			// Turn the eye to face the point around a circle of radius |e|.
+			if !(h > math.Abs(e)) {
+				return 0, 0, false
+			}
+			lon -= math.Asin(e / h)
+			h = math.Sqrt(h*h - e*e)
+			if lon > math.Pi {
+				lon -= 2 * math.Pi
+			} else if lon < -math.Pi {
+				lon += 2 * math.Pi
+			}
+		}
+		if !(math.Hypot(h, y) >= v.near) {
+			return 0, 0, false
+		}
+		return lon * v.k, math.Atan2(y, h) * v.k, true
+	default:
+		if v.t == 0 {
+			return x, y, true
+		}
+		w := 1 - v.t*z
+		// negated condition to catch nans
+		if !(w > v.near) {
+			return 0, 0, false
+		}
+		return (x-e)/w + e, y / w, true
+	}
+}
+
+// bin maps projected coordinates for one eye to a bin of the histogram. The
+// result is false if the point is outside the histogram.
+func (v *view) bin(x, y float64, eye int) (col, row int, ok bool) {
+	if v.aspect >= 1 {
+		y *= v.aspect
+	} else {
+		x /= v.aspect
+	}
+	// negated condition to catch nans
+	if !(x >= -1 && x < 1 && y >= -1 && y < 1) {
+		return 0, 0, false
+	}
+	col = int((x+1)*0.5*v.cols+float64(eye)*v.cols) - v.x
+	row = int((y+1)*0.5*v.rows) - v.y
+	if col < 0 || col >= v.w || row < 0 || row >= v.h {
+		return 0, 0, false
+	}
+	return col, row, true
+}
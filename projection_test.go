@@ -0,0 +1,94 @@
+package xirho_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+func TestProjection(t *testing.T) {
+	// Renders are 32x32. With a field of view of 90°, a rectilinear viewer is
+	// at z = 1, and points at depth z scale by 1/(1-z). In stereo, each eye
+	// has a 16x32 half.
+	persp := xirho.Projection{FOV: math.Pi / 2}
+	fisheye := xirho.Projection{Lens: xirho.Fisheye}
+	equirect := xirho.Projection{Lens: xirho.Equirectangular}
+	cases := []struct {
+		name string
+		p    xirho.Pt
+		proj xirho.Projection
+		bins []int
+	}{
+		{"ortho", xirho.Pt{X: 0.25, Y: -0.25, Z: 0.5}, xirho.Projection{}, []int{12*32 + 20}},
+		{"focal", xirho.Pt{X: 0.25, Y: -0.25, Z: 0}, persp, []int{12*32 + 20}},
+		{"near", xirho.Pt{X: 0.25, Y: -0.25, Z: 0.5}, persp, []int{8*32 + 24}},
+		{"far", xirho.Pt{X: 0.25, Y: -0.25, Z: -1}, persp, []int{14*32 + 18}},
+		{"behind", xirho.Pt{X: 0.25, Y: -0.25, Z: 2}, persp, nil},
+		{"clipped", xirho.Pt{X: 0.25, Y: -0.25, Z: 0.5}, xirho.Projection{FOV: math.Pi / 2, Near: 0.6}, nil},
+		{"unclipped", xirho.Pt{X: 0.1, Y: -0.1, Z: 0.5}, xirho.Projection{FOV: math.Pi / 2, Near: 0.4}, []int{12*32 + 19}},
+		{"fisheye-center", xirho.Pt{Z: -1}, fisheye, []int{16*32 + 16}},
+		{"fisheye-side", xirho.Pt{X: 1, Z: -1}, fisheye, []int{16*32 + 24}},
+		{"fisheye-narrow", xirho.Pt{X: 1, Z: -2}, xirho.Projection{Lens: xirho.Fisheye, FOV: math.Pi / 2}, []int{16*32 + 25}},
+		{"fisheye-behind", xirho.Pt{Z: 1}, fisheye, nil},
+		{"fisheye-clipped", xirho.Pt{X: 1, Z: -1}, xirho.Projection{Lens: xirho.Fisheye, Near: 1.5}, nil},
+		{"equirect-right", xirho.Pt{X: 1}, equirect, []int{16*32 + 24}},
+		{"equirect-up", xirho.Pt{Y: -1, Z: -1}, equirect, []int{12*32 + 16}},
+		{"stereo-near", xirho.Pt{Z: 0.5}, xirho.Projection{FOV: math.Pi / 2, Stereo: 0.5}, []int{16*32 + 12, 16*32 + 20}},
+		{"stereo-focal", xirho.Pt{Z: 0}, xirho.Projection{FOV: math.Pi / 2, Stereo: 0.5}, []int{16*32 + 8, 16*32 + 24}},
+		{"stereo-equirect", xirho.Pt{Z: -1}, xirho.Projection{Lens: xirho.Equirectangular, Stereo: 0.5}, []int{16*32 + 9, 16*32 + 22}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.p.C = 0.5
+			n := projected(t, c.p, xirho.Depth{}, c.proj)
+			for i, x := range n {
+				if (x != 0) != slices.Contains(c.bins, i) {
+					t.Errorf("wrong count at bin %d,%d: %d", i%32, i/32, x)
+				}
+			}
+		})
+	}
+}
+
+func TestLensNames(t *testing.T) {
+	for _, l := range []xirho.Lens{xirho.Rectilinear, xirho.Fisheye, xirho.Equirectangular} {
+		b, err := l.MarshalText()
+		if err != nil {
+			t.Errorf("couldn't marshal %v: %v", l, err)
+			continue
+		}
+		var r xirho.Lens
+		if err := r.UnmarshalText(b); err != nil || r != l {
+			t.Errorf("wrong round trip of %v: got %v, %v", l, r, err)
+		}
+	}
+	if _, err := xirho.ParseLens("pinhole"); err == nil {
+		t.Error("no error parsing unknown lens")
+	}
+}
+
+func TestProjectionTranslate(t *testing.T) {
+	rng := xmath.NewRNG()
+	proj := xirho.Projection{FOV: 1.2}
+	cam := xmath.Eye()
+	cam.RotX(0.4).RotY(-0.7).Scale(0.8, 0.8, 0.8).Translate(0.1, 0.2, -0.3)
+	moved := cam
+	proj.Translate(&moved, 0.3, -0.6)
+	// Project points directly, since the projection's plotting is internal.
+	k := math.Tan(proj.FOV / 2)
+	project := func(cam *xmath.Affine, x, y, z float64) (float64, float64) {
+		x, y, z = xmath.Tx(cam, x, y, z)
+		return x / (1 - k*z), y / (1 - k*z)
+	}
+	for i := 0; i < 100; i++ {
+		x, y, z := rng.Uniform()*2-1, rng.Uniform()*2-1, rng.Uniform()*2-1
+		px, py := project(&cam, x, y, z)
+		qx, qy := project(&moved, x, y, z)
+		if math.Abs(qx-px-0.3) > 1e-12 || math.Abs(qy-py+0.6) > 1e-12 {
+			t.Errorf("wrong translation of %g,%g,%g: want %g,%g, got %g,%g", x, y, z, px+0.3, py-0.6, qx, qy)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package xirho
 import (
 	"context"
 	"image/color"
+	"io"
+	"math"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -22,23 +24,59 @@ type Render struct {
 	Camera xmath.Affine
 	// Palette is the colors used by the renderer.
 	Palette color.Palette
+	// Depth is the depth of field and fog settings.
+	Depth Depth
+	// Projection is the projection from camera coordinates to the output.
+	Projection Projection
+	// Budget is the stopping criterion for the render. If it is the zero
+	// value, then rendering continues until the context closes.
+	Budget Budget
+	// OnProgress, if non-nil, is called periodically during rendering to
+	// report progress. Calls are never concurrent, and they should return
+	// quickly.
+	OnProgress func(Progress)
+	// ProgressInterval is the time between calls to OnProgress. If it is not
+	// positive, then the interval is one second.
+	ProgressInterval time.Duration
+	// Diagnose enables collecting per-node statistics, which are available
+	// from NodeStats. Rendering is slightly slower while it is set.
+	Diagnose bool
+	// PlotBuffer is the number of bins in which each worker accumulates its
+	// points before adding them to the histogram. Bright regions are plotted
+	// by every worker at once, so adding each point directly makes workers
+	// contend for the same memory; buffering combines repeated points in the
+	// same bins so that the histogram receives fewer, larger additions. If
+	// PlotBuffer is not positive, points are added to the histogram directly.
+	// Values above 1<<20 are treated as 1<<20.
+	// Buffers are flushed periodically while rendering and whenever workers
+	// stop, so the histogram is complete whenever rendering returns or
+	// RenderAsync pauses.
+	PlotBuffer int
 	// n is the number of points calculated.
 	n atomic.Int64
 	// q is the number of points plotted.
 	q atomic.Int64
+	// mu guards stats.
+	mu sync.Mutex
+	// stats is the per-node statistics, if Diagnose is set.
+	stats []nodeStats
+	// frame, if not nil, places the histogram within a larger output, so
+	// that the renderer plots one tile of it.
+	frame *frame
 }
 
 // Render renders a System onto a Hist. Calculation is performed by procs
 // goroutines, or by GOMAXPROCS goroutines if procs <= 0. Render returns after
-// the context closes and after all its renderer goroutines finish. It is safe
-// to call Render multiple times in succession to continue using the same
-// histogram.
+// the context closes or the renderer's Budget is met, and after all its
+// renderer goroutines finish. It is safe to call Render multiple times in
+// succession to continue using the same histogram.
 func (r *Render) Render(ctx context.Context, system System, procs int) {
 	rng := xmath.NewRNG()
 	if procs <= 0 {
 		procs = runtime.GOMAXPROCS(0)
 	}
 	system.Prep()
+	defer r.watch(r.Budget)()
 	var wg sync.WaitGroup
 	wg.Add(procs)
 	for i := 0; i < procs; i++ {
@@ -51,6 +89,45 @@ func (r *Render) Render(ctx context.Context, system System, procs int) {
 	wg.Wait()
 }
 
+// RenderSeeded renders exactly iters iterations of a System onto a Hist
+// deterministically. Calculation is performed by procs goroutines, or by
+// GOMAXPROCS goroutines if procs <= 0, each of which performs a fixed share of
+// the iterations using a random number generator derived from seed. Rendering
+// the same system with the same renderer settings, seed, procs, and iters
+// produces an identical histogram, provided the context does not close first.
+// Since the default number of goroutines varies between machines, procs should
+// be given explicitly when the result must be reproduced elsewhere.
+//
+// The exception is a histogram using Float32 storage with procs > 1. Floating
+// point addition is not associative, so the order in which goroutines add to
+// a shared bin affects rounding, and results may differ slightly between runs.
+//
+// RenderSeeded returns after all its renderer goroutines finish, either
+// because they completed their iterations or because the context closed. The
+// renderer's Budget is ignored.
+func (r *Render) RenderSeeded(ctx context.Context, system System, procs int, seed uint64, iters int64) {
+	rng := xmath.NewSeededRNG(seed)
+	if procs <= 0 {
+		procs = runtime.GOMAXPROCS(0)
+	}
+	system.Prep()
+	defer r.watch(Budget{Iters: r.Iters() + iters})()
+	var wg sync.WaitGroup
+	wg.Add(procs)
+	for i := 0; i < procs; i++ {
+		n := iters / int64(procs)
+		if int64(i) < iters%int64(procs) {
+			n++
+		}
+		go func(rng xmath.RNG, n int64) {
+			system.iter(ctx, r, &rng, &r.Camera, n, false)
+			wg.Done()
+		}(rng, n)
+		rng.Jump()
+	}
+	wg.Wait()
+}
+
 // RenderAsync manages asynchronous rendering. It is intended to be used in a
 // go statement. The renderer does not begin work until receiving a System and
 // other render settings over the change channel.
@@ -62,6 +139,12 @@ func (r *Render) Render(ctx context.Context, system System, procs int) {
 // prevent data races. It also attempts to group together multiple changes and
 // plot requests to reduce unnecessary work.
 //
+// If the renderer has a Budget, then workers stop once it is met. They resume
+// when a change increases the budget or resets the histogram.
+//
+// If the renderer has an OnProgress hook, then RenderAsync calls it from its
+// own goroutine, with elapsed time measured from the last reset.
+//
 // Once the context closes, RenderAsync stops its workers, closes the imgs
 // channel, and returns. If needed, other goroutines may join on RenderAsync by
 // waiting for imgs to close. Until imgs closes, it is not safe to modify any
@@ -77,17 +160,27 @@ func (r *Render) RenderAsync(ctx context.Context, change <-chan ChangeRender, pl
 		system System
 		out    chan<- draw.Image
 		img    draw.Image
+		tick   <-chan time.Time
+		prog   progress
 	)
+	if r.OnProgress != nil {
+		t := time.NewTicker(r.interval())
+		defer t.Stop()
+		tick = t.C
+		prog = r.newProgress(time.Now())
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			cancel()
 			return
+		case now := <-tick:
+			r.OnProgress(r.progress(&prog, r.Budget, now))
 		case c := <-change:
 			cancel()
 			c = drainchg(c, change)
 			rctx, cancel = context.WithCancel(ctx)
-			x, y, osa := r.Hist.Width(), r.Hist.Height(), r.Hist.OSA()
+			sz := r.Hist.Size()
 			reset := false
 			wg.Wait() // TODO: select with ctx.Done
 			if !c.System.Empty() {
@@ -95,19 +188,32 @@ func (r *Render) RenderAsync(ctx context.Context, change <-chan ChangeRender, pl
 				reset = true
 			}
 			if c.Size.Bins() != 0 {
-				x, y, osa = c.Size.W, c.Size.H, c.Size.OSA
+				sz = c.Size
 				reset = true
 			}
 			if c.Camera != nil {
 				r.Camera = *c.Camera
 				reset = true
 			}
+			if c.Depth != nil {
+				r.Depth = *c.Depth
+				reset = true
+			}
+			if c.Projection != nil {
+				r.Projection = *c.Projection
+				reset = true
+			}
+			if c.Budget != nil {
+				r.Budget = *c.Budget
+			}
 			if len(c.Palette) != 0 {
 				r.Palette = append(color.Palette{}, c.Palette...)
 				reset = true
 			}
 			if reset {
-				r.Reset(x, y, osa)
+				r.ResetCounts()
+				r.Hist.Reset(sz)
+				prog = r.newProgress(time.Now())
 			}
 			procs = c.Procs
 			r.start(rctx, &wg, procs, system, &rng)
@@ -147,34 +253,140 @@ func (r *Render) start(ctx context.Context, wg *sync.WaitGroup, procs int, syste
 	}
 }
 
-// plot plots a point.
-func (r *Render) plot(x, y, z float64, c color.RGBA64, aspect float64) bool {
-	x, y, _ = xmath.Tx(&r.Camera, x, y, z) // ignore z
-	var col, row int
-	if aspect >= 1 {
-		y *= aspect
-	} else {
-		x /= aspect
+// watch starts reporting progress toward a budget to r.OnProgress, if it is
+// set, until the returned function is called. The returned function makes a
+// final report before returning.
+func (r *Render) watch(b Budget) func() {
+	if r.OnProgress == nil {
+		return func() {}
+	}
+	t := time.NewTicker(r.interval())
+	done := make(chan struct{})
+	fin := make(chan struct{})
+	p := r.newProgress(time.Now())
+	go func() {
+		defer close(fin)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				r.OnProgress(r.progress(&p, b, now))
+			case <-done:
+				r.OnProgress(r.progress(&p, b, time.Now()))
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-fin
+	}
+}
+
+// interval returns the time between progress reports.
+func (r *Render) interval() time.Duration {
+	if r.ProgressInterval <= 0 {
+		return time.Second
+	}
+	return r.ProgressInterval
+}
+
+// progress tracks the state needed to report render progress.
+type progress struct {
+	// start is the time at which the render started.
+	start time.Time
+	// last is the time of the previous report.
+	last time.Time
+	// n is the iteration count at the previous report.
+	n int64
+}
+
+// newProgress starts tracking progress from the current iteration count.
+func (r *Render) newProgress(now time.Time) progress {
+	return progress{start: now, last: now, n: r.Iters()}
+}
+
+// progress computes the render's progress toward a budget and updates p for
+// the next report.
+func (r *Render) progress(p *progress, b Budget, now time.Time) Progress {
+	n, q := r.Iters(), r.Hits()
+	bins := r.Hist.Cols() * r.Hist.Rows()
+	s := Progress{
+		Iters:     n,
+		Hits:      q,
+		Elapsed:   now.Sub(p.start),
+		Remaining: -1,
+	}
+	if d := now.Sub(p.last).Seconds(); d > 0 {
+		s.Rate = float64(n-p.n) / d
+	}
+	if bins > 0 {
+		s.SPP = float64(n) / float64(bins)
+	}
+	if left, ok := b.left(n, q, bins); ok && s.Rate > 0 {
+		s.Remaining = time.Duration(float64(left) / s.Rate * float64(time.Second))
 	}
-	// negated condition to catch nans
-	if !(x >= -1 && x < 1 && y >= -1 && y < 1) {
+	p.last, p.n = now, n
+	return s
+}
+
+// plot plots a point through a camera and projection, into buf if it is not
+// nil or directly onto the histogram otherwise. rng is used for depth of field.
+// In stereo, the point is plotted for each eye, and the result is true if
+// either plotted it.
+func (r *Render) plot(buf *plotBuffer, cam *xmath.Affine, v *view, x, y, z float64, c color.RGBA64, rng *xmath.RNG) bool {
+	x, y, z = xmath.Tx(cam, x, y, z)
+	x, y = r.Depth.blur(x, y, z, rng)
+	c = r.Depth.fog(z, c)
+	if v.eye == 0 {
+		return r.plotEye(buf, v, x, y, z, c, 0)
+	}
+	left := r.plotEye(buf, v, x, y, z, c, 0)
+	right := r.plotEye(buf, v, x, y, z, c, 1)
+	return left || right
+}
+
+// plotEye plots a point in camera coordinates as seen by one eye, 0 for the
+// left or 1 for the right.
+func (r *Render) plotEye(buf *plotBuffer, v *view, x, y, z float64, c color.RGBA64, eye int) bool {
+	x, y, ok := v.project(x, y, z, v.eye*float64(2*eye-1))
+	if !ok {
 		return false
 	}
-	col = int((x + 1) * 0.5 * float64(r.Hist.Cols()))
-	row = int((y + 1) * 0.5 * float64(r.Hist.Rows()))
-	r.Hist.Add(col, row, c)
+	col, row, ok := v.bin(x, y, eye)
+	if !ok {
+		return false
+	}
+	if buf != nil {
+		buf.add(col, row, c)
+	} else {
+		r.Hist.Add(col, row, c)
+	}
 	return true
 }
 
 // Area calculates the size in Cartesian units of the area viewed through the
-// camera.
+// camera. In stereo, it is the total area viewed by both eyes.
 func (r *Render) Area() float64 {
 	d := r.Camera.ProjArea()
-	a := r.Hist.Aspect()
+	cols, rows := r.Hist.Cols(), r.Hist.Rows()
+	if r.frame != nil {
+		cols, rows = r.frame.cols, r.frame.rows
+	}
+	if rows == 0 {
+		return 0
+	}
+	eyes := float64(r.Projection.eyes())
+	a := float64(cols) / eyes / float64(rows)
 	if a > 1 {
 		a = 1 / a
 	}
-	return a / d
+	a = eyes * a / d
+	if r.frame != nil {
+		// A tile covers its share of the full output.
+		a *= float64(r.Hist.Cols()*r.Hist.Rows()) / float64(cols*rows)
+	}
+	return a
 }
 
 // Iters returns the number of iterations the renderer has performed. It is
@@ -189,17 +401,79 @@ func (r *Render) Hits() int64 {
 	return r.q.Load()
 }
 
-// ResetCounts resets the values returned by Iters and Hits to zero.
+// ResetCounts resets the values returned by Iters and Hits to zero and
+// discards per-node statistics.
 func (r *Render) ResetCounts() {
 	r.n.Store(0)
 	r.q.Store(0)
+	r.resetStats()
 }
 
-// Reset resets the histogram and the iteration counts. It is not safe to call
-// this while the renderer is running.
+// Reset resets the histogram and the iteration counts, keeping the
+// histogram's storage layout. It is not safe to call this while the renderer
+// is running.
 func (r *Render) Reset(width, height, osa int) {
 	r.ResetCounts()
-	r.Hist.Reset(hist.Size{W: width, H: height, OSA: osa})
+	r.Hist.Reset(hist.Size{W: width, H: height, OSA: osa, Storage: r.Hist.Size().Storage})
+}
+
+// Checkpoint saves the histogram and the iteration counts to w so that the
+// render can be continued later with Restore. extra is saved alongside the
+// render state; typically it is the encoded system being rendered. It is not
+// safe to call this while the renderer is running.
+func (r *Render) Checkpoint(w io.Writer, extra []byte) (int64, error) {
+	c := hist.Checkpoint{
+		Iters: r.Iters(),
+		Hits:  r.Hits(),
+		Extra: extra,
+	}
+	return r.Hist.WriteCheckpoint(w, c)
+}
+
+// Restore loads a histogram and iteration counts saved by Checkpoint,
+// replacing the renderer's current progress, and returns the extra data saved
+// with them. If the renderer has no histogram, a new one is allocated. If an
+// error occurs, the renderer's progress is reset. It is not safe to call this
+// while the renderer is running.
+func (r *Render) Restore(rd io.Reader) ([]byte, error) {
+	if r.Hist == nil {
+		r.Hist = hist.New(hist.Size{})
+	}
+	c, err := r.Hist.ReadCheckpoint(rd)
+	if err != nil {
+		r.Reset(r.Hist.Width(), r.Hist.Height(), r.Hist.OSA())
+		return nil, err
+	}
+	r.n.Store(c.Iters)
+	r.q.Store(c.Hits)
+	return c.Extra, nil
+}
+
+// Merge adds the histogram and iteration counts of another renderer into r.
+// Both renderers must have histograms of the same size. This allows a single
+// render to be split across multiple renderers and combined afterward. It is
+// not safe to call this while either renderer is running.
+func (r *Render) Merge(o *Render) error {
+	if err := r.Hist.Merge(o.Hist); err != nil {
+		return err
+	}
+	r.n.Add(o.Iters())
+	r.q.Add(o.Hits())
+	return nil
+}
+
+// MergeCheckpoint adds a histogram and iteration counts saved by Checkpoint
+// into r and returns the extra data saved with them. The checkpoint must have
+// the same histogram size as r. It is not safe to call this while the
+// renderer is running.
+func (r *Render) MergeCheckpoint(rd io.Reader) ([]byte, error) {
+	c, err := r.Hist.MergeCheckpoint(rd)
+	if err != nil {
+		return nil, err
+	}
+	r.n.Add(c.Iters)
+	r.q.Add(c.Hits)
+	return c.Extra, nil
 }
 
 // drainchg pulls items from a ChangeRender channel until doing so would block,
@@ -247,20 +521,94 @@ type ChangeRender struct {
 	// System is the new system to render. If the system is empty, then the
 	// renderer continues using its previous non-empty system.
 	System System
-	// Size is the new histogram size to render. If this is the zero value,
-	// then the histogram is neither resized nor reset. If this is equal to the
-	// histogram's current size, then all plotting progress is cleared.
+	// Size is the new histogram size and storage layout to render. If this
+	// is the zero value, then the histogram is neither resized nor reset. If
+	// this is equal to the histogram's current size, then all plotting
+	// progress is cleared.
 	Size hist.Size
 	// Camera is the new camera transform to use, if non-nil.
 	Camera *xmath.Affine
+	// Depth is the new depth of field and fog settings to use, if non-nil.
+	Depth *Depth
+	// Projection is the new projection to use, if non-nil.
+	Projection *Projection
 	// Palette is the new palette to use, if it has nonzero length. The palette
 	// is copied into the renderer.
 	Palette color.Palette
+	// Budget is the new stopping criterion to use, if non-nil. Changing the
+	// budget does not reset rendering progress.
+	Budget *Budget
 	// Procs is the new number of worker goroutines to use. If this is zero,
 	// then the renderer does no work until receiving a nonzero Procs.
 	Procs int
 }
 
+// Budget is a stopping criterion for a render. Each nonzero field sets a
+// limit, and rendering stops once any limit is reached. Workers check the
+// budget periodically, so a render may slightly exceed it.
+type Budget struct {
+	// Iters is the total number of iterations to perform.
+	Iters int64
+	// Hits is the total number of points to plot.
+	Hits int64
+	// SPP is the number of samples per pixel to reach, i.e. the ratio of
+	// iterations to histogram bins.
+	SPP float64
+}
+
+// left estimates the number of iterations remaining until a render which has
+// performed iters iterations and plotted hits points onto a histogram with the
+// given number of bins meets the budget. The result is false if the budget is
+// the zero value or the number of iterations to reach it is unknown.
+func (b Budget) left(iters, hits int64, bins int) (int64, bool) {
+	r, ok := int64(math.MaxInt64), false
+	if b.Iters > 0 {
+		r, ok = min(r, b.Iters-iters), true
+	}
+	if b.Hits > 0 && hits > 0 {
+		// Assume the hit ratio so far continues.
+		n := float64(b.Hits-hits) * float64(iters) / float64(hits)
+		r, ok = min(r, int64(n)), true
+	}
+	if b.SPP > 0 {
+		r, ok = min(r, int64(b.SPP*float64(bins))-iters), true
+	}
+	return max(r, 0), ok
+}
+
+// Met returns whether a render which has performed iters iterations and
+// plotted hits points onto a histogram with the given number of bins has
+// reached the budget.
+func (b Budget) Met(iters, hits int64, bins int) bool {
+	if b.Iters > 0 && iters >= b.Iters {
+		return true
+	}
+	if b.Hits > 0 && hits >= b.Hits {
+		return true
+	}
+	if b.SPP > 0 && float64(iters) >= b.SPP*float64(bins) {
+		return true
+	}
+	return false
+}
+
+// Progress describes the state of a render in progress.
+type Progress struct {
+	// Iters is the number of iterations performed.
+	Iters int64
+	// Hits is the number of points plotted.
+	Hits int64
+	// Elapsed is the time spent rendering.
+	Elapsed time.Duration
+	// Rate is the number of iterations per second since the previous report.
+	Rate float64
+	// SPP is the average number of samples per histogram bin.
+	SPP float64
+	// Remaining is the estimated time until the render's budget is met. It is
+	// negative if there is no budget or the time cannot yet be estimated.
+	Remaining time.Duration
+}
+
 // Metadata holds metadata about a fractal.
 type Metadata struct {
 	// Title is the name of the fractal.
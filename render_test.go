@@ -1,9 +1,14 @@
 package xirho_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"fmt"
 	"image"
 	"image/color"
+	"math"
+	"sync"
 	"testing"
 	"time"
 
@@ -110,10 +115,502 @@ func TestRenderAsync(t *testing.T) {
 	if iters == r.Iters() {
 		t.Error("renderer did not continue after", iters, "iters")
 	}
-	// TODO: many other things to test: changing render, pause/resume, coalescing ops, ...
+	// Changing the size also changes the storage layout.
+	sz := hist.Size{W: 2, H: 1, OSA: 1, Storage: hist.Counts32}
+	change <- xirho.ChangeRender{Size: sz, Procs: 4}
+	time.Sleep(150 * time.Millisecond)
+	plot <- xirho.PlotOnto{
+		Image:   image.NewNRGBA64(image.Rect(0, 0, 2, 1)),
+		Scale:   draw.NearestNeighbor,
+		ToneMap: hist.ToneMap{Brightness: 1, Contrast: 1, Gamma: 1},
+	}
+	if _, ok := <-imgs; !ok {
+		cancel()
+		t.Fatal("renderer closed imgs early")
+	}
+	if r.Hist.Size() != sz {
+		t.Errorf("wrong size after change: want %+v, got %+v", sz, r.Hist.Size())
+	}
+	// TODO: many other things to test: pause/resume, coalescing ops, ...
 	cancel()
 	// Make sure the renderer closes channels after the context cancels.
 	for range imgs {
 		// do nothing
 	}
 }
+
+// randf is a function that produces uniformly random points.
+type randf struct{}
+
+func (randf) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	return xirho.Pt{
+		X: rng.Uniform()*2 - 1,
+		Y: rng.Uniform()*2 - 1,
+		Z: 0,
+		C: rng.Uniform(),
+	}
+}
+
+func (randf) Prep() {}
+
+func TestRenderSeeded(t *testing.T) {
+	s := xirho.System{
+		Nodes: []xirho.Node{
+			{Func: randf{}, Opacity: 1, Weight: 1},
+			{Func: randf{}, Opacity: 0.5, Weight: 2},
+		},
+	}
+	palette := color.Palette{
+		color.RGBA64{R: 0xffff, A: 0xffff},
+		color.RGBA64{G: 0xffff, A: 0xffff},
+		color.RGBA64{B: 0xffff, A: 0xffff},
+	}
+	render := func(seed uint64, iters int64) (*xirho.Render, []byte) {
+		r := xirho.Render{
+			Hist:    hist.New(hist.Size{W: 16, H: 16, OSA: 1}),
+			Camera:  xmath.Eye(),
+			Palette: palette,
+		}
+		r.RenderSeeded(context.Background(), s, 3, seed, iters)
+		var b bytes.Buffer
+		if _, err := r.Hist.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		return &r, b.Bytes()
+	}
+	const iters = 100003
+	r1, a := render(1, iters)
+	r2, b := render(1, iters)
+	if r1.Iters() != iters || r2.Iters() != iters {
+		t.Errorf("wrong number of iters: want %d, got %d and %d", iters, r1.Iters(), r2.Iters())
+	}
+	if r1.Hits() != r2.Hits() {
+		t.Errorf("same seed gave different hits: %d and %d", r1.Hits(), r2.Hits())
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("same seed gave different histograms")
+	}
+	_, c := render(2, iters)
+	if bytes.Equal(a, c) {
+		t.Error("different seeds gave the same histogram")
+	}
+}
+
+func TestRenderBudget(t *testing.T) {
+	cases := map[string]xirho.Budget{
+		"iters": {Iters: 100000},
+		"hits":  {Hits: 100000},
+		"spp":   {SPP: 1000},
+	}
+	for name, b := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := xirho.Render{
+				Hist:    hist.New(hist.Size{W: 10, H: 10, OSA: 1}),
+				Camera:  xmath.Eye(),
+				Palette: color.Palette{color.RGBA64{R: 0xffff, A: 0xffff}},
+				Budget:  b,
+			}
+			s := xirho.System{
+				Nodes: []xirho.Node{
+					{Func: randf{}, Opacity: 1, Weight: 1},
+				},
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			r.Render(ctx, s, 2)
+			if ctx.Err() != nil {
+				t.Fatal("render did not stop before timeout")
+			}
+			if !b.Met(r.Iters(), r.Hits(), 100) {
+				t.Errorf("render stopped early at %d iters, %d hits", r.Iters(), r.Hits())
+			}
+		})
+	}
+}
+
+func TestBudgetMet(t *testing.T) {
+	cases := []struct {
+		name  string
+		b     xirho.Budget
+		iters int64
+		hits  int64
+		bins  int
+		want  bool
+	}{
+		{"zero", xirho.Budget{}, 1 << 40, 1 << 40, 1, false},
+		{"itersUnder", xirho.Budget{Iters: 10}, 9, 9, 1, false},
+		{"itersMet", xirho.Budget{Iters: 10}, 10, 0, 1, true},
+		{"hitsUnder", xirho.Budget{Hits: 10}, 100, 9, 1, false},
+		{"hitsMet", xirho.Budget{Hits: 10}, 10, 10, 1, true},
+		{"sppUnder", xirho.Budget{SPP: 2.5}, 24, 24, 10, false},
+		{"sppMet", xirho.Budget{SPP: 2.5}, 25, 0, 10, true},
+		{"any", xirho.Budget{Iters: 1000, Hits: 10}, 100, 10, 1, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.b.Met(c.iters, c.hits, c.bins); got != c.want {
+				t.Errorf("%+v.Met(%d, %d, %d): want %t, got %t", c.b, c.iters, c.hits, c.bins, c.want, got)
+			}
+		})
+	}
+}
+
+func TestRenderCheckpoint(t *testing.T) {
+	s := xirho.System{
+		Nodes: []xirho.Node{
+			{Func: randf{}, Opacity: 1, Weight: 1},
+		},
+	}
+	r := xirho.Render{
+		Hist:    hist.New(hist.Size{W: 8, H: 8, OSA: 2}),
+		Camera:  xmath.Eye(),
+		Palette: color.Palette{color.RGBA64{R: 0xffff, A: 0xffff}},
+	}
+	r.RenderSeeded(context.Background(), s, 2, 1, 50000)
+	var b bytes.Buffer
+	if _, err := r.Checkpoint(&b, []byte("extra")); err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	if _, err := r.Hist.WriteTo(&want); err != nil {
+		t.Fatal(err)
+	}
+	q := xirho.Render{}
+	extra, err := q.Restore(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(extra) != "extra" {
+		t.Errorf("wrong extra data: want %q, got %q", "extra", extra)
+	}
+	if q.Iters() != r.Iters() || q.Hits() != r.Hits() {
+		t.Errorf("wrong counts: want %d iters %d hits, got %d iters %d hits", r.Iters(), r.Hits(), q.Iters(), q.Hits())
+	}
+	if q.Hist.Size() != r.Hist.Size() {
+		t.Errorf("wrong size: want %v, got %v", r.Hist.Size(), q.Hist.Size())
+	}
+	var got bytes.Buffer
+	if _, err := q.Hist.WriteTo(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want.Bytes(), got.Bytes()) {
+		t.Error("restored histogram differs")
+	}
+}
+
+func TestRenderMerge(t *testing.T) {
+	s := xirho.System{
+		Nodes: []xirho.Node{
+			{Func: randf{}, Opacity: 1, Weight: 1},
+		},
+	}
+	render := func(seed uint64) *xirho.Render {
+		r := xirho.Render{
+			Hist:    hist.New(hist.Size{W: 8, H: 8, OSA: 1}),
+			Camera:  xmath.Eye(),
+			Palette: color.Palette{color.RGBA64{R: 0xffff, A: 0xffff}},
+		}
+		r.RenderSeeded(context.Background(), s, 1, seed, 30000)
+		return &r
+	}
+	a, b := render(1), render(2)
+	n, q := a.Iters()+b.Iters(), a.Hits()+b.Hits()
+	var ck bytes.Buffer
+	if _, err := b.Checkpoint(&ck, nil); err != nil {
+		t.Fatal(err)
+	}
+	c := render(1)
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Iters() != n || a.Hits() != q {
+		t.Errorf("wrong counts after merge: want %d iters %d hits, got %d iters %d hits", n, q, a.Iters(), a.Hits())
+	}
+	if _, err := c.MergeCheckpoint(&ck); err != nil {
+		t.Fatal(err)
+	}
+	if c.Iters() != n || c.Hits() != q {
+		t.Errorf("wrong counts after checkpoint merge: want %d iters %d hits, got %d iters %d hits", n, q, c.Iters(), c.Hits())
+	}
+	var x, y bytes.Buffer
+	a.Hist.WriteTo(&x)
+	c.Hist.WriteTo(&y)
+	if !bytes.Equal(x.Bytes(), y.Bytes()) {
+		t.Error("merging render and merging checkpoint gave different histograms")
+	}
+}
+
+func TestRenderProgress(t *testing.T) {
+	var reports []xirho.Progress
+	r := xirho.Render{
+		Hist:             hist.New(hist.Size{W: 10, H: 10, OSA: 1}),
+		Camera:           xmath.Eye(),
+		Palette:          color.Palette{color.RGBA64{R: 0xffff, A: 0xffff}},
+		Budget:           xirho.Budget{Iters: 2000000},
+		OnProgress:       func(p xirho.Progress) { reports = append(reports, p) },
+		ProgressInterval: time.Millisecond,
+	}
+	s := xirho.System{
+		Nodes: []xirho.Node{
+			{Func: randf{}, Opacity: 1, Weight: 1},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r.Render(ctx, s, 2)
+	if len(reports) == 0 {
+		t.Fatal("no progress reports")
+	}
+	last := reports[len(reports)-1]
+	if last.Iters != r.Iters() || last.Hits != r.Hits() {
+		t.Errorf("final report has %d iters %d hits, but render has %d iters %d hits", last.Iters, last.Hits, r.Iters(), r.Hits())
+	}
+	if want := float64(r.Iters()) / 100; last.SPP != want {
+		t.Errorf("wrong final spp: want %g, got %g", want, last.SPP)
+	}
+	for i := 1; i < len(reports); i++ {
+		p, q := reports[i-1], reports[i]
+		if q.Iters < p.Iters || q.Elapsed < p.Elapsed {
+			t.Errorf("report %d went backward: %+v after %+v", i, q, p)
+		}
+		if q.Rate < 0 {
+			t.Errorf("report %d has negative rate %g", i, q.Rate)
+		}
+	}
+	for _, p := range reports {
+		if p.Rate > 0 && p.Remaining < 0 {
+			t.Errorf("report %+v has a rate but no remaining time with a budget", p)
+		}
+	}
+}
+
+// constf is a function that always produces the same point.
+type constf struct {
+	p xirho.Pt
+}
+
+func (f constf) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	return f.p
+}
+
+func (constf) Prep() {}
+
+func TestNodeStats(t *testing.T) {
+	s := xirho.System{
+		Nodes: []xirho.Node{
+			{Func: randf{}, Opacity: 1, Weight: 1},
+			{Func: constf{xirho.Pt{X: 3, Y: 3, C: 0.5}}, Opacity: 1, Weight: 1},
+			{Func: constf{xirho.Pt{X: math.NaN()}}, Opacity: 1, Weight: 1},
+			{Func: randf{}, Opacity: 0, Weight: 1},
+		},
+	}
+	r := xirho.Render{
+		Hist:     hist.New(hist.Size{W: 16, H: 16, OSA: 1}),
+		Camera:   xmath.Eye(),
+		Palette:  color.Palette{color.RGBA64{R: 0xffff, A: 0xffff}},
+		Diagnose: true,
+	}
+	if st := r.NodeStats(); st != nil {
+		t.Errorf("stats before rendering: %+v", st)
+	}
+	r.RenderSeeded(context.Background(), s, 2, 1, 100000)
+	st := r.NodeStats()
+	if len(st) != len(s.Nodes) {
+		t.Fatalf("wrong number of node stats: want %d, got %d", len(s.Nodes), len(st))
+	}
+	var sel, plot int64
+	for i, n := range st {
+		if n.Selected == 0 {
+			t.Errorf("node %d never selected: %+v", i, n)
+		}
+		sel += n.Selected
+		plot += n.Plotted
+	}
+	if sel != r.Iters() {
+		t.Errorf("selections don't match iters: %d selections, %d iters", sel, r.Iters())
+	}
+	if plot != r.Hits() {
+		t.Errorf("plots don't match hits: %d plots, %d hits", plot, r.Hits())
+	}
+	if n := st[0]; n.Plotted != n.Selected || n.Invalid != 0 || n.Outside != 0 {
+		t.Errorf("wrong stats for visible node: %+v", n)
+	}
+	if n := st[1]; n.Outside != n.Selected || n.Invalid != 0 || n.Plotted != 0 {
+		t.Errorf("wrong stats for outside node: %+v", n)
+	}
+	if n := st[2]; n.Invalid != n.Selected || n.Outside != 0 || n.Plotted != 0 {
+		t.Errorf("wrong stats for invalid node: %+v", n)
+	}
+	if n := st[3]; n.Invalid != 0 || n.Outside != 0 || n.Plotted != 0 {
+		t.Errorf("wrong stats for transparent node: %+v", n)
+	}
+	r.ResetCounts()
+	if st := r.NodeStats(); st != nil {
+		t.Errorf("stats after reset: %+v", st)
+	}
+}
+
+func TestRenderMotion(t *testing.T) {
+	s := xirho.System{
+		Nodes: []xirho.Node{
+			{Func: constf{xirho.Pt{C: 0.5}}, Opacity: 1, Weight: 1},
+		},
+	}
+	var ts []float64
+	var mu sync.Mutex
+	// Sweep the camera across the image so that the single point of the
+	// system draws a line.
+	motion := func(t float64) (xirho.System, xmath.Affine) {
+		mu.Lock()
+		ts = append(ts, t)
+		mu.Unlock()
+		cam := xmath.Eye()
+		cam.Translate(1.8*t-0.9, 0, 0)
+		return s, cam
+	}
+	r := xirho.Render{
+		Hist:    hist.New(hist.Size{W: 8, H: 1, OSA: 1}),
+		Camera:  xmath.Eye(),
+		Palette: color.Palette{color.RGBA64{R: 0xffff, A: 0xffff}},
+		Budget:  xirho.Budget{Iters: 1000000},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r.RenderMotion(ctx, motion, 2)
+	if ctx.Err() != nil {
+		t.Fatal("render did not stop before timeout")
+	}
+	if r.Iters() < 1000000 {
+		t.Errorf("render stopped before budget: %d iters", r.Iters())
+	}
+	if r.Hits() != r.Iters() {
+		t.Errorf("all points should be plotted, but got %d iters and %d hits", r.Iters(), r.Hits())
+	}
+	if len(ts) < 2 {
+		t.Fatalf("too few trajectories: %d", len(ts))
+	}
+	for _, t0 := range ts {
+		if t0 < 0 || t0 >= 1 {
+			t.Errorf("time %v out of range", t0)
+		}
+	}
+	tm := hist.ToneMap{Brightness: 1e6, Contrast: 1, Gamma: 1}
+	img := r.Hist.Image(tm, r.Area(), r.Iters())
+	for x := 0; x < 8; x++ {
+		if _, _, _, a := img.At(x, 0).RGBA(); a == 0 {
+			t.Errorf("no motion blur plotted in column %d", x)
+		}
+	}
+}
+
+func TestRenderPlotBuffer(t *testing.T) {
+	s := xirho.System{
+		Nodes: []xirho.Node{
+			{Func: randf{}, Opacity: 1, Weight: 1},
+			{Func: constf{xirho.Pt{X: 0.1, Y: 0.2, C: 0.5}}, Opacity: 1, Weight: 3},
+		},
+	}
+	palette := color.Palette{
+		color.RGBA64{R: 0xffff, A: 0xffff},
+		color.RGBA64{G: 0xffff, B: 0x8000, A: 0xffff},
+	}
+	render := func(buf int) []byte {
+		r := xirho.Render{
+			Hist:       hist.New(hist.Size{W: 20, H: 12, OSA: 2}),
+			Camera:     xmath.Eye(),
+			Palette:    palette,
+			PlotBuffer: buf,
+		}
+		r.RenderSeeded(context.Background(), s, 3, 1, 100003)
+		var b bytes.Buffer
+		if _, err := r.Hist.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	want := render(0)
+	for _, buf := range []int{1, 7, 1024} {
+		if got := render(buf); !bytes.Equal(want, got) {
+			t.Errorf("plot buffer of %d gave different histogram", buf)
+		}
+	}
+}
+
+func TestRenderPlotBufferStop(t *testing.T) {
+	// Every buffered point must reach the histogram when workers stop early.
+	r := xirho.Render{
+		Hist:       hist.New(hist.Size{W: 4, H: 4, OSA: 1}),
+		Camera:     xmath.Eye(),
+		Palette:    color.Palette{color.RGBA64{R: 0xffff, A: 0xffff}},
+		PlotBuffer: 4096,
+	}
+	s := xirho.System{Nodes: []xirho.Node{{Func: randf{}, Opacity: 1, Weight: 1}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r.Render(ctx, s, 4)
+	var b bytes.Buffer
+	if _, err := r.Hist.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	// The alpha counts are the last quarter of the bins.
+	p := b.Bytes()[16:]
+	var n uint64
+	for p = p[len(p)*3/4:]; len(p) > 0; p = p[8:] {
+		n += binary.LittleEndian.Uint64(p)
+	}
+	if want := uint64(r.Hits()) * 0xffff; n != want {
+		t.Errorf("wrong total count after stopping: want %d, got %d", want, n)
+	}
+}
+
+// halff moves points halfway toward a corner, producing a Sierpinski gasket.
+type halff struct {
+	x, y float64
+}
+
+func (f halff) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	return xirho.Pt{X: (in.X + f.x) / 2, Y: (in.Y + f.y) / 2, C: in.C}
+}
+
+func (halff) Prep() {}
+
+func BenchmarkRender(b *testing.B) {
+	systems := []struct {
+		name string
+		s    xirho.System
+	}{
+		{
+			name: "sierpinski",
+			s: xirho.System{
+				Nodes: []xirho.Node{
+					{Func: halff{-1, -1}, Opacity: 1, Weight: 1},
+					{Func: halff{1, -1}, Opacity: 1, Weight: 1},
+					{Func: halff{0, 1}, Opacity: 1, Weight: 1},
+				},
+			},
+		},
+		{
+			// Every point lands in the same bin, the worst case for
+			// contention.
+			name: "point",
+			s: xirho.System{
+				Nodes: []xirho.Node{{Func: constf{xirho.Pt{C: 0.5}}, Opacity: 1, Weight: 1}},
+			},
+		},
+	}
+	for _, sys := range systems {
+		for _, buf := range []int{0, 256, 4096} {
+			b.Run(fmt.Sprintf("%s/buf=%d", sys.name, buf), func(b *testing.B) {
+				r := xirho.Render{
+					Hist:       hist.New(hist.Size{W: 1024, H: 1024, OSA: 1}),
+					Camera:     xmath.Eye(),
+					Palette:    color.Palette{color.RGBA64{R: 0xffff, G: 0x8000, A: 0xffff}},
+					PlotBuffer: buf,
+				}
+				b.ResetTimer()
+				r.RenderSeeded(context.Background(), sys.s, 0, 1, int64(b.N))
+				b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "iters/s")
+			})
+		}
+	}
+}
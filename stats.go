@@ -0,0 +1,78 @@
+package xirho
+
+import "sync/atomic"
+
+// NodeStats holds diagnostic counts for a single node in a system.
+type NodeStats struct {
+	// Selected is the number of times the node was chosen to transform the
+	// current point.
+	Selected int64
+	// Invalid is the number of times the node's output, or the result of
+	// applying the system's final to it, was not a valid point, i.e. had a
+	// non-finite spatial coordinate or a color coordinate outside [0, 1].
+	Invalid int64
+	// Plotted is the number of points from the node plotted onto the
+	// histogram.
+	Plotted int64
+	// Outside is the number of points from the node which were valid but
+	// landed outside the camera's view.
+	Outside int64
+}
+
+// nodeStats is the shared accumulator for NodeStats.
+type nodeStats struct {
+	selected, invalid, plotted, outside atomic.Int64
+}
+
+// add adds counts into the accumulator.
+func (s *nodeStats) add(t *NodeStats) {
+	s.selected.Add(t.Selected)
+	s.invalid.Add(t.Invalid)
+	s.plotted.Add(t.Plotted)
+	s.outside.Add(t.Outside)
+}
+
+// load gets the current counts.
+func (s *nodeStats) load() NodeStats {
+	return NodeStats{
+		Selected: s.selected.Load(),
+		Invalid:  s.invalid.Load(),
+		Plotted:  s.plotted.Load(),
+		Outside:  s.outside.Load(),
+	}
+}
+
+// NodeStats returns the per-node statistics collected while the renderer's
+// Diagnose field is set, indexed by node. The result is nil if no statistics
+// have been collected since the last reset. It is safe to call this while the
+// renderer is running.
+func (r *Render) NodeStats() []NodeStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stats == nil {
+		return nil
+	}
+	s := make([]NodeStats, len(r.stats))
+	for i := range r.stats {
+		s[i] = r.stats[i].load()
+	}
+	return s
+}
+
+// nodeStats gets the accumulators for a system with n nodes, allocating new
+// ones if the current ones are for a different number of nodes.
+func (r *Render) nodeStats(n int) []nodeStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.stats) != n {
+		r.stats = make([]nodeStats, n)
+	}
+	return r.stats
+}
+
+// resetStats discards collected statistics.
+func (r *Render) resetStats() {
+	r.mu.Lock()
+	r.stats = nil
+	r.mu.Unlock()
+}
@@ -98,28 +98,113 @@ func (s System) Prep() {
 }
 
 // Iter iterates the function system and plots points onto r. It continues
-// iterating until the context's Done channel is closed. rng should be seeded
+// iterating until the context's Done channel is closed or the renderer's
+// Budget is met, whichever happens first. rng should be seeded
 // to a distinct state for each call to this method. Iter panics if Check
 // returns an error.
 func (s System) Iter(ctx context.Context, r *Render, rng xmath.RNG) {
+	s.iter(ctx, r, &rng, &r.Camera, -1, true)
+}
+
+// iter iterates the function system and plots points onto r through cam. If
+// lim is non-negative, then iter returns after exactly lim iterations, unless
+// the context closes first. If budget is true, then it also returns once the
+// renderer's budget is met. The result is true if iter returned because it
+// completed lim iterations. The sequence of points plotted depends only on the
+// system, the renderer's settings, cam, rng, and lim. rng is updated to the
+// iterator's final state so that the caller may continue using it.
+func (s System) iter(ctx context.Context, r *Render, rng *xmath.RNG, cam *xmath.Affine, lim int64, budget bool) bool {
 	if err := s.Check(); err != nil {
 		panic(err)
 	}
-	it := iterator{rng: rng}
+	it := iterator{rng: *rng}
+	defer func() { *rng = it.rng }()
 	it.prep(s, r.Palette)
-	aspect := r.Hist.Aspect()
+	v := r.view()
+	bins := r.Hist.Cols() * r.Hist.Rows()
+	budget = budget && r.Budget != (Budget{})
+	if budget && r.Budget.Met(r.n.Load(), r.q.Load(), bins) {
+		return false
+	}
+	var st []NodeStats
+	var acc []nodeStats
+	if r.Diagnose {
+		st = make([]NodeStats, it.n)
+		acc = r.nodeStats(it.n)
+	}
+	var buf *plotBuffer
+	if r.PlotBuffer > 0 {
+		buf = newPlotBuffer(r.Hist, r.PlotBuffer)
+		defer buf.flush()
+	}
 	p, k := it.fuse() // p may not be valid!
 	done := ctx.Done()
 	var n, q int
+	// t is the total number of points this iterator has plotted. It is used
+	// to decide when to re-fuse; using our own count rather than the
+	// renderer's keeps the decision independent of other goroutines.
+	var t int64
+	batch := 25000
+	if lim >= 0 && lim < int64(batch) {
+		batch = int(lim)
+	}
 	for {
+		if n >= batch {
+			if buf != nil {
+				buf.flush()
+			}
+			tn := r.n.Add(int64(n))
+			tq := r.q.Add(int64(q))
+			for i := range st {
+				acc[i].add(&st[i])
+				st[i] = NodeStats{}
+			}
+			if budget && r.Budget.Met(tn, tq, bins) {
+				return false
+			}
+			t += int64(q)
+			if lim >= 0 {
+				lim -= int64(n)
+				if lim < int64(batch) {
+					batch = int(lim)
+				}
+			}
+			if batch == 0 {
+				return true
+			}
+			n, q = 0, 0
+			// Some random-ish condition that's fast to check to decide
+			// whether to re-fuse. 0x8 is the lowest bit set in 25000, so
+			// this will be every other group if the hit ratio is 1.0.
+			if t&0x8 == 0 {
+				p, k = it.fuse()
+			}
+			select {
+			case <-done:
+				return false
+			default:
+				// continue on
+			}
+		}
 		p = it.nodeat(k).Calc(p, &it.rng)
 		n++
+		bad := false
+		if st != nil {
+			st[k].Selected++
+			if !p.IsValid() {
+				st[k].Invalid++
+				bad = true
+			}
+		}
 		// If a function has opacity α, that means we plot its points with
 		// probability α. If we don't plot a point, then there's no reason
 		// to apply the final, since that is only a nonlinear camera.
 		if op := it.opat(k); op >= 1<<53 || (op > 0 && it.rng.Uint64()%(1<<53) < op) {
 			fp := it.doFinal(p)
 			if !fp.IsValid() {
+				if st != nil && !bad {
+					st[k].Invalid++
+				}
 				p, k = it.fuse()
 				continue
 			}
@@ -128,28 +213,16 @@ func (s System) Iter(ctx context.Context, r *Render, rng xmath.RNG) {
 				// Since fp.C can be 1.0, i can be out of bounds.
 				i = it.nclrs - 1
 			}
-			if r.plot(fp.X, fp.Y, fp.Z, it.colorat(i), aspect) {
+			if r.plot(buf, cam, &v, fp.X, fp.Y, fp.Z, it.colorat(i), &it.rng) {
 				q++
+				if st != nil {
+					st[k].Plotted++
+				}
+			} else if st != nil {
+				st[k].Outside++
 			}
 		}
 		k = it.next(k)
-		if n == 25000 {
-			r.n.Add(int64(n))
-			t := r.q.Add(int64(q))
-			n, q = 0, 0
-			// Some random-ish condition that's fast to check to decide
-			// whether to re-fuse. 0x8 is the lowest bit set in 25000, so
-			// this will be every other group if the hit ratio is 1.0.
-			if t&0x8 == 0 {
-				p, k = it.fuse()
-			}
-			select {
-			case <-done:
-				return
-			default:
-				// continue on
-			}
-		}
 	}
 }
 
@@ -0,0 +1,85 @@
+package xirho
+
+import (
+	"image"
+
+	"github.com/zephyrtronium/xirho/hist"
+)
+
+// Tiling divides a render into tiles which can be rendered separately, so
+// that the output can be larger than a single histogram could fit in memory.
+// Each tile is rendered with its own histogram, which plots only the points
+// falling within its part of the full view.
+//
+// Bins in each tile's histogram cover the same area as the corresponding bins
+// of a histogram for the full render would, so each tile's Area method gives
+// the same normalization as the full render. Hence tiles tone map
+// consistently when each is given the same number of iterations. Tiles can
+// also be expanded by a margin of pixels shared with their neighbors, so that
+// density filtering and resampling near the edges of tiles match the full
+// render; the margin is cropped when assembling the output.
+type Tiling struct {
+	// Size is the size of the full render.
+	Size hist.Size
+	// Tile is the size in pixels of each tile, excluding margins. Tiles on
+	// the right and bottom edges may be smaller.
+	Tile image.Point
+	// Margin is the number of pixels to expand each tile on each side. The
+	// margin is clipped to the bounds of the full render.
+	Margin int
+}
+
+// Tiles returns the bounds of each tile within the full render in pixels,
+// excluding margins, in row-major order. The result is empty if the tile size
+// is not positive.
+func (t Tiling) Tiles() []image.Rectangle {
+	if t.Tile.X <= 0 || t.Tile.Y <= 0 {
+		return nil
+	}
+	var r []image.Rectangle
+	full := image.Rect(0, 0, t.Size.W, t.Size.H)
+	for y := 0; y < t.Size.H; y += t.Tile.Y {
+		for x := 0; x < t.Size.W; x += t.Tile.X {
+			r = append(r, image.Rect(x, y, x+t.Tile.X, y+t.Tile.Y).Intersect(full))
+		}
+	}
+	return r
+}
+
+// Outer returns the bounds of a tile in pixels including its margin.
+func (t Tiling) Outer(tile image.Rectangle) image.Rectangle {
+	return tile.Inset(-t.Margin).Intersect(image.Rect(0, 0, t.Size.W, t.Size.H))
+}
+
+// Render creates a renderer for a tile, including its margin, from the
+// renderer for the full render. The full renderer need not have a histogram.
+// The tile's budget has the same number of iterations as the full render's,
+// converting samples per bin according to the full size. A budget of hits is
+// not converted and applies to each tile separately, so it does not bound a
+// tile which the system never reaches. The tile also uses the full renderer's
+// camera, projection, depth, and PlotBuffer. Points plot onto the same bins
+// relative to the full output as in the full render, so a tile rendered with
+// the same seed and iterations has the same counts as the corresponding part
+// of the full render.
+func (t Tiling) Render(r *Render, tile image.Rectangle) *Render {
+	o := t.Outer(tile)
+	b := r.Budget
+	if b.SPP > 0 {
+		n := int64(b.SPP * float64(t.Size.Bins()))
+		if b.Iters <= 0 || n < b.Iters {
+			b.Iters = n
+		}
+		b.SPP = 0
+	}
+	osa := t.Size.OSA
+	return &Render{
+		Hist:       hist.New(hist.Size{W: o.Dx(), H: o.Dy(), OSA: osa, Storage: t.Size.Storage}),
+		Camera:     r.Camera,
+		Palette:    r.Palette,
+		Depth:      r.Depth,
+		Projection: r.Projection,
+		Budget:     b,
+		PlotBuffer: r.PlotBuffer,
+		frame:      &frame{cols: t.Size.W * osa, rows: t.Size.H * osa, x: o.Min.X * osa, y: o.Min.Y * osa},
+	}
+}
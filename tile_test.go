@@ -0,0 +1,145 @@
+package xirho_test
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/hist"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+func TestTilingTiles(t *testing.T) {
+	tl := xirho.Tiling{Size: hist.Size{W: 25, H: 10, OSA: 1}, Tile: image.Pt(8, 4), Margin: 3}
+	tiles := tl.Tiles()
+	if len(tiles) != 12 {
+		t.Fatalf("wrong number of tiles: want 12, got %d", len(tiles))
+	}
+	cover := make([]int, 25*10)
+	for _, r := range tiles {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				cover[y*25+x]++
+			}
+		}
+		o := tl.Outer(r)
+		if !r.In(o) || !o.In(image.Rect(0, 0, 25, 10)) {
+			t.Errorf("bad outer bounds %v for tile %v", o, r)
+		}
+	}
+	for i, n := range cover {
+		if n != 1 {
+			t.Errorf("pixel %d,%d covered %d times", i%25, i/25, n)
+		}
+	}
+}
+
+func TestTilingRender(t *testing.T) {
+	palette := color.Palette{color.RGBA64{R: 0xffff, G: 0x8000, A: 0xffff}}
+	tm := hist.ToneMap{Brightness: 1, Contrast: 1, Gamma: 2}
+	rng := xmath.NewRNG()
+	for _, sz := range []hist.Size{{W: 30, H: 20, OSA: 2}, {W: 14, H: 23, OSA: 1}} {
+		cam := xmath.Eye()
+		cam.RotZ(0.3).Scale(1.2, 0.9, 1).Translate(0.1, -0.2, 0)
+		tl := xirho.Tiling{Size: sz, Tile: image.Pt(8, 7), Margin: 2}
+		for i := 0; i < 20; i++ {
+			// Plotting a single point gives identical counts in the full
+			// render and in each tile containing it.
+			s := xirho.System{
+				Nodes: []xirho.Node{
+					{Func: constf{xirho.Pt{X: rng.Uniform()*2 - 1, Y: rng.Uniform()*2 - 1, C: 0.5}}, Opacity: 1, Weight: 1},
+				},
+			}
+			const iters = 1000
+			full := &xirho.Render{Hist: hist.New(sz), Camera: cam, Palette: palette}
+			full.RenderSeeded(context.Background(), s, 1, 1, iters)
+			img := full.Hist.Image(tm, full.Area(), iters)
+			for _, tile := range tl.Tiles() {
+				r := tl.Render(full, tile)
+				r.RenderSeeded(context.Background(), s, 1, 1, iters)
+				o := tl.Outer(tile)
+				want := full.Area() * float64(o.Dx()*o.Dy()) / float64(sz.W*sz.H)
+				if a := r.Area(); math.Abs(a-want) > 1e-9*want {
+					t.Errorf("%v tile %v: wrong area: want %g, got %g", sz, tile, want, a)
+				}
+				ti := r.Hist.Image(tm, r.Area(), iters)
+				b := ti.Bounds()
+				for y := b.Min.Y; y < b.Max.Y; y++ {
+					for x := b.Min.X; x < b.Max.X; x++ {
+						p := ti.At(x, y)
+						q := img.At(x+o.Min.X*sz.OSA, y+o.Min.Y*sz.OSA)
+						if p != q {
+							t.Errorf("%v tile %v: wrong color at %d,%d: want %v, got %v", sz, tile, x, y, q, p)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTilingProjection(t *testing.T) {
+	palette := color.Palette{color.RGBA64{R: 0xffff, G: 0x8000, A: 0xffff}}
+	tm := hist.ToneMap{Brightness: 1, Contrast: 1, Gamma: 2}
+	rng := xmath.NewRNG()
+	sz := hist.Size{W: 30, H: 20, OSA: 1}
+	cam := xmath.Eye()
+	cam.RotX(0.3).Translate(0.1, -0.2, -0.5)
+	tl := xirho.Tiling{Size: sz, Tile: image.Pt(8, 7), Margin: 2}
+	cases := []struct {
+		name  string
+		proj  xirho.Projection
+		depth xirho.Depth
+	}{
+		{"dof", xirho.Projection{}, xirho.Depth{Focus: 0.2, Aperture: 0.1, Fog: 1}},
+		{"perspective", xirho.Projection{FOV: 1}, xirho.Depth{}},
+		{"fisheye", xirho.Projection{Lens: xirho.Fisheye}, xirho.Depth{}},
+		{"equirect", xirho.Projection{Lens: xirho.Equirectangular, Stereo: 0.1}, xirho.Depth{}},
+		{"stereo", xirho.Projection{FOV: 1, Stereo: 0.2}, xirho.Depth{Aperture: 0.05}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var hits int64
+			for i := 0; i < 10; i++ {
+				// Plotting a single point gives identical counts in the
+				// full render and in each tile containing it, even with
+				// depth of field, since the tiles draw the same sequence of
+				// random numbers.
+				s := xirho.System{
+					Nodes: []xirho.Node{
+						{Func: constf{xirho.Pt{X: rng.Uniform()*2 - 1, Y: rng.Uniform()*2 - 1, Z: rng.Uniform() - 0.5, C: 0.5}}, Opacity: 1, Weight: 1},
+					},
+				}
+				const iters = 1000
+				full := &xirho.Render{Hist: hist.New(sz), Camera: cam, Palette: palette, Projection: c.proj, Depth: c.depth}
+				full.RenderSeeded(context.Background(), s, 1, 1, iters)
+				hits += full.Hits()
+				img := full.Hist.Image(tm, full.Area(), iters)
+				for _, tile := range tl.Tiles() {
+					r := tl.Render(full, tile)
+					r.RenderSeeded(context.Background(), s, 1, 1, iters)
+					o := tl.Outer(tile)
+					want := full.Area() * float64(o.Dx()*o.Dy()) / float64(sz.W*sz.H)
+					if a := r.Area(); math.Abs(a-want) > 1e-9*want {
+						t.Errorf("tile %v: wrong area: want %g, got %g", tile, want, a)
+					}
+					ti := r.Hist.Image(tm, r.Area(), iters)
+					b := ti.Bounds()
+					for y := b.Min.Y; y < b.Max.Y; y++ {
+						for x := b.Min.X; x < b.Max.X; x++ {
+							if p, q := ti.At(x, y), img.At(x+o.Min.X, y+o.Min.Y); p != q {
+								t.Errorf("tile %v: wrong color at %d,%d: want %v, got %v", tile, x, y, q, p)
+							}
+						}
+					}
+				}
+			}
+			if hits == 0 {
+				t.Error("no points plotted")
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package xi
+
+import (
+	"math"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// Expr computes each output coordinate from a user-supplied expression.
+//
+// Expressions use complex arithmetic with +, -, *, /, and ^ for powers, and
+// parentheses for grouping. They may refer to the input coordinates x, y, z,
+// and c; to w, which is x + y*i; to the parameters p1 through p4; to the
+// constants pi, e, and i; and to variables assigned in Defs. Numbers may have
+// a trailing i to make them imaginary, as in 2.5i. The available functions
+// are sin, cos, tan, asin, acos, atan, sinh, cosh, tanh, exp, log, sqrt,
+// conj, abs, arg, re, im, floor, and ceil of one argument; atan2, hypot,
+// mod, min, and max of two arguments, which use only real parts; and rand and
+// normal of none, which draw uniform variates in [0, 1) and standard normal
+// variates, respectively.
+//
+// Defs is a list of assignments of the form name = expression, separated by
+// semicolons or newlines, which are evaluated in order before the coordinate
+// expressions. Each coordinate takes the real part of its expression; if an
+// expression is empty, its coordinate is unchanged. Every expression sees the
+// input coordinates, not the outputs of the others. The color coordinate is
+// clamped to [0, 1].
+//
+// The expressions are compiled in Prep. If they fail to compile, Err reports
+// why, and points are discarded. Package encoding reports such errors when
+// decoding systems.
+type Expr struct {
+	Defs string  `xirho:"defs,4096"`
+	X    string  `xirho:"x,1024"`
+	Y    string  `xirho:"y,1024"`
+	Z    string  `xirho:"z,1024"`
+	C    string  `xirho:"c,1024"`
+	P1   float64 `xirho:"p1"`
+	P2   float64 `xirho:"p2"`
+	P3   float64 `xirho:"p3"`
+	P4   float64 `xirho:"p4"`
+
+	prog *exprProgram
+	err  error
+}
+
+func (f *Expr) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	if f.prog == nil {
+		in.X = math.NaN()
+		return in
+	}
+	var s [exprSlots]complex128
+	s[slotX], s[slotY], s[slotZ], s[slotC] = complex(in.X, 0), complex(in.Y, 0), complex(in.Z, 0), complex(in.C, 0)
+	s[slotW] = complex(in.X, in.Y)
+	s[slotP1], s[slotP2], s[slotP3], s[slotP4] = complex(f.P1, 0), complex(f.P2, 0), complex(f.P3, 0), complex(f.P4, 0)
+	s[slotOutX], s[slotOutY], s[slotOutZ], s[slotOutC] = s[slotX], s[slotY], s[slotZ], s[slotC]
+	f.prog.run(&s, rng)
+	in.X, in.Y, in.Z = real(s[slotOutX]), real(s[slotOutY]), real(s[slotOutZ])
+	in.C = math.Min(math.Max(real(s[slotOutC]), 0), 1)
+	return in
+}
+
+func (f *Expr) Prep() {
+	f.prog, f.err = compileExpr(f.Defs, [4]string{f.X, f.Y, f.Z, f.C})
+}
+
+// Err returns the error from compiling the expressions in the last call to
+// Prep, or nil if they compiled successfully.
+func (f *Expr) Err() error {
+	return f.err
+}
+
+func init() {
+	must("expr", func() xirho.Func { return &Expr{} })
+}
@@ -0,0 +1,157 @@
+package xi_test
+
+import (
+	"math"
+	"math/cmplx"
+	"strings"
+	"testing"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/fapi"
+	"github.com/zephyrtronium/xirho/xi"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+func TestExprAPI(t *testing.T) {
+	expect := map[string]fapi.Param{
+		"defs": fapi.String{},
+		"x":    fapi.String{},
+		"y":    fapi.String{},
+		"z":    fapi.String{},
+		"c":    fapi.String{},
+		"p1":   fapi.Real{},
+		"p2":   fapi.Real{},
+		"p3":   fapi.Real{},
+		"p4":   fapi.Real{},
+	}
+	ExpectAPI(t, expect, "expr")
+}
+
+func TestExprCalc(t *testing.T) {
+	in := xirho.Pt{X: 0.5, Y: -2, Z: 3, C: 0.25}
+	w := complex(in.X, in.Y)
+	cases := []struct {
+		name string
+		f    xi.Expr
+		want xirho.Pt
+	}{
+		{"identity", xi.Expr{}, in},
+		{"arith", xi.Expr{X: "1 + 2*3 - 4/2", Y: "-x^2", Z: "2^3^2", C: "(c + 1) / 2"}, xirho.Pt{X: 5, Y: -0.25, Z: 512, C: 0.625}},
+		{"swap", xi.Expr{X: "y", Y: "x"}, xirho.Pt{X: -2, Y: 0.5, Z: 3, C: 0.25}},
+		{"complex", xi.Expr{X: "re(w^2)", Y: "im(w*w)", Z: "abs(3 + 4i)"}, xirho.Pt{X: real(w * w), Y: imag(w * w), Z: 5, C: 0.25}},
+		{"params", xi.Expr{X: "p1*x + p2", Y: "p3 - p4", P1: 2, P2: 1, P3: 4, P4: 6}, xirho.Pt{X: 2, Y: -2, Z: 3, C: 0.25}},
+		{"defs", xi.Expr{Defs: "t = sqrt(w); u = t*t\nt = t + 1", X: "re(u)", Y: "im(u)", Z: "re(t)"}, xirho.Pt{X: 0.5, Y: -2, Z: real(cmplx.Sqrt(w)) + 1, C: 0.25}},
+		{"funcs", xi.Expr{X: "atan2(y, x)", Y: "hypot(3, 4)", Z: "mod(7, 4) + min(1, 2) + max(1, 2) + floor(2.5) + ceil(0.5)"}, xirho.Pt{X: math.Atan2(-2, 0.5), Y: 5, Z: 9, C: 0.25}},
+		{"trig", xi.Expr{X: "sin(pi/2)", Y: "cos(0) + exp(0) + log(e)", Z: "arg(i)"}, xirho.Pt{X: 1, Y: 3, Z: math.Pi / 2, C: 0.25}},
+		{"scientific", xi.Expr{X: "1.5e2", Y: ".5E-1", Z: "re(2i*2i)"}, xirho.Pt{X: 150, Y: 0.05, Z: -4, C: 0.25}},
+		{"clamp", xi.Expr{C: "c + 10"}, xirho.Pt{X: 0.5, Y: -2, Z: 3, C: 1}},
+	}
+	rng := xmath.NewRNG()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := c.f
+			f.Prep()
+			if err := f.Err(); err != nil {
+				t.Fatal(err)
+			}
+			p := f.Calc(in, &rng)
+			if math.Abs(p.X-c.want.X) > 1e-12 || math.Abs(p.Y-c.want.Y) > 1e-12 || math.Abs(p.Z-c.want.Z) > 1e-12 || math.Abs(p.C-c.want.C) > 1e-12 {
+				t.Errorf("wrong result: want %v, got %v", c.want, p)
+			}
+		})
+	}
+}
+
+func TestExprRandom(t *testing.T) {
+	f := xi.Expr{X: "rand()", Y: "normal()"}
+	f.Prep()
+	if err := f.Err(); err != nil {
+		t.Fatal(err)
+	}
+	rng := xmath.NewRNG()
+	var sx, sy float64
+	const n = 10000
+	for i := 0; i < n; i++ {
+		p := f.Calc(xirho.Pt{}, &rng)
+		if p.X < 0 || p.X >= 1 {
+			t.Fatalf("uniform variate %g out of range", p.X)
+		}
+		sx += p.X
+		sy += p.Y
+	}
+	if m := sx / n; math.Abs(m-0.5) > 0.05 {
+		t.Errorf("wrong uniform mean: want 0.5, got %g", m)
+	}
+	if m := sy / n; math.Abs(m) > 0.05 {
+		t.Errorf("wrong normal mean: want 0, got %g", m)
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	cases := map[string]xi.Expr{
+		"undefined":   {X: "q"},
+		"self":        {Defs: "t = t + 1"},
+		"assignInput": {Defs: "x = 1"},
+		"assignConst": {Defs: "pi = 3"},
+		"noEquals":    {Defs: "t 1"},
+		"trailing":    {Defs: "t = 1 2"},
+		"unbalanced":  {X: "(x + 1"},
+		"extraParen":  {X: "x + 1)"},
+		"empty":       {X: "x +"},
+		"badNumber":   {X: "1.2.3"},
+		"badExponent": {Y: "2e"},
+		"badChar":     {Z: "x $ y"},
+		"unknownFunc": {X: "frob(x)"},
+		"arity":       {X: "sin(x, y)"},
+		"assignInX":   {X: "t = 1"},
+		"sepInX":      {X: "x; y"},
+		"deep":        {C: strings.Repeat("(1+", 40) + "1" + strings.Repeat(")", 40)},
+	}
+	rng := xmath.NewRNG()
+	for name, f := range cases {
+		t.Run(name, func(t *testing.T) {
+			f.Prep()
+			if f.Err() == nil {
+				t.Fatal("no error")
+			}
+			if p := f.Calc(xirho.Pt{}, &rng); p.IsValid() {
+				t.Errorf("invalid expression gave valid point %v", p)
+			}
+		})
+	}
+}
+
+func TestExprLimits(t *testing.T) {
+	// Enough variables to exhaust the slots.
+	var defs strings.Builder
+	for i := 0; i < 100; i++ {
+		defs.WriteString("v")
+		defs.WriteString(strings.Repeat("a", i+1))
+		defs.WriteString(" = 1\n")
+	}
+	f := xi.Expr{Defs: defs.String()}
+	f.Prep()
+	if f.Err() == nil {
+		t.Error("no error with too many variables")
+	}
+	// Long expressions are rejected by the parameter.
+	g := xi.New("expr")
+	for _, p := range fapi.For(g) {
+		if p, ok := p.(fapi.String); ok && p.Set(strings.Repeat("x+", 3000)+"x") == nil {
+			t.Errorf("%s accepted an overly long expression", p.Name())
+		}
+	}
+}
+
+func TestExprAllocs(t *testing.T) {
+	f := xi.Expr{Defs: "t = w^2 + p1*i", X: "re(t)", Y: "im(t) + rand()", Z: "abs(sin(t))", C: "c/2", P1: 1}
+	f.Prep()
+	if err := f.Err(); err != nil {
+		t.Fatal(err)
+	}
+	rng := xmath.NewRNG()
+	in := xirho.Pt{X: 0.3, Y: -0.7, Z: 0.1, C: 0.5}
+	if n := testing.AllocsPerRun(100, func() { f.Calc(in, &rng) }); n != 0 {
+		t.Errorf("Calc allocates %v times", n)
+	}
+}
@@ -0,0 +1,589 @@
+package xi
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// Limits on compiled expressions. Since expressions have no loops, these
+// together with the lengths of the source strings bound evaluation time.
+const (
+	// exprSlots is the number of variable slots, including inputs,
+	// parameters, and outputs.
+	exprSlots = 64
+	// exprStack is the maximum depth of the evaluation stack.
+	exprStack = 32
+	// exprConsts is the maximum number of distinct constants.
+	exprConsts = 256
+)
+
+// Variable slots with fixed meanings. User variables follow them.
+const (
+	slotX = iota
+	slotY
+	slotZ
+	slotC
+	slotW
+	slotP1
+	slotP2
+	slotP3
+	slotP4
+	slotOutX
+	slotOutY
+	slotOutZ
+	slotOutC
+	slotUser
+)
+
+// exprNames maps the names of inputs and parameters to their slots.
+var exprNames = map[string]uint8{
+	"x":  slotX,
+	"y":  slotY,
+	"z":  slotZ,
+	"c":  slotC,
+	"w":  slotW,
+	"p1": slotP1,
+	"p2": slotP2,
+	"p3": slotP3,
+	"p4": slotP4,
+}
+
+// exprConstNames maps named constants to their values.
+var exprConstNames = map[string]complex128{
+	"pi": math.Pi,
+	"e":  math.E,
+	"i":  1i,
+}
+
+// exprOp is an instruction of a compiled expression.
+type exprOp uint8
+
+const (
+	opConst exprOp = iota // push consts[arg]
+	opLoad                // push slots[arg]
+	opStore               // pop into slots[arg]
+	opNeg
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opPow
+	opFunc0 // push exprFuncs0[arg]
+	opFunc1 // apply exprFuncs1[arg] to the top
+	opFunc2 // apply exprFuncs2[arg] to the top two
+)
+
+type exprInstr struct {
+	op  exprOp
+	arg uint8
+}
+
+// exprProgram is a compiled expression.
+type exprProgram struct {
+	code   []exprInstr
+	consts []complex128
+}
+
+// run evaluates the program, reading and writing variable slots.
+func (p *exprProgram) run(slots *[exprSlots]complex128, rng *xmath.RNG) {
+	var st [exprStack]complex128
+	n := 0
+	for _, in := range p.code {
+		switch in.op {
+		case opConst:
+			st[n] = p.consts[in.arg]
+			n++
+		case opLoad:
+			st[n] = slots[in.arg]
+			n++
+		case opStore:
+			n--
+			slots[in.arg] = st[n]
+		case opNeg:
+			st[n-1] = -st[n-1]
+		case opAdd:
+			n--
+			st[n-1] += st[n]
+		case opSub:
+			n--
+			st[n-1] -= st[n]
+		case opMul:
+			n--
+			st[n-1] *= st[n]
+		case opDiv:
+			n--
+			st[n-1] /= st[n]
+		case opPow:
+			n--
+			st[n-1] = exprPow(st[n-1], st[n])
+		case opFunc0:
+			st[n] = exprFuncs0[in.arg].f(rng)
+			n++
+		case opFunc1:
+			st[n-1] = exprFuncs1[in.arg].f(st[n-1])
+		case opFunc2:
+			n--
+			st[n-1] = exprFuncs2[in.arg].f(st[n-1], st[n])
+		}
+	}
+}
+
+// exprPow raises a to the power b. Integer powers are computed by repeated
+// multiplication, so that powers of reals remain real.
+func exprPow(a, b complex128) complex128 {
+	if imag(b) == 0 {
+		x := real(b)
+		if x == math.Trunc(x) && math.Abs(x) <= 64 {
+			k := int(math.Abs(x))
+			r := complex(1, 0)
+			for k > 0 {
+				if k&1 != 0 {
+					r *= a
+				}
+				a *= a
+				k >>= 1
+			}
+			if x < 0 {
+				r = 1 / r
+			}
+			return r
+		}
+		if imag(a) == 0 && real(a) >= 0 {
+			return complex(math.Pow(real(a), x), 0)
+		}
+	}
+	return cmplx.Pow(a, b)
+}
+
+// Functions available to expressions, by number of arguments. Functions of
+// reals use only the real parts of their arguments.
+var (
+	exprFuncs0 = [...]struct {
+		name string
+		f    func(*xmath.RNG) complex128
+	}{
+		{"rand", func(rng *xmath.RNG) complex128 { return complex(rng.Uniform(), 0) }},
+		{"normal", func(rng *xmath.RNG) complex128 { return complex(rng.Normal(), 0) }},
+	}
+	exprFuncs1 = [...]struct {
+		name string
+		f    func(complex128) complex128
+	}{
+		{"sin", cmplx.Sin},
+		{"cos", cmplx.Cos},
+		{"tan", cmplx.Tan},
+		{"asin", cmplx.Asin},
+		{"acos", cmplx.Acos},
+		{"atan", cmplx.Atan},
+		{"sinh", cmplx.Sinh},
+		{"cosh", cmplx.Cosh},
+		{"tanh", cmplx.Tanh},
+		{"exp", cmplx.Exp},
+		{"log", cmplx.Log},
+		{"sqrt", cmplx.Sqrt},
+		{"conj", cmplx.Conj},
+		{"abs", func(z complex128) complex128 { return complex(cmplx.Abs(z), 0) }},
+		{"arg", func(z complex128) complex128 { return complex(cmplx.Phase(z), 0) }},
+		{"re", func(z complex128) complex128 { return complex(real(z), 0) }},
+		{"im", func(z complex128) complex128 { return complex(imag(z), 0) }},
+		{"floor", func(z complex128) complex128 { return complex(math.Floor(real(z)), math.Floor(imag(z))) }},
+		{"ceil", func(z complex128) complex128 { return complex(math.Ceil(real(z)), math.Ceil(imag(z))) }},
+	}
+	exprFuncs2 = [...]struct {
+		name string
+		f    func(a, b complex128) complex128
+	}{
+		{"atan2", func(a, b complex128) complex128 { return complex(math.Atan2(real(a), real(b)), 0) }},
+		{"hypot", func(a, b complex128) complex128 { return complex(math.Hypot(real(a), real(b)), 0) }},
+		{"mod", func(a, b complex128) complex128 { return complex(math.Mod(real(a), real(b)), 0) }},
+		{"min", func(a, b complex128) complex128 { return complex(math.Min(real(a), real(b)), 0) }},
+		{"max", func(a, b complex128) complex128 { return complex(math.Max(real(a), real(b)), 0) }},
+	}
+)
+
+// compileExpr compiles definitions of user variables followed by expressions
+// for each output coordinate. An empty output expression leaves its
+// coordinate unchanged.
+func compileExpr(defs string, out [4]string) (*exprProgram, error) {
+	c := exprCompiler{prog: new(exprProgram), vars: make(map[string]uint8)}
+	c.start("defs", defs)
+	for {
+		for c.tok.kind == tokSep {
+			c.next()
+		}
+		if c.tok.kind == tokEOF {
+			break
+		}
+		if err := c.assign(); err != nil {
+			return nil, err
+		}
+		if c.tok.kind != tokSep && c.tok.kind != tokEOF {
+			return nil, c.errorf("expected end of definition")
+		}
+	}
+	names := [4]string{"x", "y", "z", "c"}
+	for i, src := range out {
+		c.start(names[i], src)
+		if c.tok.kind == tokEOF {
+			continue
+		}
+		if err := c.expr(); err != nil {
+			return nil, err
+		}
+		if c.tok.kind != tokEOF {
+			return nil, c.errorf("expected end of expression")
+		}
+		if err := c.emit(opStore, slotOutX+uint8(i), -1); err != nil {
+			return nil, err
+		}
+	}
+	return c.prog, nil
+}
+
+// exprCompiler compiles expressions by recursive descent.
+type exprCompiler struct {
+	// field is the name of the expression being compiled, and src is its
+	// source.
+	field, src string
+	// pos is the position in src of the next token.
+	pos int
+	tok exprToken
+	err error
+
+	prog *exprProgram
+	// vars maps user variables to their slots.
+	vars map[string]uint8
+	// depth is the stack depth at the current point in the program.
+	depth int
+}
+
+type exprTokenKind uint8
+
+const (
+	tokEOF exprTokenKind = iota
+	tokNum
+	tokIdent
+	tokOp
+	tokSep
+	// tokErr is a token which could not be scanned.
+	tokErr
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	// at is the position of the token in the source.
+	at int
+	// text is the text of an identifier or operator.
+	text string
+	// num is the value of a number.
+	num complex128
+}
+
+// start begins compiling a new source string.
+func (c *exprCompiler) start(field, src string) {
+	c.field, c.src, c.pos, c.err = field, src, 0, nil
+	c.next()
+}
+
+// errorf creates an error at the current token. If scanning the token failed,
+// that error is returned instead.
+func (c *exprCompiler) errorf(format string, args ...any) error {
+	return c.errorAt(c.tok.at, format, args...)
+}
+
+// errorAt creates an error at a position in the source. If scanning the
+// current token failed, that error is returned instead.
+func (c *exprCompiler) errorAt(at int, format string, args ...any) error {
+	if c.err != nil {
+		return c.err
+	}
+	return fmt.Errorf("xirho: %s: %s at offset %d", c.field, fmt.Sprintf(format, args...), at)
+}
+
+// next scans the next token.
+func (c *exprCompiler) next() {
+	for c.pos < len(c.src) && (c.src[c.pos] == ' ' || c.src[c.pos] == '\t' || c.src[c.pos] == '\r') {
+		c.pos++
+	}
+	c.tok = exprToken{at: c.pos}
+	if c.pos >= len(c.src) {
+		return
+	}
+	start := c.pos
+	switch b := c.src[c.pos]; {
+	case b == ';' || b == '\n':
+		c.pos++
+		c.tok.kind = tokSep
+	case b >= '0' && b <= '9' || b == '.':
+		for c.pos < len(c.src) && (isDigit(c.src[c.pos]) || c.src[c.pos] == '.') {
+			c.pos++
+		}
+		if c.pos < len(c.src) && (c.src[c.pos] == 'e' || c.src[c.pos] == 'E') {
+			c.pos++
+			if c.pos < len(c.src) && (c.src[c.pos] == '+' || c.src[c.pos] == '-') {
+				c.pos++
+			}
+			for c.pos < len(c.src) && isDigit(c.src[c.pos]) {
+				c.pos++
+			}
+		}
+		x, err := strconv.ParseFloat(c.src[start:c.pos], 64)
+		if err != nil {
+			c.tok.kind = tokErr
+			c.err = fmt.Errorf("xirho: %s: bad number %q at offset %d", c.field, c.src[start:c.pos], start)
+			return
+		}
+		c.tok.kind, c.tok.num = tokNum, complex(x, 0)
+		if c.pos < len(c.src) && c.src[c.pos] == 'i' {
+			c.pos++
+			c.tok.num = complex(0, x)
+		}
+	case isLetter(b):
+		for c.pos < len(c.src) && (isLetter(c.src[c.pos]) || isDigit(c.src[c.pos])) {
+			c.pos++
+		}
+		c.tok.kind, c.tok.text = tokIdent, c.src[start:c.pos]
+	default:
+		c.pos++
+		c.tok.kind, c.tok.text = tokOp, c.src[start:c.pos]
+	}
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+func isLetter(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || b == '_'
+}
+
+// isOp returns whether the current token is the given operator.
+func (c *exprCompiler) isOp(op string) bool {
+	return c.tok.kind == tokOp && c.tok.text == op
+}
+
+// emit appends an instruction which changes the stack depth by d.
+func (c *exprCompiler) emit(op exprOp, arg uint8, d int) error {
+	c.prog.code = append(c.prog.code, exprInstr{op: op, arg: arg})
+	c.depth += d
+	if c.depth > exprStack {
+		return c.errorf("expression too deeply nested")
+	}
+	return nil
+}
+
+// constant emits an instruction to push a constant.
+func (c *exprCompiler) constant(v complex128) error {
+	for i, x := range c.prog.consts {
+		if x == v {
+			return c.emit(opConst, uint8(i), 1)
+		}
+	}
+	if len(c.prog.consts) >= exprConsts {
+		return c.errorf("too many constants")
+	}
+	c.prog.consts = append(c.prog.consts, v)
+	return c.emit(opConst, uint8(len(c.prog.consts)-1), 1)
+}
+
+// assign compiles a definition of the form name = expr.
+func (c *exprCompiler) assign() error {
+	if c.tok.kind != tokIdent {
+		return c.errorf("expected variable name")
+	}
+	name := c.tok.text
+	if _, ok := exprNames[name]; ok {
+		return c.errorf("cannot assign to %s", name)
+	}
+	if _, ok := exprConstNames[name]; ok {
+		return c.errorf("cannot assign to constant %s", name)
+	}
+	c.next()
+	if !c.isOp("=") {
+		return c.errorf("expected =")
+	}
+	c.next()
+	if err := c.expr(); err != nil {
+		return err
+	}
+	// Assign the slot after compiling the expression so that a new variable
+	// is not defined in terms of itself.
+	slot, ok := c.vars[name]
+	if !ok {
+		n := slotUser + len(c.vars)
+		if n >= exprSlots {
+			return c.errorf("too many variables")
+		}
+		slot = uint8(n)
+		c.vars[name] = slot
+	}
+	return c.emit(opStore, slot, -1)
+}
+
+// expr compiles a sum of terms.
+func (c *exprCompiler) expr() error {
+	if err := c.term(); err != nil {
+		return err
+	}
+	for c.isOp("+") || c.isOp("-") {
+		op := opAdd
+		if c.tok.text == "-" {
+			op = opSub
+		}
+		c.next()
+		if err := c.term(); err != nil {
+			return err
+		}
+		if err := c.emit(op, 0, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// term compiles a product of factors.
+func (c *exprCompiler) term() error {
+	if err := c.unary(); err != nil {
+		return err
+	}
+	for c.isOp("*") || c.isOp("/") {
+		op := opMul
+		if c.tok.text == "/" {
+			op = opDiv
+		}
+		c.next()
+		if err := c.unary(); err != nil {
+			return err
+		}
+		if err := c.emit(op, 0, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unary compiles a negation or a power.
+func (c *exprCompiler) unary() error {
+	switch {
+	case c.isOp("-"):
+		c.next()
+		if err := c.unary(); err != nil {
+			return err
+		}
+		return c.emit(opNeg, 0, 0)
+	case c.isOp("+"):
+		c.next()
+		return c.unary()
+	}
+	return c.power()
+}
+
+// power compiles an exponentiation, which is right-associative and binds more
+// tightly than negation on its left.
+func (c *exprCompiler) power() error {
+	if err := c.primary(); err != nil {
+		return err
+	}
+	if !c.isOp("^") {
+		return nil
+	}
+	c.next()
+	if err := c.unary(); err != nil {
+		return err
+	}
+	return c.emit(opPow, 0, -1)
+}
+
+// primary compiles a number, name, function call, or parenthesized
+// expression.
+func (c *exprCompiler) primary() error {
+	switch c.tok.kind {
+	case tokNum:
+		v := c.tok.num
+		c.next()
+		return c.constant(v)
+	case tokIdent:
+		name, at := c.tok.text, c.tok.at
+		c.next()
+		if c.isOp("(") {
+			return c.call(name, at)
+		}
+		if slot, ok := exprNames[name]; ok {
+			return c.emit(opLoad, slot, 1)
+		}
+		if slot, ok := c.vars[name]; ok {
+			return c.emit(opLoad, slot, 1)
+		}
+		if v, ok := exprConstNames[name]; ok {
+			return c.constant(v)
+		}
+		return c.errorAt(at, "undefined name %s", name)
+	case tokOp:
+		if c.isOp("(") {
+			c.next()
+			if err := c.expr(); err != nil {
+				return err
+			}
+			if !c.isOp(")") {
+				return c.errorf("expected )")
+			}
+			c.next()
+			return nil
+		}
+		return c.errorf("unexpected %q", c.tok.text)
+	case tokSep:
+		return c.errorf("unexpected end of definition")
+	case tokEOF:
+		return c.errorf("unexpected end of expression")
+	default:
+		return c.errorf("bad token")
+	}
+}
+
+// call compiles a call to the function whose name is at the given position.
+// The current token is the opening parenthesis.
+func (c *exprCompiler) call(name string, at int) error {
+	c.next()
+	var n int
+	if !c.isOp(")") {
+		for {
+			if err := c.expr(); err != nil {
+				return err
+			}
+			n++
+			if !c.isOp(",") {
+				break
+			}
+			c.next()
+		}
+	}
+	if !c.isOp(")") {
+		return c.errorf("expected ) after arguments to %s", name)
+	}
+	c.next()
+	switch n {
+	case 0:
+		for i, f := range exprFuncs0 {
+			if f.name == name {
+				return c.emit(opFunc0, uint8(i), 1)
+			}
+		}
+	case 1:
+		for i, f := range exprFuncs1 {
+			if f.name == name {
+				return c.emit(opFunc1, uint8(i), 0)
+			}
+		}
+	case 2:
+		for i, f := range exprFuncs2 {
+			if f.name == name {
+				return c.emit(opFunc2, uint8(i), -1)
+			}
+		}
+	}
+	return c.errorAt(at, "no function %s of %d arguments", name, n)
+}
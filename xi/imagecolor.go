@@ -0,0 +1,53 @@
+package xi
+
+import (
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// ImageColor sets the color coordinate of points from the luminance or hue of
+// the pixel beneath them in an image loaded from a PNG or JPEG file. The image
+// is placed as for ImagePoints. Blend is the fraction of the way to move the
+// color coordinate toward the image's value, further scaled by the pixel's
+// opacity. Points outside the image are unchanged, as are all points if the
+// image cannot be loaded.
+type ImageColor struct {
+	Path  string  `xirho:"path,path"`
+	Mode  int     `xirho:"mode,luminance,hue"`
+	Blend float64 `xirho:"blend,0,1"`
+
+	img raster
+}
+
+// Image color modes.
+const (
+	imageLuminance = iota
+	imageHue
+)
+
+// newImageColor is a factory for ImageColor, defaulting to taking the color
+// coordinate entirely from luminance.
+func newImageColor() xirho.Func {
+	return &ImageColor{Blend: 1}
+}
+
+func (f *ImageColor) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	i, ok := f.img.pixel(in.X, in.Y)
+	if !ok {
+		return in
+	}
+	v := f.img.lum[i]
+	if f.Mode == imageHue {
+		v = f.img.hue[i]
+	}
+	in.C += f.Blend * float64(f.img.alpha[i]) * (float64(v) - in.C)
+	return in
+}
+
+func (f *ImageColor) Prep() {
+	f.img.load(f.Path)
+}
+
+func init() {
+	must("imagecolor", newImageColor)
+}
@@ -0,0 +1,16 @@
+package xi_test
+
+import (
+	"testing"
+
+	"github.com/zephyrtronium/xirho/fapi"
+)
+
+func TestImageColorAPI(t *testing.T) {
+	expect := map[string]fapi.Param{
+		"path":  fapi.String{},
+		"mode":  fapi.List{},
+		"blend": fapi.Real{},
+	}
+	ExpectAPI(t, expect, "imagecolor")
+}
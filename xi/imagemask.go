@@ -0,0 +1,40 @@
+package xi
+
+import (
+	"math"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// ImageMask discards points outside the opaque area of an image loaded from a
+// PNG or JPEG file, i.e. those outside the image or on pixels with opacity
+// less than Threshold. Other points are unchanged. The image is placed as for
+// ImagePoints. If the image cannot be loaded, all points are discarded.
+type ImageMask struct {
+	Path      string  `xirho:"path,path"`
+	Threshold float64 `xirho:"threshold,0,1"`
+
+	img raster
+}
+
+// newImageMask is a factory for ImageMask, defaulting Threshold to 0.5.
+func newImageMask() xirho.Func {
+	return &ImageMask{Threshold: 0.5}
+}
+
+func (f *ImageMask) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	i, ok := f.img.pixel(in.X, in.Y)
+	if !ok || float64(f.img.alpha[i]) < f.Threshold {
+		in.X = math.NaN()
+	}
+	return in
+}
+
+func (f *ImageMask) Prep() {
+	f.img.load(f.Path)
+}
+
+func init() {
+	must("imagemask", newImageMask)
+}
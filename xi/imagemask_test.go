@@ -0,0 +1,15 @@
+package xi_test
+
+import (
+	"testing"
+
+	"github.com/zephyrtronium/xirho/fapi"
+)
+
+func TestImageMaskAPI(t *testing.T) {
+	expect := map[string]fapi.Param{
+		"path":      fapi.String{},
+		"threshold": fapi.Real{},
+	}
+	ExpectAPI(t, expect, "imagemask")
+}
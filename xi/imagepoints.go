@@ -0,0 +1,75 @@
+package xi
+
+import (
+	"math"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// ImagePoints moves points to random locations within an image loaded from a
+// PNG or JPEG file, choosing each pixel with probability proportional to its
+// luminance times its opacity, or to its darkness times its opacity if Invert
+// is set. The image is placed so that its longer axis spans [-1, 1], centered
+// at the origin, with its top toward negative y. The z and color coordinates
+// are unchanged. If the image cannot be loaded or has no pixels to choose,
+// points are discarded.
+type ImagePoints struct {
+	Path   string `xirho:"path,path"`
+	Invert bool   `xirho:"invert"`
+
+	img raster
+	// cdf is the cumulative weight of each pixel. cdfPath and cdfInvert are
+	// the Path and Invert for which it was computed, so that Prep needs to
+	// recompute it only when they change.
+	cdf       []float64
+	cdfPath   string
+	cdfInvert bool
+}
+
+func (f *ImagePoints) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	n := len(f.cdf)
+	if n == 0 || f.cdf[n-1] <= 0 {
+		in.X = math.NaN()
+		return in
+	}
+	// Find the first pixel whose cumulative weight exceeds u. Since u is less
+	// than the total, there is always such a pixel, and it has nonzero weight.
+	u := rng.Uniform() * f.cdf[n-1]
+	lo, hi := 0, n-1
+	for lo < hi {
+		m := int(uint(lo+hi) >> 1)
+		if f.cdf[m] > u {
+			hi = m
+		} else {
+			lo = m + 1
+		}
+	}
+	in.X, in.Y = f.img.point(lo, rng)
+	return in
+}
+
+func (f *ImagePoints) Prep() {
+	f.img.load(f.Path)
+	if len(f.img.lum) == 0 {
+		f.cdf = nil
+		return
+	}
+	if f.cdf != nil && f.cdfPath == f.Path && f.cdfInvert == f.Invert {
+		return
+	}
+	f.cdfPath, f.cdfInvert = f.Path, f.Invert
+	f.cdf = make([]float64, len(f.img.lum))
+	var s float64
+	for i, l := range f.img.lum {
+		if f.Invert {
+			l = 1 - l
+		}
+		s += float64(l * f.img.alpha[i])
+		f.cdf[i] = s
+	}
+}
+
+func init() {
+	must("imagepoints", func() xirho.Func { return &ImagePoints{} })
+}
@@ -0,0 +1,15 @@
+package xi_test
+
+import (
+	"testing"
+
+	"github.com/zephyrtronium/xirho/fapi"
+)
+
+func TestImagePointsAPI(t *testing.T) {
+	expect := map[string]fapi.Param{
+		"path":   fapi.String{},
+		"invert": fapi.Flag{},
+	}
+	ExpectAPI(t, expect, "imagepoints")
+}
@@ -0,0 +1,81 @@
+package xi
+
+import (
+	"math"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// lattice is a seeded permutation table for coherent noise. The permutation
+// of 0..255 is repeated so that sums of two entries need no wrapping.
+type lattice [512]uint8
+
+// seed fills the table with a permutation determined by a seed.
+func (l *lattice) seed(seed int64) {
+	rng := xmath.NewSeededRNG(uint64(seed))
+	for i := 0; i < 256; i++ {
+		l[i] = uint8(i)
+	}
+	for i := 255; i > 0; i-- {
+		j := rng.Intn(i + 1)
+		l[i], l[j] = l[j], l[i]
+	}
+	copy(l[256:], l[:256])
+}
+
+// hash hashes an integer lattice point.
+func (l *lattice) hash(i, j, k int) int {
+	return int(l[int(l[int(l[i&255])+j&255])+k&255])
+}
+
+// noise3 is a coherent noise function of three dimensions whose result is
+// roughly within [-1, 1].
+type noise3 func(l *lattice, x, y, z float64) float64
+
+// fractal is the parameters of fractal noise shared by coherent noise
+// functions.
+type fractal struct {
+	freq, lacunarity, gain float64
+	octaves                int64
+}
+
+// sum evaluates fractal noise at a point: octaves of noise of increasing
+// frequency and decreasing amplitude.
+func (f fractal) sum(l *lattice, n noise3, x, y, z float64) float64 {
+	x, y, z = x*f.freq, y*f.freq, z*f.freq
+	var s float64
+	a := 1.0
+	for i := int64(0); i < f.octaves; i++ {
+		s += a * n(l, x, y, z)
+		x, y, z = x*f.lacunarity, y*f.lacunarity, z*f.lacunarity
+		a *= f.gain
+	}
+	return s
+}
+
+// Offsets which decorrelate the noise fields displacing each coordinate.
+const (
+	noiseOffY = 31.4159
+	noiseOffZ = -27.1828
+	noiseOffC = 14.1421
+)
+
+// displace displaces a point by independent fields of fractal noise in each
+// coordinate, scaled by amp, and its color coordinate by another field scaled
+// by color, clamped to [0, 1].
+func (f fractal) displace(l *lattice, n noise3, in xirho.Pt, amp, color float64) xirho.Pt {
+	x, y, z := in.X, in.Y, in.Z
+	in.X += amp * f.sum(l, n, x, y, z)
+	in.Y += amp * f.sum(l, n, x+noiseOffY, y+noiseOffY, z+noiseOffY)
+	in.Z += amp * f.sum(l, n, x+noiseOffZ, y+noiseOffZ, z+noiseOffZ)
+	if color != 0 {
+		in.C = math.Min(math.Max(in.C+color*f.sum(l, n, x+noiseOffC, y+noiseOffC, z+noiseOffC), 0), 1)
+	}
+	return in
+}
+
+// lerp interpolates linearly between a and b.
+func lerp(a, b, t float64) float64 {
+	return a + t*(b-a)
+}
@@ -0,0 +1,57 @@
+package xi_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xi"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+func TestCoherentNoise(t *testing.T) {
+	seeded := func(name string, seed int64) xirho.Func {
+		f := xi.New(name)
+		switch f := f.(type) {
+		case *xi.Perlin:
+			f.Seed, f.Color = seed, 0.5
+		case *xi.Simplex:
+			f.Seed, f.Color = seed, 0.5
+		case *xi.Worley:
+			f.Seed, f.Color = seed, 0.5
+		}
+		f.Prep()
+		return f
+	}
+	for _, name := range []string{"perlin", "simplex", "worley"} {
+		t.Run(name, func(t *testing.T) {
+			rng := xmath.NewRNG()
+			a, b, c := seeded(name, 1), seeded(name, 1), seeded(name, 2)
+			differ := false
+			for i := 0; i < 1000; i++ {
+				in := xirho.Pt{X: rng.Uniform()*8 - 4, Y: rng.Uniform()*8 - 4, Z: rng.Uniform()*8 - 4, C: rng.Uniform()}
+				p, q, r := a.Calc(in, &rng), b.Calc(in, &rng), c.Calc(in, &rng)
+				if p != q {
+					t.Errorf("same seed gave different results at %v: %v, %v", in, p, q)
+				}
+				differ = differ || p != r
+				if !p.IsValid() {
+					t.Errorf("invalid result %v at %v", p, in)
+				}
+				// Noise is coherent: nearby inputs give nearby outputs.
+				near := in
+				near.X += 1e-6
+				if s := a.Calc(near, &rng); math.Abs(s.X-p.X) > 1e-3 || math.Abs(s.Y-p.Y) > 1e-3 || math.Abs(s.Z-p.Z) > 1e-3 {
+					t.Errorf("discontinuity at %v: %v vs %v", in, p, s)
+				}
+			}
+			if !differ {
+				t.Error("different seeds gave the same results")
+			}
+			in := xirho.Pt{X: 0.3, Y: -1.7, Z: 2.2, C: 0.5}
+			if n := testing.AllocsPerRun(100, func() { a.Calc(in, &rng) }); n != 0 {
+				t.Errorf("Calc allocates %v times", n)
+			}
+		})
+	}
+}
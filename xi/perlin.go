@@ -0,0 +1,94 @@
+package xi
+
+import (
+	"math"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// Perlin displaces points by fractal Perlin gradient noise, giving smooth,
+// organic distortions. Each coordinate is displaced by an independent noise
+// field. Octaves of noise are summed, each with its frequency multiplied by
+// Lacunarity and its amplitude by Gain relative to the previous. If Color is
+// nonzero, the color coordinate is also displaced by that much noise.
+type Perlin struct {
+	Freq       float64 `xirho:"frequency"`
+	Octaves    int64   `xirho:"octaves,1,16"`
+	Lacunarity float64 `xirho:"lacunarity"`
+	Gain       float64 `xirho:"gain"`
+	Seed       int64   `xirho:"seed"`
+	Amp        float64 `xirho:"amplitude"`
+	Color      float64 `xirho:"color"`
+
+	lat lattice
+}
+
+// newPerlin is a factory for Perlin, defaulting to four octaves of noise of
+// unit frequency.
+func newPerlin() xirho.Func {
+	return &Perlin{Freq: 1, Octaves: 4, Lacunarity: 2, Gain: 0.5, Amp: 0.25}
+}
+
+func (f *Perlin) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	fr := fractal{freq: f.Freq, lacunarity: f.Lacunarity, gain: f.Gain, octaves: f.Octaves}
+	return fr.displace(&f.lat, perlin, in, f.Amp, f.Color)
+}
+
+func (f *Perlin) Prep() {
+	f.lat.seed(f.Seed)
+}
+
+// perlin computes improved Perlin noise.
+func perlin(l *lattice, x, y, z float64) float64 {
+	fx, fy, fz := math.Floor(x), math.Floor(y), math.Floor(z)
+	i, j, k := int(fx)&255, int(fy)&255, int(fz)&255
+	x, y, z = x-fx, y-fy, z-fz
+	u, v, w := fade(x), fade(y), fade(z)
+	a := int(l[i]) + j
+	aa, ab := int(l[a])+k, int(l[a+1])+k
+	b := int(l[i+1]) + j
+	ba, bb := int(l[b])+k, int(l[b+1])+k
+	return lerp(
+		lerp(
+			lerp(grad(l[aa], x, y, z), grad(l[ba], x-1, y, z), u),
+			lerp(grad(l[ab], x, y-1, z), grad(l[bb], x-1, y-1, z), u),
+			v),
+		lerp(
+			lerp(grad(l[aa+1], x, y, z-1), grad(l[ba+1], x-1, y, z-1), u),
+			lerp(grad(l[ab+1], x, y-1, z-1), grad(l[bb+1], x-1, y-1, z-1), u),
+			v),
+		w)
+}
+
+// fade is the quintic interpolant of improved Perlin noise.
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+// grad computes the dot product of a point with one of twelve gradients
+// selected by a hash.
+func grad(h uint8, x, y, z float64) float64 {
+	h &= 15
+	u := y
+	if h < 8 {
+		u = x
+	}
+	v := z
+	if h < 4 {
+		v = y
+	} else if h == 12 || h == 14 {
+		v = x
+	}
+	if h&1 != 0 {
+		u = -u
+	}
+	if h&2 != 0 {
+		v = -v
+	}
+	return u + v
+}
+
+func init() {
+	must("perlin", newPerlin)
+}
@@ -0,0 +1,121 @@
+package xi
+
+import (
+	"image"
+	"image/color"
+	_ "image/jpeg" // register JPEG decoding
+	_ "image/png"  // register PNG decoding
+	"io"
+	"math"
+	"os"
+
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// maxRasterPixels is the largest number of pixels in an image that functions
+// will load, to avoid huge allocations for enormous or corrupt files.
+const maxRasterPixels = 1 << 25
+
+// raster is an image prepared for sampling by functions. The image is placed
+// so that its longer axis spans [-1, 1], centered at the origin, with its top
+// toward negative y, so that it appears upright through the identity camera.
+type raster struct {
+	// path is the file from which the image was loaded.
+	path string
+	// w and h are the size of the image in pixels, and s is the number of
+	// pixels per unit.
+	w, h int
+	s    float64
+	// lum, hue, and alpha are the luminance, hue, and opacity in [0, 1] of
+	// each pixel in row-major order. Luminance and hue are not premultiplied.
+	lum, hue, alpha []float32
+}
+
+// load loads the image at path. If the image is already loaded from that path,
+// it is not read again. If the image cannot be loaded or has more than
+// maxRasterPixels pixels, the raster is empty.
+func (r *raster) load(path string) {
+	if r.path == path && r.w > 0 {
+		return
+	}
+	*r = raster{path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxRasterPixels/cfg.Height {
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return
+	}
+	r.w, r.h = w, h
+	r.s = float64(max(w, h)) / 2
+	r.lum = make([]float32, w*h)
+	r.hue = make([]float32, w*h)
+	r.alpha = make([]float32, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA64Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA64)
+			red, grn, blu := float64(c.R)/0xffff, float64(c.G)/0xffff, float64(c.B)/0xffff
+			i := y*w + x
+			r.lum[i] = float32(0.2126*red + 0.7152*grn + 0.0722*blu)
+			r.hue[i] = float32(hue(red, grn, blu))
+			r.alpha[i] = float32(c.A) / 0xffff
+		}
+	}
+}
+
+// pixel returns the index of the pixel containing a point. The result is false
+// if the point is outside the image.
+func (r *raster) pixel(x, y float64) (int, bool) {
+	px := math.Floor(x*r.s + float64(r.w)/2)
+	py := math.Floor(y*r.s + float64(r.h)/2)
+	// negated condition to catch nans
+	if !(px >= 0 && px < float64(r.w) && py >= 0 && py < float64(r.h)) {
+		return 0, false
+	}
+	return int(py)*r.w + int(px), true
+}
+
+// point returns a uniformly random point within a pixel.
+func (r *raster) point(i int, rng *xmath.RNG) (x, y float64) {
+	px, py := i%r.w, i/r.w
+	x = (float64(px) + rng.Uniform() - float64(r.w)/2) / r.s
+	y = (float64(py) + rng.Uniform() - float64(r.h)/2) / r.s
+	return x, y
+}
+
+// hue computes the hue of a color as a fraction of a turn from red through
+// green and blue. The hue of a gray is 0.
+func hue(r, g, b float64) float64 {
+	hi, lo := max(r, g, b), min(r, g, b)
+	d := hi - lo
+	if d == 0 {
+		return 0
+	}
+	var h float64
+	switch hi {
+	case r:
+		h = (g - b) / d
+		if h < 0 {
+			h += 6
+		}
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return h / 6
+}
@@ -0,0 +1,197 @@
+package xi_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/fapi"
+	"github.com/zephyrtronium/xirho/xi"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// testImage writes a 4x2 PNG whose left half is opaque and right half is
+// transparent. The top left pixel is white, the next is blue, and the other
+// opaque pixels are black. The image spans [-1, 1] in x and [-0.5, 0.5] in y,
+// so each pixel is 0.5 units square.
+func testImage(t *testing.T) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			img.Set(x, y, color.Black)
+		}
+	}
+	img.Set(0, 0, color.White)
+	img.Set(1, 0, color.NRGBA{B: 0xff, A: 0xff})
+	name := filepath.Join(t.TempDir(), "test.png")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestImagePoints(t *testing.T) {
+	name := testImage(t)
+	rng := xmath.NewRNG()
+	f := &xi.ImagePoints{Path: name}
+	f.Prep()
+	var n [2]int
+	for i := 0; i < 10000; i++ {
+		p := f.Calc(xirho.Pt{Z: 1, C: 0.5}, &rng)
+		switch {
+		case p.X >= -1 && p.X < -0.5 && p.Y >= -0.5 && p.Y < 0:
+			n[0]++
+		case p.X >= -0.5 && p.X < 0 && p.Y >= -0.5 && p.Y < 0:
+			n[1]++
+		default:
+			t.Fatalf("point %v outside bright pixels", p)
+		}
+		if p.Z != 1 || p.C != 0.5 {
+			t.Fatalf("z or color changed: %v", p)
+		}
+	}
+	// Blue has luminance 0.0722.
+	if r := float64(n[1]) / float64(n[0]); math.Abs(r-0.0722) > 0.02 {
+		t.Errorf("wrong ratio of blue to white points: want 0.0722, got %g", r)
+	}
+	if n := testing.AllocsPerRun(100, func() { f.Calc(xirho.Pt{}, &rng) }); n != 0 {
+		t.Errorf("Calc allocates %v times", n)
+	}
+	// Preparing again with the same image and weights reuses them.
+	if n := testing.AllocsPerRun(10, f.Prep); n != 0 {
+		t.Errorf("repeated Prep allocates %v times", n)
+	}
+
+	f.Invert = true
+	f.Prep()
+	for i := 0; i < 10000; i++ {
+		p := f.Calc(xirho.Pt{}, &rng)
+		if !(p.X >= -1 && p.X < 0 && p.Y >= -0.5 && p.Y < 0.5) || (p.X < -0.5 && p.Y < 0) {
+			t.Fatalf("inverted point %v outside dark pixels", p)
+		}
+	}
+}
+
+func TestImageColor(t *testing.T) {
+	name := testImage(t)
+	rng := xmath.NewRNG()
+	cases := []struct {
+		name  string
+		mode  int
+		blend float64
+		in    xirho.Pt
+		want  float64
+	}{
+		{"white", 0, 1, xirho.Pt{X: -0.75, Y: -0.25, C: 0.5}, 1},
+		{"black", 0, 1, xirho.Pt{X: -0.25, Y: 0.25, C: 0.5}, 0},
+		{"blend", 0, 0.5, xirho.Pt{X: -0.75, Y: -0.25, C: 0.5}, 0.75},
+		{"hue", 1, 1, xirho.Pt{X: -0.25, Y: -0.25, C: 0.5}, 2.0 / 3},
+		{"transparent", 0, 1, xirho.Pt{X: 0.25, Y: -0.25, C: 0.5}, 0.5},
+		{"outside", 0, 1, xirho.Pt{X: -0.75, Y: 0.75, C: 0.5}, 0.5},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := &xi.ImageColor{Path: name, Mode: c.mode, Blend: c.blend}
+			f.Prep()
+			p := f.Calc(c.in, &rng)
+			if math.Abs(p.C-c.want) > 1e-6 {
+				t.Errorf("wrong color: want %g, got %g", c.want, p.C)
+			}
+			if p.X != c.in.X || p.Y != c.in.Y || p.Z != c.in.Z {
+				t.Errorf("point moved: %v became %v", c.in, p)
+			}
+		})
+	}
+}
+
+func TestImageMask(t *testing.T) {
+	name := testImage(t)
+	rng := xmath.NewRNG()
+	f := &xi.ImageMask{Path: name, Threshold: 0.5}
+	f.Prep()
+	cases := []struct {
+		in   xirho.Pt
+		keep bool
+	}{
+		{xirho.Pt{X: -0.75, Y: -0.25}, true},
+		{xirho.Pt{X: -0.25, Y: 0.25}, true},
+		{xirho.Pt{X: 0.25, Y: -0.25}, false},
+		{xirho.Pt{X: 0.75, Y: 0.25}, false},
+		{xirho.Pt{X: -0.75, Y: 0.75}, false},
+		{xirho.Pt{X: math.NaN()}, false},
+	}
+	for _, c := range cases {
+		p := f.Calc(c.in, &rng)
+		if p.IsValid() != c.keep {
+			t.Errorf("wrong result for %v: want kept %t, got %v", c.in, c.keep, p)
+		}
+	}
+}
+
+func TestImageMissing(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "missing.png")
+	rng := xmath.NewRNG()
+	in := xirho.Pt{X: 0.1, Y: 0.2, C: 0.5}
+	pts := &xi.ImagePoints{Path: name}
+	pts.Prep()
+	if p := pts.Calc(in, &rng); p.IsValid() {
+		t.Errorf("imagepoints with missing image gave valid point %v", p)
+	}
+	clr := &xi.ImageColor{Path: name, Blend: 1}
+	clr.Prep()
+	if p := clr.Calc(in, &rng); p != in {
+		t.Errorf("imagecolor with missing image changed %v to %v", in, p)
+	}
+	mask := &xi.ImageMask{Path: name}
+	mask.Prep()
+	if p := mask.Calc(in, &rng); p.IsValid() {
+		t.Errorf("imagemask with missing image gave valid point %v", p)
+	}
+}
+
+func TestImageTooLarge(t *testing.T) {
+	// Claim a size of 32768x32768 in the header of a valid 1x1 PNG.
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	p := b.Bytes()
+	ihdr := p[12 : 12+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:], 1<<15)
+	binary.BigEndian.PutUint32(ihdr[8:], 1<<15)
+	binary.BigEndian.PutUint32(p[12+4+13:], crc32.ChecksumIEEE(ihdr))
+	name := filepath.Join(t.TempDir(), "large.png")
+	if err := os.WriteFile(name, p, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rng := xmath.NewRNG()
+	f := &xi.ImagePoints{Path: name}
+	f.Prep()
+	if p := f.Calc(xirho.Pt{}, &rng); p.IsValid() {
+		t.Errorf("imagepoints with oversized image gave valid point %v", p)
+	}
+}
+
+func TestImagePathParams(t *testing.T) {
+	for _, name := range []string{"imagepoints", "imagecolor", "imagemask"} {
+		for _, p := range fapi.For(xi.New(name)) {
+			if p, ok := p.(fapi.String); ok && p.Name() == "path" && !p.IsPath() {
+				t.Errorf("%s path param is not marked as a path", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package xi
+
+import (
+	"math"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// Simplex displaces points by fractal simplex noise. It is similar to Perlin,
+// with fewer directional artifacts. Each coordinate is displaced by an
+// independent noise field. Octaves of noise are summed, each with its
+// frequency multiplied by Lacunarity and its amplitude by Gain relative to the
+// previous. If Color is nonzero, the color coordinate is also displaced by
+// that much noise.
+type Simplex struct {
+	Freq       float64 `xirho:"frequency"`
+	Octaves    int64   `xirho:"octaves,1,16"`
+	Lacunarity float64 `xirho:"lacunarity"`
+	Gain       float64 `xirho:"gain"`
+	Seed       int64   `xirho:"seed"`
+	Amp        float64 `xirho:"amplitude"`
+	Color      float64 `xirho:"color"`
+
+	lat lattice
+}
+
+// newSimplex is a factory for Simplex, defaulting to four octaves of noise of
+// unit frequency.
+func newSimplex() xirho.Func {
+	return &Simplex{Freq: 1, Octaves: 4, Lacunarity: 2, Gain: 0.5, Amp: 0.25}
+}
+
+func (f *Simplex) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	fr := fractal{freq: f.Freq, lacunarity: f.Lacunarity, gain: f.Gain, octaves: f.Octaves}
+	return fr.displace(&f.lat, simplex, in, f.Amp, f.Color)
+}
+
+func (f *Simplex) Prep() {
+	f.lat.seed(f.Seed)
+}
+
+// simplex computes three-dimensional simplex noise.
+func simplex(l *lattice, x, y, z float64) float64 {
+	const (
+		skew   = 1.0 / 3
+		unskew = 1.0 / 6
+	)
+	// Find the simplex cell containing the point and the point's position
+	// relative to the cell's origin.
+	s := (x + y + z) * skew
+	fi, fj, fk := math.Floor(x+s), math.Floor(y+s), math.Floor(z+s)
+	t := (fi + fj + fk) * unskew
+	x0, y0, z0 := x-fi+t, y-fj+t, z-fk+t
+	// Determine which of the six tetrahedra in the cell contains the point,
+	// as the offsets of its second and third corners.
+	var i1, j1, k1, i2, j2, k2 int
+	switch {
+	case x0 >= y0 && y0 >= z0:
+		i1, i2, j2 = 1, 1, 1
+	case x0 >= y0 && x0 >= z0:
+		i1, i2, k2 = 1, 1, 1
+	case x0 >= y0:
+		k1, i2, k2 = 1, 1, 1
+	case y0 < z0:
+		k1, j2, k2 = 1, 1, 1
+	case x0 < z0:
+		j1, j2, k2 = 1, 1, 1
+	default:
+		j1, i2, j2 = 1, 1, 1
+	}
+	x1, y1, z1 := x0-float64(i1)+unskew, y0-float64(j1)+unskew, z0-float64(k1)+unskew
+	x2, y2, z2 := x0-float64(i2)+2*unskew, y0-float64(j2)+2*unskew, z0-float64(k2)+2*unskew
+	x3, y3, z3 := x0-1+3*unskew, y0-1+3*unskew, z0-1+3*unskew
+	i, j, k := int(fi)&255, int(fj)&255, int(fk)&255
+	n := corner(l.hash(i, j, k), x0, y0, z0)
+	n += corner(l.hash(i+i1, j+j1, k+k1), x1, y1, z1)
+	n += corner(l.hash(i+i2, j+j2, k+k2), x2, y2, z2)
+	n += corner(l.hash(i+1, j+1, k+1), x3, y3, z3)
+	return 32 * n
+}
+
+// corner computes the contribution of one corner of a simplex.
+func corner(h int, x, y, z float64) float64 {
+	t := 0.6 - x*x - y*y - z*z
+	if t <= 0 {
+		return 0
+	}
+	t *= t
+	return t * t * grad(uint8(h%12), x, y, z)
+}
+
+func init() {
+	must("simplex", newSimplex)
+}
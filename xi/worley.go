@@ -0,0 +1,71 @@
+package xi
+
+import (
+	"math"
+
+	"github.com/zephyrtronium/xirho"
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+// Worley displaces points by fractal cellular noise, giving cracked or
+// scaly distortions. The noise is the distance d to the nearest of a set of
+// random feature points, one in each unit cell, mapped to 2d-1 so that it is
+// usually within [-1, 1].
+// Each coordinate is displaced by an independent noise field. Octaves of
+// noise are summed, each with its frequency multiplied by Lacunarity and its
+// amplitude by Gain relative to the previous. If Color is nonzero, the color
+// coordinate is also displaced by that much noise.
+type Worley struct {
+	Freq       float64 `xirho:"frequency"`
+	Octaves    int64   `xirho:"octaves,1,16"`
+	Lacunarity float64 `xirho:"lacunarity"`
+	Gain       float64 `xirho:"gain"`
+	Seed       int64   `xirho:"seed"`
+	Amp        float64 `xirho:"amplitude"`
+	Color      float64 `xirho:"color"`
+
+	lat lattice
+}
+
+// newWorley is a factory for Worley, defaulting to two octaves of noise of
+// unit frequency.
+func newWorley() xirho.Func {
+	return &Worley{Freq: 1, Octaves: 2, Lacunarity: 2, Gain: 0.5, Amp: 0.25}
+}
+
+func (f *Worley) Calc(in xirho.Pt, rng *xmath.RNG) xirho.Pt {
+	fr := fractal{freq: f.Freq, lacunarity: f.Lacunarity, gain: f.Gain, octaves: f.Octaves}
+	return fr.displace(&f.lat, worley, in, f.Amp, f.Color)
+}
+
+func (f *Worley) Prep() {
+	f.lat.seed(f.Seed)
+}
+
+// worley computes cellular noise.
+func worley(l *lattice, x, y, z float64) float64 {
+	fx, fy, fz := math.Floor(x), math.Floor(y), math.Floor(z)
+	i, j, k := int(fx), int(fy), int(fz)
+	x, y, z = x-fx, y-fy, z-fz
+	d := math.Inf(1)
+	for dk := -1; dk <= 1; dk++ {
+		for dj := -1; dj <= 1; dj++ {
+			for di := -1; di <= 1; di++ {
+				// Derive the feature point's position within its cell from
+				// successive entries of the table.
+				a := l[l.hash(i+di, j+dj, k+dk)]
+				b := l[int(a)+1]
+				c := l[int(b)+2]
+				px := float64(di) + (float64(a)+0.5)/256 - x
+				py := float64(dj) + (float64(b)+0.5)/256 - y
+				pz := float64(dk) + (float64(c)+0.5)/256 - z
+				d = math.Min(d, px*px+py*py+pz*pz)
+			}
+		}
+	}
+	return 2*math.Sqrt(d) - 1
+}
+
+func init() {
+	must("worley", newWorley)
+}
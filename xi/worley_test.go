@@ -0,0 +1,20 @@
+package xi_test
+
+import (
+	"testing"
+
+	"github.com/zephyrtronium/xirho/fapi"
+)
+
+func TestWorleyAPI(t *testing.T) {
+	expect := map[string]fapi.Param{
+		"frequency":  fapi.Real{},
+		"octaves":    fapi.Int{},
+		"lacunarity": fapi.Real{},
+		"gain":       fapi.Real{},
+		"seed":       fapi.Int{},
+		"amplitude":  fapi.Real{},
+		"color":      fapi.Real{},
+	}
+	ExpectAPI(t, expect, "worley")
+}
@@ -0,0 +1,188 @@
+package xmath
+
+import "math"
+
+// InterpAffine interpolates between two affine transforms. The linear part of
+// each transform is decomposed into a rotation and a stretch, so that the
+// rotation can be interpolated along the shortest arc while the stretch and
+// the translation are interpolated linearly. This keeps intermediate
+// transforms from shrinking as they would if each component were interpolated
+// separately. If either transform is singular, then each component is
+// interpolated linearly instead.
+func InterpAffine(a, b Affine, t float64) Affine {
+	ra, sa, ok := polar(linear(&a))
+	if !ok {
+		return lerpAffine(a, b, t)
+	}
+	rb, sb, ok := polar(linear(&b))
+	if !ok {
+		return lerpAffine(a, b, t)
+	}
+	r := slerp(quat(ra), quat(rb), t).mat()
+	var s mat3
+	for i := range s {
+		s[i] = sa[i] + (sb[i]-sa[i])*t
+	}
+	m := r.mul(s)
+	return Affine{
+		m[0], m[1], m[2], a[3] + (b[3]-a[3])*t,
+		m[3], m[4], m[5], a[7] + (b[7]-a[7])*t,
+		m[6], m[7], m[8], a[11] + (b[11]-a[11])*t,
+	}
+}
+
+// lerpAffine interpolates linearly between each component of two affine
+// transforms.
+func lerpAffine(a, b Affine, t float64) Affine {
+	for i := range a {
+		a[i] += (b[i] - a[i]) * t
+	}
+	return a
+}
+
+// mat3 is a 3×3 matrix in row major order.
+type mat3 [9]float64
+
+// linear gets the linear part of an affine transform.
+func linear(ax *Affine) mat3 {
+	return mat3{
+		ax[0], ax[1], ax[2],
+		ax[4], ax[5], ax[6],
+		ax[8], ax[9], ax[10],
+	}
+}
+
+// det computes the determinant of m.
+func (m mat3) det() float64 {
+	return m[0]*(m[4]*m[8]-m[5]*m[7]) - m[1]*(m[3]*m[8]-m[5]*m[6]) + m[2]*(m[3]*m[7]-m[4]*m[6])
+}
+
+// invT computes the transpose of the inverse of m, given its determinant.
+func (m mat3) invT(det float64) mat3 {
+	// The inverse transpose is the cofactor matrix over the determinant.
+	return mat3{
+		(m[4]*m[8] - m[5]*m[7]) / det, (m[5]*m[6] - m[3]*m[8]) / det, (m[3]*m[7] - m[4]*m[6]) / det,
+		(m[2]*m[7] - m[1]*m[8]) / det, (m[0]*m[8] - m[2]*m[6]) / det, (m[1]*m[6] - m[0]*m[7]) / det,
+		(m[1]*m[5] - m[2]*m[4]) / det, (m[2]*m[3] - m[0]*m[5]) / det, (m[0]*m[4] - m[1]*m[3]) / det,
+	}
+}
+
+// mul computes the matrix product m n.
+func (m mat3) mul(n mat3) mat3 {
+	var r mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			r[3*i+j] = m[3*i]*n[j] + m[3*i+1]*n[3+j] + m[3*i+2]*n[6+j]
+		}
+	}
+	return r
+}
+
+// transpose computes the transpose of m.
+func (m mat3) transpose() mat3 {
+	return mat3{
+		m[0], m[3], m[6],
+		m[1], m[4], m[7],
+		m[2], m[5], m[8],
+	}
+}
+
+// polar computes the polar decomposition m = r s, where r is a rotation and s
+// is symmetric. If m includes a reflection, then it is carried by s so that r
+// is always a proper rotation. ok is false if m is singular.
+func polar(m mat3) (r, s mat3, ok bool) {
+	d := m.det()
+	if !(math.Abs(d) > 1e-12) || !IsFinite(d) {
+		return r, s, false
+	}
+	// Newton's iteration r ← (r + r⁻ᵀ)/2 converges quadratically to the
+	// orthogonal factor. Starting from -m when m has a negative determinant
+	// makes that factor a rotation, since the determinant of a 3×3 matrix
+	// changes sign with the matrix.
+	r = m
+	if d < 0 {
+		for i := range r {
+			r[i] = -r[i]
+		}
+	}
+	for k := 0; k < 100; k++ {
+		y := r.invT(r.det())
+		var e float64
+		for i := range r {
+			x := (r[i] + y[i]) / 2
+			e = math.Max(e, math.Abs(x-r[i]))
+			r[i] = x
+		}
+		if e < 1e-15 {
+			break
+		}
+	}
+	return r, r.transpose().mul(m), true
+}
+
+// quaternion is a rotation quaternion w + xi + yj + zk.
+type quaternion [4]float64
+
+// quat converts a rotation matrix to a unit quaternion.
+func quat(m mat3) quaternion {
+	var q quaternion
+	// Choose the largest diagonal term to divide by for stability.
+	switch tr := m[0] + m[4] + m[8]; {
+	case tr > 0:
+		s := 2 * math.Sqrt(tr+1)
+		q = quaternion{s / 4, (m[7] - m[5]) / s, (m[2] - m[6]) / s, (m[3] - m[1]) / s}
+	case m[0] > m[4] && m[0] > m[8]:
+		s := 2 * math.Sqrt(1+m[0]-m[4]-m[8])
+		q = quaternion{(m[7] - m[5]) / s, s / 4, (m[1] + m[3]) / s, (m[2] + m[6]) / s}
+	case m[4] > m[8]:
+		s := 2 * math.Sqrt(1+m[4]-m[0]-m[8])
+		q = quaternion{(m[2] - m[6]) / s, (m[1] + m[3]) / s, s / 4, (m[5] + m[7]) / s}
+	default:
+		s := 2 * math.Sqrt(1+m[8]-m[0]-m[4])
+		q = quaternion{(m[3] - m[1]) / s, (m[2] + m[6]) / s, (m[5] + m[7]) / s, s / 4}
+	}
+	return q
+}
+
+// mat converts a unit quaternion to a rotation matrix.
+func (q quaternion) mat() mat3 {
+	w, x, y, z := q[0], q[1], q[2], q[3]
+	return mat3{
+		1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w),
+		2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w),
+		2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y),
+	}
+}
+
+// slerp interpolates between unit quaternions along the shorter arc.
+func slerp(a, b quaternion, t float64) quaternion {
+	d := a[0]*b[0] + a[1]*b[1] + a[2]*b[2] + a[3]*b[3]
+	if d < 0 {
+		// q and -q are the same rotation; use the one nearer a.
+		d = -d
+		for i := range b {
+			b[i] = -b[i]
+		}
+	}
+	var p, q float64
+	if d > 0.9995 {
+		// Nearly parallel. Interpolate linearly to avoid dividing by a tiny
+		// sine, then normalize.
+		p, q = 1-t, t
+	} else {
+		th := math.Acos(d)
+		s := math.Sin(th)
+		p, q = math.Sin((1-t)*th)/s, math.Sin(t*th)/s
+	}
+	var r quaternion
+	var n float64
+	for i := range r {
+		r[i] = p*a[i] + q*b[i]
+		n += r[i] * r[i]
+	}
+	n = math.Sqrt(n)
+	for i := range r {
+		r[i] /= n
+	}
+	return r
+}
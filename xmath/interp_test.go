@@ -0,0 +1,61 @@
+package xmath_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zephyrtronium/xirho/xmath"
+)
+
+func affineNear(a, b xmath.Affine) bool {
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestInterpAffineEndpoints(t *testing.T) {
+	eye := func() *xmath.Affine {
+		ax := xmath.Eye()
+		return &ax
+	}
+	cases := map[string][2]xmath.Affine{
+		"eye":     {xmath.Eye(), xmath.Eye()},
+		"rotate":  {*eye().Translate(1, 2, 3), *eye().RotZ(2).Scale(2, 3, 1)},
+		"reflect": {*eye().Scale(-1, 1, 1), *eye().RotX(1).Scale(1, -2, 1)},
+		"shear":   {{1, 0.5, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0}, {2, 0, 0, 1, 1, 1, 0, 0, 0.25, 0, 1, 0}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if r := xmath.InterpAffine(c[0], c[1], 0); !affineNear(r, c[0]) {
+				t.Errorf("wrong start: want %v, got %v", c[0], r)
+			}
+			if r := xmath.InterpAffine(c[0], c[1], 1); !affineNear(r, c[1]) {
+				t.Errorf("wrong end: want %v, got %v", c[1], r)
+			}
+		})
+	}
+}
+
+func TestInterpAffineRotation(t *testing.T) {
+	a := xmath.Eye()
+	a.Scale(2, 2, 2)
+	b := a
+	b.RotZ(math.Pi/2).Translate(4, 0, 0)
+	want := a
+	want.RotZ(math.Pi/4).Translate(2, 0, 0)
+	if r := xmath.InterpAffine(a, b, 0.5); !affineNear(r, want) {
+		t.Errorf("wrong halfway rotation: want %v, got %v", want, r)
+	}
+}
+
+func TestInterpAffineSingular(t *testing.T) {
+	a := xmath.Affine{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	b := xmath.Affine{3, 0, 0, 2, 0, 3, 0, 2, 0, 0, 0, 2}
+	want := xmath.Affine{2, 0, 0, 1, 0, 2, 0, 1, 0, 0, 0, 1}
+	if r := xmath.InterpAffine(a, b, 0.5); !affineNear(r, want) {
+		t.Errorf("wrong singular interpolation: want %v, got %v", want, r)
+	}
+}
@@ -31,6 +31,30 @@ func NewRNG() RNG {
 	return r
 }
 
+// NewSeededRNG produces an RNG whose state is derived from a seed. RNGs
+// created with the same seed produce the same sequence of values.
+func NewSeededRNG(seed uint64) RNG {
+	// Expand the seed with splitmix64, as recommended by the authors of
+	// xoshiro. splitmix64 is a bijection on its state, so its outputs from
+	// four consecutive states are never all zero.
+	r := RNG{}
+	r.w, seed = splitmix64(seed)
+	r.x, seed = splitmix64(seed)
+	r.y, seed = splitmix64(seed)
+	r.z, _ = splitmix64(seed)
+	return r
+}
+
+// splitmix64 computes one step of the splitmix64 generator, returning the
+// output and the new state.
+func splitmix64(s uint64) (r, next uint64) {
+	s += 0x9e3779b97f4a7c15
+	r = s
+	r = (r ^ r>>30) * 0xbf58476d1ce4e5b9
+	r = (r ^ r>>27) * 0x94d049bb133111eb
+	return r ^ r>>31, s
+}
+
 // Uint64 produces a 64-bit pseudo-random value.
 func (rng *RNG) Uint64() uint64 {
 	w, x, y, z := rng.w, rng.x, rng.y, rng.z
@@ -95,6 +95,28 @@ func graphFill(rng xmath.RNG, degree int) float64 {
 	return mathext.GammaIncRegComp(float64(degree*degree-1)/2, x/2)
 }
 
+func TestSeededRNG(t *testing.T) {
+	a, b := xmath.NewSeededRNG(1), xmath.NewSeededRNG(1)
+	c := xmath.NewSeededRNG(2)
+	same := 0
+	for i := 0; i < 1000; i++ {
+		x, y, z := a.Uint64(), b.Uint64(), c.Uint64()
+		if x != y {
+			t.Fatalf("same seed gave different values at step %d: %#x and %#x", i, x, y)
+		}
+		if x == z {
+			same++
+		}
+	}
+	if same != 0 {
+		t.Errorf("different seeds gave %d equal values", same)
+	}
+	z := xmath.NewSeededRNG(0)
+	if z == (xmath.RNG{}) {
+		t.Error("zero seed gave all-zero state")
+	}
+}
+
 var doNotOptimize uint64
 
 func BenchmarkUint64(b *testing.B) {